@@ -3,13 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/webhook"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/services/assembly"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/services/customer"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/services/delegation"
@@ -39,6 +44,14 @@ const (
 
 	ticketService  = "ticket"
 	supportService = "support"
+
+	// replayCommand moves all dead-lettered messages of a service back into its queue
+	replayCommand = "replay"
+	// healthCheckCommand checks the readiness of the service running in the same container
+	healthCheckCommand = "healthcheck"
+
+	// defaultManagementAddr is the address of the health endpoints if MANAGEMENT_ADDR isn't set
+	defaultManagementAddr = ":8081"
 )
 
 // Service is an interface used as a contract with the service library
@@ -47,14 +60,23 @@ type Service interface {
 }
 
 // getConfig fills the config structs with data read from environment variables
-func getConfig() *service.Config {
+// The service name defaults to the name the service was started with
+func getConfig(serviceName string) *service.Config {
+	if name := os.Getenv("SERVICE_NAME"); name != "" {
+		serviceName = name
+	}
+
 	return &service.Config{
-		Location: os.Getenv("SERVICE_LOCATION"),
+		Location:       os.Getenv("SERVICE_LOCATION"),
+		ManagementAddr: envString("MANAGEMENT_ADDR", defaultManagementAddr),
+		TopologyFile:   os.Getenv("TOPOLOGY_FILE"),
+
 		Db: db.Config{
 			Driver:   os.Getenv("DB_DRIVER"),
 			User:     os.Getenv("DB_USER"),
 			Password: os.Getenv("DB_PASSWORD"),
 			Host:     os.Getenv("DB_HOST"),
+			Name:     os.Getenv("DB_NAME"),
 		},
 		Rbmq: rbmq.Config{
 			User:         os.Getenv("RBMQ_USER"),
@@ -64,7 +86,144 @@ func getConfig() *service.Config {
 			ExchangeType: os.Getenv("RBMQ_EXCHANGE_TYPE"),
 			BindingKey:   os.Getenv("RBMQ_BINDINGKEY"),
 			ConsumerTag:  os.Getenv("RBMQ_CONSUMER_TAG"),
+			ServiceName:  serviceName,
+			Workers:      envInt("RBMQ_WORKERS", 1),
+			Prefetch:     envInt("RBMQ_PREFETCH", 0),
+			ManualAck:    envBool("RBMQ_MANUAL_ACK", false),
+			QueueName:    os.Getenv("RBMQ_QUEUE"),
+			MaxRetries:   envInt("RBMQ_MAX_RETRIES", 3),
+			RetryDelay:   envDuration("RBMQ_RETRY_DELAY", 5*time.Second),
+
+			PublisherConfirms: envBool("RBMQ_PUBLISHER_CONFIRMS", false),
+			ConfirmTimeout:    envDuration("RBMQ_CONFIRM_TIMEOUT", 5*time.Second),
+		},
+		Tracing: tracing.Config{
+			ServiceName: serviceName,
+			Exporter:    os.Getenv("TRACING_EXPORTER"),
+			Endpoint:    os.Getenv("TRACING_OTLP_ENDPOINT"),
+			File:        envString("TRACING_FILE", "traces.json"),
+		},
+	}
+}
+
+// getDelegationConfig reads the settings of the delegation service from environment variables
+func getDelegationConfig() delegation.Config {
+	return delegation.Config{
+		Strategy:          os.Getenv("DELEGATION_STRATEGY"),
+		HeartbeatInterval: envDuration("HEARTBEAT_INTERVAL", 10*time.Second),
+		HeartbeatTimeout:  envDuration("HEARTBEAT_TIMEOUT", 30*time.Second),
+		ReconcileInterval: envDuration("LOAD_RECONCILE_INTERVAL", 5*time.Minute),
+	}
+}
+
+// getFactoryConfig reads the settings of a factory from environment variables
+func getFactoryConfig() factory.Config {
+	return factory.Config{
+		Capacity:          envInt("FACTORY_CAPACITY", 0),
+		HeartbeatInterval: envDuration("HEARTBEAT_INTERVAL", 10*time.Second),
+	}
+}
+
+// getOrderConfig reads the settings of the order service from environment variables
+func getOrderConfig() order.Config {
+	return order.Config{
+		IdempotencyRetention: envDuration("IDEMPOTENCY_RETENTION", 24*time.Hour),
+		IdempotencyLease:     envDuration("IDEMPOTENCY_LEASE", time.Minute),
+		Saga: order.SagaConfig{
+			AcceptTimeout:    envDuration("ORDER_ACCEPT_TIMEOUT", 2*time.Minute),
+			PartsTimeout:     envDuration("ORDER_PARTS_TIMEOUT", 5*time.Minute),
+			AssemblyTimeout:  envDuration("ORDER_ASSEMBLY_TIMEOUT", 15*time.Minute),
+			ShippingTimeout:  envDuration("ORDER_SHIPPING_TIMEOUT", 5*time.Minute),
+			CancelTimeout:    envDuration("ORDER_CANCEL_TIMEOUT", 2*time.Minute),
+			MaxRedelegations: envInt("ORDER_MAX_REDELEGATIONS", 2),
+			CheckInterval:    envDuration("ORDER_DEADLINE_CHECK_INTERVAL", 30*time.Second),
 		},
+		Webhook: getWebhookConfig(),
+	}
+}
+
+// getWebhookConfig reads the retry policy of the webhooks of the order and ticket service from environment variables
+func getWebhookConfig() webhook.Config {
+	return webhook.Config{
+		MaxAttempts:    envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		InitialBackoff: envDuration("WEBHOOK_INITIAL_BACKOFF", 10*time.Second),
+		MaxBackoff:     envDuration("WEBHOOK_MAX_BACKOFF", time.Hour),
+		Timeout:        envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		CheckInterval:  envDuration("WEBHOOK_CHECK_INTERVAL", 5*time.Second),
+	}
+}
+
+// envString reads an environment variable and falls back to a default value if it isn't set
+func envString(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// envBool reads a boolean environment variable and falls back to a default value if it isn't set or invalid
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envInt reads an integer environment variable and falls back to a default value if it isn't set or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDuration reads a duration like "5s" from an environment variable and falls back to a default value if it isn't set or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// replayDeadLetters moves the dead-lettered messages of the service configured by the environment back into its queue
+func replayDeadLetters(logger *zap.SugaredLogger) {
+	session, err := rbmq.NewSession(getConfig(replayCommand).Rbmq, logger)
+	if err != nil {
+		logger.Fatalw("Failed to connect to RabbitMQ", "err", err)
+	}
+	defer session.Close()
+
+	replayed, err := session.ReplayDeadLetters()
+	if err != nil {
+		logger.Fatalw("Failed to replay dead letters", "replayed", replayed, "err", err)
+	}
+
+	logger.Infow("Replayed dead letters", "replayed", replayed)
+}
+
+// healthCheck requests the readiness endpoint of the service running on the same host and exits with 1 if it isn't ready
+func healthCheck() {
+	_, port, err := net.SplitHostPort(getConfig(healthCheckCommand).ManagementAddr)
+	if err != nil {
+		fmt.Println("Invalid management address:", err)
+		os.Exit(1)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%s/readyz", port))
+	if err != nil {
+		fmt.Println("Service is not reachable:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Service is not ready:", resp.Status)
+		os.Exit(1)
 	}
 }
 
@@ -72,6 +231,8 @@ func getConfig() *service.Config {
 func printServices() {
 	fmt.Println("Invalid service name. Valid service names are:")
 	fmt.Println("	[user, order, delegation, part, factory, assembly, model, shipping, kpi, ticket, support]")
+	fmt.Println("Use replay to move all dead-lettered messages of a service back into its queue")
+	fmt.Println("Use healthcheck to check the readiness of a running service")
 }
 
 func main() {
@@ -94,6 +255,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if serviceName == replayCommand {
+		replayDeadLetters(logger)
+		return
+	}
+
+	if serviceName == healthCheckCommand {
+		healthCheck()
+		return
+	}
+
 	// used to listen to ctrl+c signal
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
@@ -104,27 +275,27 @@ func main() {
 	// initialize service based on service name
 	switch serviceName {
 	case customerService:
-		serviceInstance, err = customer.New(getConfig(), messages, logger)
+		serviceInstance, err = customer.New(getConfig(serviceName), messages, logger)
 	case orderService:
-		serviceInstance, err = order.New(getConfig(), messages, logger)
+		serviceInstance, err = order.New(getConfig(serviceName), getOrderConfig(), messages, logger)
 	case delegationService:
-		serviceInstance, err = delegation.New(getConfig(), messages, logger)
+		serviceInstance, err = delegation.New(getConfig(serviceName), getDelegationConfig(), messages, logger)
 	case partService:
-		serviceInstance, err = part.New(getConfig(), messages, logger)
+		serviceInstance, err = part.New(getConfig(serviceName), messages, logger)
 	case factoryService:
-		serviceInstance, err = factory.New(getConfig(), messages, logger)
+		serviceInstance, err = factory.New(getConfig(serviceName), getFactoryConfig(), messages, logger)
 	case assemblyService:
-		serviceInstance, err = assembly.New(getConfig(), messages, logger)
+		serviceInstance, err = assembly.New(getConfig(serviceName), messages, logger)
 	case modelService:
-		serviceInstance, err = model.New(getConfig(), messages, logger)
+		serviceInstance, err = model.New(getConfig(serviceName), messages, logger)
 	case shippingService:
-		serviceInstance, err = shipping.New(getConfig(), messages, logger)
+		serviceInstance, err = shipping.New(getConfig(serviceName), messages, logger)
 	case kpiService:
-		serviceInstance, err = kpi.New(getConfig(), messages, logger)
+		serviceInstance, err = kpi.New(getConfig(serviceName), messages, logger)
 	case ticketService:
-		serviceInstance, err = ticket.New(getConfig(), messages, logger)
+		serviceInstance, err = ticket.New(getConfig(serviceName), ticket.Config{Webhook: getWebhookConfig()}, messages, logger)
 	case supportService:
-		serviceInstance, err = support.New(getConfig(), messages, logger)
+		serviceInstance, err = support.New(getConfig(serviceName), messages, logger)
 	default:
 		printServices()
 		os.Exit(1)
@@ -4,6 +4,8 @@ go 1.14
 
 require (
 	github.com/go-chi/chi v4.1.2+incompatible
+	github.com/lib/pq v1.10.9
+	github.com/prometheus/client_golang v1.11.1
 	github.com/streadway/amqp v1.0.0
 	go.mongodb.org/mongo-driver v1.3.4
 	go.uber.org/zap v1.15.0
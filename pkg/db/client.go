@@ -2,8 +2,12 @@ package db
 
 import (
 	"fmt"
+	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/memory"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/mongo"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/postgres"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 )
 
@@ -21,6 +25,7 @@ type Client interface {
 	CreateCustomer(entities.Customer) (string, error)
 	FindCustomer(string) (entities.Customer, error)
 	AllCustomers() ([]entities.Customer, error)
+	FindCustomers(query.Customers) ([]entities.Customer, int, error)
 
 	// part_crud
 	FindSupplier(string) (entities.Supplier, error)
@@ -30,26 +35,45 @@ type Client interface {
 
 	// order_crud
 	CreateOrder(entities.Order) (string, error)
-	UpdateOrderStatus(entities.Order) error
+	UpdateOrderStatus(entities.Order, string) (bool, error)
 	FindOrder(string) (entities.Order, error)
 	AllOrders() ([]entities.Order, error)
+	FindOrders(query.Orders) ([]entities.Order, int, error)
+	OverdueOrders(time.Time) ([]entities.Order, error)
+	AddStatusChange(entities.StatusChange) (string, error)
+	OrderHistory(string) ([]entities.StatusChange, error)
+	ReserveIdempotencyKey(entities.IdempotencyKey, time.Time, time.Time) (bool, error)
+	FindIdempotencyKey(string) (entities.IdempotencyKey, error)
+	CompleteIdempotencyKey(entities.IdempotencyKey) error
+	DeleteIdempotencyKey(string) error
 
 	// factory_crud
 	CreateOrderFactory(entities.Order) (string, error)
 	UpdateOrderStatusFactory(entities.Order) error
+	FindOrderFactory(string) (entities.Order, error)
 	UpdateOrderCosts(entities.Order) error
 	AggregateKPI() ([]entities.KPI, error)
+	CountOpenOrdersFactory() (int, error)
 
 	// delegation_crud
 	GetFactoryStatus(string) (entities.FactoryStatus, error)
 	UpdateFactoryStatus(entities.FactoryStatus) error
-	InitDelegationDatabase() error
+	IncrementFactoryLoad(string, int) (entities.FactoryStatus, error)
+	InitDelegationDatabase([]entities.FactoryStatus) error
+	RegisterFactory(entities.FactoryStatus) error
+	AllFactoryStatus() ([]entities.FactoryStatus, error)
+	UpdateFactorySettings(entities.FactoryStatus) error
+	CreateDelegation(entities.Delegation) (string, error)
+	CompleteDelegation(entities.Delegation) (entities.Delegation, error)
+	OpenDelegations(string) ([]entities.Delegation, error)
+	FindOpenDelegation(string) (entities.Delegation, error)
 
 	// ticker_crud
 	CreateTicket(entities.Ticket) (string, error)
 	UpdateTicket(entities.Ticket) error
 	FindTicket(string) (entities.Ticket, error)
 	AllTickets() ([]entities.Ticket, error)
+	FindTickets(query.Tickets) ([]entities.Ticket, int, error)
 
 	// kpi_crud
 	CreateKPI(entities.KPI) (string, error)
@@ -62,6 +86,18 @@ type Client interface {
 	UpdateModelPart(entities.Part) error
 	InitModelDatabase() error
 
+	// webhook_crud
+	CreateWebhook(entities.Webhook) (string, error)
+	FindWebhook(string) (entities.Webhook, error)
+	AllWebhooks() ([]entities.Webhook, error)
+	DeleteWebhook(string) error
+	CreateDelivery(entities.WebhookDelivery) (string, error)
+	UpdateDelivery(entities.WebhookDelivery) error
+	FindDelivery(string) (entities.WebhookDelivery, error)
+	WebhookDeliveries(string) ([]entities.WebhookDelivery, error)
+	DueDeliveries(time.Time) ([]entities.WebhookDelivery, error)
+
+	Ping() error
 	Close() error
 }
 
@@ -71,18 +107,27 @@ type Config struct {
 	User     string
 	Password string
 	Host     string
+	Name     string
 }
 
-// New returns a new database connection
+// New returns a new database connection, the latencies of all calls are exported as metrics
 func New(config Config) (Client, error) {
+	var client Client
+	var err error
+
 	switch config.Driver {
 	case mongoDriver:
-		return mongo.New(config.User, config.Password, config.Host)
+		client, err = mongo.New(config.User, config.Password, config.Host)
 	case postgresDriver:
-		return nil, nil
+		client, err = postgres.New(config.User, config.Password, config.Host, config.Name)
 	case memoryDriver:
-		return nil, nil
+		client = memory.New()
 	default:
 		return nil, fmt.Errorf("Unknown database driver %s", config.Driver)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return instrument(client), nil
 }
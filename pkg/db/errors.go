@@ -0,0 +1,15 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/memory"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+)
+
+// IsNotFound reports whether an error means that the requested entry doesn't exist
+// Every database driver uses its own error for missing entries, so services should use this instead of comparing errors
+func IsNotFound(err error) bool {
+	return errors.Is(err, mongodriver.ErrNoDocuments) || errors.Is(err, sql.ErrNoRows) || errors.Is(err, memory.ErrNotFound)
+}
@@ -0,0 +1,369 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+)
+
+// instrumentedClient wraps a storage client and records the latency of every call
+// If the client belongs to a traced request or message, every call is recorded as a span as well
+type instrumentedClient struct {
+	client Client
+	ctx    context.Context
+}
+
+// instrument wraps a storage client so that its latencies are exported as metrics
+func instrument(client Client) Client {
+	return &instrumentedClient{client: client}
+}
+
+// WithContext returns a storage client whose calls are traced as children of the span carried by the context
+// Clients that weren't created by New are returned unchanged
+func WithContext(ctx context.Context, client Client) Client {
+	instrumented, ok := client.(*instrumentedClient)
+	if !ok {
+		return client
+	}
+
+	return &instrumentedClient{client: instrumented.client, ctx: ctx}
+}
+
+// observe starts measuring a call, the returned function has to be called once the call returned
+func (c *instrumentedClient) observe(method string) func() {
+	start := time.Now()
+
+	var span *tracing.Span
+	if c.ctx != nil && tracing.SpanFromContext(c.ctx) != nil {
+		_, span = tracing.Start(c.ctx, "db "+method, tracing.KindClient)
+	}
+
+	return func() {
+		metrics.StorageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if span != nil {
+			span.End()
+		}
+	}
+}
+
+// customer_crud
+
+func (c *instrumentedClient) CreateCustomer(customer entities.Customer) (string, error) {
+	defer c.observe("CreateCustomer")()
+	return c.client.CreateCustomer(customer)
+}
+
+func (c *instrumentedClient) FindCustomer(id string) (entities.Customer, error) {
+	defer c.observe("FindCustomer")()
+	return c.client.FindCustomer(id)
+}
+
+func (c *instrumentedClient) AllCustomers() ([]entities.Customer, error) {
+	defer c.observe("AllCustomers")()
+	return c.client.AllCustomers()
+}
+
+func (c *instrumentedClient) FindCustomers(q query.Customers) ([]entities.Customer, int, error) {
+	defer c.observe("FindCustomers")()
+	return c.client.FindCustomers(q)
+}
+
+// part_crud
+
+func (c *instrumentedClient) FindSupplier(id string) (entities.Supplier, error) {
+	defer c.observe("FindSupplier")()
+	return c.client.FindSupplier(id)
+}
+
+func (c *instrumentedClient) UpdatePart(part entities.Part) error {
+	defer c.observe("UpdatePart")()
+	return c.client.UpdatePart(part)
+}
+
+func (c *instrumentedClient) FindPart(id int) (entities.Part, error) {
+	defer c.observe("FindPart")()
+	return c.client.FindPart(id)
+}
+
+func (c *instrumentedClient) InitPartDatabase() error {
+	defer c.observe("InitPartDatabase")()
+	return c.client.InitPartDatabase()
+}
+
+// order_crud
+
+func (c *instrumentedClient) CreateOrder(order entities.Order) (string, error) {
+	defer c.observe("CreateOrder")()
+	return c.client.CreateOrder(order)
+}
+
+func (c *instrumentedClient) UpdateOrderStatus(order entities.Order, previous string) (bool, error) {
+	defer c.observe("UpdateOrderStatus")()
+	return c.client.UpdateOrderStatus(order, previous)
+}
+
+func (c *instrumentedClient) FindOrder(id string) (entities.Order, error) {
+	defer c.observe("FindOrder")()
+	return c.client.FindOrder(id)
+}
+
+func (c *instrumentedClient) AllOrders() ([]entities.Order, error) {
+	defer c.observe("AllOrders")()
+	return c.client.AllOrders()
+}
+
+func (c *instrumentedClient) FindOrders(q query.Orders) ([]entities.Order, int, error) {
+	defer c.observe("FindOrders")()
+	return c.client.FindOrders(q)
+}
+
+// factory_crud
+
+func (c *instrumentedClient) CreateOrderFactory(order entities.Order) (string, error) {
+	defer c.observe("CreateOrderFactory")()
+	return c.client.CreateOrderFactory(order)
+}
+
+func (c *instrumentedClient) UpdateOrderStatusFactory(order entities.Order) error {
+	defer c.observe("UpdateOrderStatusFactory")()
+	return c.client.UpdateOrderStatusFactory(order)
+}
+
+func (c *instrumentedClient) UpdateOrderCosts(order entities.Order) error {
+	defer c.observe("UpdateOrderCosts")()
+	return c.client.UpdateOrderCosts(order)
+}
+
+func (c *instrumentedClient) AggregateKPI() ([]entities.KPI, error) {
+	defer c.observe("AggregateKPI")()
+	return c.client.AggregateKPI()
+}
+
+func (c *instrumentedClient) CountOpenOrdersFactory() (int, error) {
+	defer c.observe("CountOpenOrdersFactory")()
+	return c.client.CountOpenOrdersFactory()
+}
+
+// delegation_crud
+
+func (c *instrumentedClient) GetFactoryStatus(location string) (entities.FactoryStatus, error) {
+	defer c.observe("GetFactoryStatus")()
+	return c.client.GetFactoryStatus(location)
+}
+
+func (c *instrumentedClient) UpdateFactoryStatus(status entities.FactoryStatus) error {
+	defer c.observe("UpdateFactoryStatus")()
+	return c.client.UpdateFactoryStatus(status)
+}
+
+func (c *instrumentedClient) IncrementFactoryLoad(location string, delta int) (entities.FactoryStatus, error) {
+	defer c.observe("IncrementFactoryLoad")()
+	return c.client.IncrementFactoryLoad(location, delta)
+}
+
+func (c *instrumentedClient) InitDelegationDatabase(factories []entities.FactoryStatus) error {
+	defer c.observe("InitDelegationDatabase")()
+	return c.client.InitDelegationDatabase(factories)
+}
+
+func (c *instrumentedClient) RegisterFactory(status entities.FactoryStatus) error {
+	defer c.observe("RegisterFactory")()
+	return c.client.RegisterFactory(status)
+}
+
+func (c *instrumentedClient) AllFactoryStatus() ([]entities.FactoryStatus, error) {
+	defer c.observe("AllFactoryStatus")()
+	return c.client.AllFactoryStatus()
+}
+
+func (c *instrumentedClient) UpdateFactorySettings(status entities.FactoryStatus) error {
+	defer c.observe("UpdateFactorySettings")()
+	return c.client.UpdateFactorySettings(status)
+}
+
+func (c *instrumentedClient) CreateDelegation(delegation entities.Delegation) (string, error) {
+	defer c.observe("CreateDelegation")()
+	return c.client.CreateDelegation(delegation)
+}
+
+func (c *instrumentedClient) CompleteDelegation(delegation entities.Delegation) (entities.Delegation, error) {
+	defer c.observe("CompleteDelegation")()
+	return c.client.CompleteDelegation(delegation)
+}
+
+func (c *instrumentedClient) OpenDelegations(location string) ([]entities.Delegation, error) {
+	defer c.observe("OpenDelegations")()
+	return c.client.OpenDelegations(location)
+}
+
+// ticket_crud
+
+func (c *instrumentedClient) CreateTicket(ticket entities.Ticket) (string, error) {
+	defer c.observe("CreateTicket")()
+	return c.client.CreateTicket(ticket)
+}
+
+func (c *instrumentedClient) UpdateTicket(ticket entities.Ticket) error {
+	defer c.observe("UpdateTicket")()
+	return c.client.UpdateTicket(ticket)
+}
+
+func (c *instrumentedClient) FindTicket(id string) (entities.Ticket, error) {
+	defer c.observe("FindTicket")()
+	return c.client.FindTicket(id)
+}
+
+func (c *instrumentedClient) AllTickets() ([]entities.Ticket, error) {
+	defer c.observe("AllTickets")()
+	return c.client.AllTickets()
+}
+
+func (c *instrumentedClient) FindTickets(q query.Tickets) ([]entities.Ticket, int, error) {
+	defer c.observe("FindTickets")()
+	return c.client.FindTickets(q)
+}
+
+// kpi_crud
+
+func (c *instrumentedClient) CreateKPI(kpi entities.KPI) (string, error) {
+	defer c.observe("CreateKPI")()
+	return c.client.CreateKPI(kpi)
+}
+
+func (c *instrumentedClient) FindKPI(location string) ([]entities.KPI, error) {
+	defer c.observe("FindKPI")()
+	return c.client.FindKPI(location)
+}
+
+func (c *instrumentedClient) FindLastNKPI(location string, n int64) ([]entities.KPI, error) {
+	defer c.observe("FindLastNKPI")()
+	return c.client.FindLastNKPI(location, n)
+}
+
+// model_crud
+
+func (c *instrumentedClient) FindModel(id int) (entities.Model, error) {
+	defer c.observe("FindModel")()
+	return c.client.FindModel(id)
+}
+
+func (c *instrumentedClient) AllModels() ([]entities.Model, error) {
+	defer c.observe("AllModels")()
+	return c.client.AllModels()
+}
+
+func (c *instrumentedClient) UpdateModelPart(part entities.Part) error {
+	defer c.observe("UpdateModelPart")()
+	return c.client.UpdateModelPart(part)
+}
+
+func (c *instrumentedClient) InitModelDatabase() error {
+	defer c.observe("InitModelDatabase")()
+	return c.client.InitModelDatabase()
+}
+
+// webhook_crud
+
+func (c *instrumentedClient) CreateWebhook(webhook entities.Webhook) (string, error) {
+	defer c.observe("CreateWebhook")()
+	return c.client.CreateWebhook(webhook)
+}
+
+func (c *instrumentedClient) FindWebhook(id string) (entities.Webhook, error) {
+	defer c.observe("FindWebhook")()
+	return c.client.FindWebhook(id)
+}
+
+func (c *instrumentedClient) AllWebhooks() ([]entities.Webhook, error) {
+	defer c.observe("AllWebhooks")()
+	return c.client.AllWebhooks()
+}
+
+func (c *instrumentedClient) DeleteWebhook(id string) error {
+	defer c.observe("DeleteWebhook")()
+	return c.client.DeleteWebhook(id)
+}
+
+func (c *instrumentedClient) CreateDelivery(delivery entities.WebhookDelivery) (string, error) {
+	defer c.observe("CreateDelivery")()
+	return c.client.CreateDelivery(delivery)
+}
+
+func (c *instrumentedClient) UpdateDelivery(delivery entities.WebhookDelivery) error {
+	defer c.observe("UpdateDelivery")()
+	return c.client.UpdateDelivery(delivery)
+}
+
+func (c *instrumentedClient) FindDelivery(id string) (entities.WebhookDelivery, error) {
+	defer c.observe("FindDelivery")()
+	return c.client.FindDelivery(id)
+}
+
+func (c *instrumentedClient) WebhookDeliveries(webhookID string) ([]entities.WebhookDelivery, error) {
+	defer c.observe("WebhookDeliveries")()
+	return c.client.WebhookDeliveries(webhookID)
+}
+
+func (c *instrumentedClient) DueDeliveries(now time.Time) ([]entities.WebhookDelivery, error) {
+	defer c.observe("DueDeliveries")()
+	return c.client.DueDeliveries(now)
+}
+
+// Ping and Close aren't storage operations and therefore aren't measured
+
+func (c *instrumentedClient) Ping() error {
+	return c.client.Ping()
+}
+
+func (c *instrumentedClient) Close() error {
+	return c.client.Close()
+}
+
+func (c *instrumentedClient) OverdueOrders(now time.Time) ([]entities.Order, error) {
+	defer c.observe("OverdueOrders")()
+	return c.client.OverdueOrders(now)
+}
+
+func (c *instrumentedClient) FindOrderFactory(orderID string) (entities.Order, error) {
+	defer c.observe("FindOrderFactory")()
+	return c.client.FindOrderFactory(orderID)
+}
+
+func (c *instrumentedClient) FindOpenDelegation(orderID string) (entities.Delegation, error) {
+	defer c.observe("FindOpenDelegation")()
+	return c.client.FindOpenDelegation(orderID)
+}
+
+func (c *instrumentedClient) AddStatusChange(change entities.StatusChange) (string, error) {
+	defer c.observe("AddStatusChange")()
+	return c.client.AddStatusChange(change)
+}
+
+func (c *instrumentedClient) OrderHistory(orderID string) ([]entities.StatusChange, error) {
+	defer c.observe("OrderHistory")()
+	return c.client.OrderHistory(orderID)
+}
+
+func (c *instrumentedClient) ReserveIdempotencyKey(key entities.IdempotencyKey, expired, abandoned time.Time) (bool, error) {
+	defer c.observe("ReserveIdempotencyKey")()
+	return c.client.ReserveIdempotencyKey(key, expired, abandoned)
+}
+
+func (c *instrumentedClient) FindIdempotencyKey(key string) (entities.IdempotencyKey, error) {
+	defer c.observe("FindIdempotencyKey")()
+	return c.client.FindIdempotencyKey(key)
+}
+
+func (c *instrumentedClient) CompleteIdempotencyKey(key entities.IdempotencyKey) error {
+	defer c.observe("CompleteIdempotencyKey")()
+	return c.client.CompleteIdempotencyKey(key)
+}
+
+func (c *instrumentedClient) DeleteIdempotencyKey(key string) error {
+	defer c.observe("DeleteIdempotencyKey")()
+	return c.client.DeleteIdempotencyKey(key)
+}
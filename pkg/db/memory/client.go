@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// ErrNotFound is returned if no entry matches the given filter
+var ErrNotFound = errors.New("no matching entry found")
+
+// Client is an in-memory storage that implements the same methods as the database clients
+// All entries are lost as soon as the service is shut down
+type Client struct {
+	mu sync.RWMutex
+
+	customers     map[string]entities.Customer
+	orders        map[string]entities.Order
+	history       []entities.StatusChange
+	keys          map[string]entities.IdempotencyKey
+	factoryOrders map[string]entities.Order
+	factoryStatus map[string]entities.FactoryStatus
+	delegations   []entities.Delegation
+	tickets       map[string]entities.Ticket
+	kpis          []entities.KPI
+	models        map[int]entities.Model
+	parts         map[int]entities.Part
+	suppliers     map[string]entities.Supplier
+	webhooks      map[string]entities.Webhook
+	deliveries    map[string]entities.WebhookDelivery
+}
+
+// New returns a new and empty in-memory storage
+func New() *Client {
+	return &Client{
+		customers:     make(map[string]entities.Customer),
+		orders:        make(map[string]entities.Order),
+		keys:          make(map[string]entities.IdempotencyKey),
+		factoryOrders: make(map[string]entities.Order),
+		factoryStatus: make(map[string]entities.FactoryStatus),
+		tickets:       make(map[string]entities.Ticket),
+		models:        make(map[int]entities.Model),
+		parts:         make(map[int]entities.Part),
+		suppliers:     make(map[string]entities.Supplier),
+		webhooks:      make(map[string]entities.Webhook),
+		deliveries:    make(map[string]entities.WebhookDelivery),
+	}
+}
+
+// Ping always succeeds, the storage lives in the service's memory
+func (c *Client) Ping() error {
+	return nil
+}
+
+// Close is a no-op, there is no connection to shut down
+func (c *Client) Close() error {
+	return nil
+}
+
+// newObjectID generates a random id that has the same format as a mongo object id
+func newObjectID() string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"sort"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateCustomer stores a new customer and returns its generated id
+func (c *Client) CreateCustomer(customer entities.Customer) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	customer.ObjectID = newObjectID()
+	c.customers[customer.ObjectID] = customer
+
+	return customer.ObjectID, nil
+}
+
+// FindCustomer returns the customer with the given id
+func (c *Client) FindCustomer(id string) (entities.Customer, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	customer, ok := c.customers[id]
+	if !ok {
+		return entities.Customer{}, ErrNotFound
+	}
+
+	return customer, nil
+}
+
+// AllCustomers returns all stored customers
+func (c *Client) AllCustomers() ([]entities.Customer, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var customers []entities.Customer
+	for _, customer := range c.customers {
+		customers = append(customers, customer)
+	}
+
+	return customers, nil
+}
+
+// FindCustomers returns a page of the customers matching the query together with the number of all matching customers
+func (c *Client) FindCustomers(q query.Customers) ([]entities.Customer, int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var customers []entities.Customer
+	for _, customer := range c.customers {
+		if q.Country != "" && customer.Address.Country != q.Country {
+			continue
+		}
+		if !q.Created.Contains(customer.Created) {
+			continue
+		}
+		customers = append(customers, customer)
+	}
+
+	sort.Slice(customers, func(i, j int) bool {
+		compared := 0
+		switch q.Sort.Field {
+		case query.SortCreated:
+			compared = compareTimes(customers[i].Created, customers[j].Created)
+		case query.SortLastName:
+			compared = compareStrings(customers[i].LastName, customers[j].LastName)
+		}
+		return less(compared, customers[i].ObjectID, customers[j].ObjectID, q.Sort.Descending)
+	})
+
+	start, end := q.Page.Bounds(len(customers))
+	return customers[start:end], len(customers), nil
+}
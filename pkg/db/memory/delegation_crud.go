@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// GetFactoryStatus returns the current status of a factory
+func (c *Client) GetFactoryStatus(location string) (entities.FactoryStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	status, ok := c.factoryStatus[location]
+	if !ok {
+		return entities.FactoryStatus{}, ErrNotFound
+	}
+
+	return status, nil
+}
+
+// UpdateFactoryStatus sets the current load of a factory
+func (c *Client) UpdateFactoryStatus(status entities.FactoryStatus) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryStatus[status.Location]
+	if !ok {
+		return nil
+	}
+
+	stored.CurrentLoad = status.CurrentLoad
+	c.factoryStatus[status.Location] = stored
+
+	return nil
+}
+
+// InitDelegationDatabase resets the factory status to the given factories
+func (c *Client) InitDelegationDatabase(factories []entities.FactoryStatus) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.factoryStatus = make(map[string]entities.FactoryStatus)
+	for _, factory := range factories {
+		c.factoryStatus[factory.Location] = entities.FactoryStatus{
+			ObjectID:            newObjectID(),
+			Location:            factory.Location,
+			CurrentLoad:         0,
+			MaxConcurrentOrders: factory.MaxConcurrentOrders,
+		}
+	}
+
+	return nil
+}
+
+// RegisterFactory adds a factory or updates its capacity and heartbeat, the current load of a known factory is kept
+func (c *Client) RegisterFactory(status entities.FactoryStatus) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryStatus[status.Location]
+	if !ok {
+		stored = entities.FactoryStatus{
+			ObjectID: newObjectID(),
+			Location: status.Location,
+		}
+	}
+
+	stored.MaxConcurrentOrders = status.MaxConcurrentOrders
+	stored.LastHeartbeat = status.LastHeartbeat
+	c.factoryStatus[status.Location] = stored
+
+	return nil
+}
+
+// AllFactoryStatus returns the status of every registered factory
+func (c *Client) AllFactoryStatus() ([]entities.FactoryStatus, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var status []entities.FactoryStatus
+	for _, factory := range c.factoryStatus {
+		status = append(status, factory)
+	}
+
+	return status, nil
+}
+
+// CreateDelegation stores the decision that delegated an order to a factory
+func (c *Client) CreateDelegation(delegation entities.Delegation) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delegation.ObjectID = newObjectID()
+	c.delegations = append(c.delegations, delegation)
+
+	return delegation.ObjectID, nil
+}
+
+// UpdateFactorySettings sets the mode and the capacity override of a factory
+func (c *Client) UpdateFactorySettings(status entities.FactoryStatus) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryStatus[status.Location]
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.Mode = status.Mode
+	stored.CapacityOverride = status.CapacityOverride
+	c.factoryStatus[status.Location] = stored
+
+	return nil
+}
+
+// CompleteDelegation marks the open delegation of an order as completed and returns it
+// It returns ErrNotFound if the order has no open delegation, e.g. because it was already completed
+func (c *Client) CompleteDelegation(delegation entities.Delegation) (entities.Delegation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.delegations) - 1; i >= 0; i-- {
+		stored := c.delegations[i]
+		if stored.OrderID == delegation.OrderID && stored.Completed.IsZero() {
+			c.delegations[i].Completed = delegation.Completed
+			return c.delegations[i], nil
+		}
+	}
+
+	return entities.Delegation{}, ErrNotFound
+}
+
+// OpenDelegations returns all orders delegated to a factory that aren't completed yet
+func (c *Client) OpenDelegations(location string) ([]entities.Delegation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var delegations []entities.Delegation
+	for _, delegation := range c.delegations {
+		if delegation.Location == location && delegation.Completed.IsZero() {
+			delegations = append(delegations, delegation)
+		}
+	}
+
+	return delegations, nil
+}
+
+// FindOpenDelegation returns the latest delegation of an order that isn't completed yet
+func (c *Client) FindOpenDelegation(orderID string) (entities.Delegation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := len(c.delegations) - 1; i >= 0; i-- {
+		delegation := c.delegations[i]
+		if delegation.OrderID == orderID && delegation.Completed.IsZero() {
+			return delegation, nil
+		}
+	}
+
+	return entities.Delegation{}, ErrNotFound
+}
+
+// IncrementFactoryLoad changes the current load of a factory by delta and returns the updated status
+// The load is never decreased below zero
+func (c *Client) IncrementFactoryLoad(location string, delta int) (entities.FactoryStatus, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryStatus[location]
+	if !ok {
+		return entities.FactoryStatus{}, ErrNotFound
+	}
+
+	stored.CurrentLoad += delta
+	if stored.CurrentLoad < 0 {
+		stored.CurrentLoad = 0
+	}
+	c.factoryStatus[location] = stored
+
+	return stored, nil
+}
@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+func TestCompleteDelegation(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name        string
+		delegations []entities.Delegation
+		orderID     string
+		want        string
+		wantErr     error
+	}{
+		{
+			name:        "open delegation",
+			delegations: []entities.Delegation{{OrderID: "a", Location: "berlin"}},
+			orderID:     "a",
+			want:        "berlin",
+		},
+		{
+			name: "latest open delegation",
+			delegations: []entities.Delegation{
+				{OrderID: "a", Location: "berlin", Completed: now},
+				{OrderID: "a", Location: "london"},
+			},
+			orderID: "a",
+			want:    "london",
+		},
+		{
+			name:        "already completed",
+			delegations: []entities.Delegation{{OrderID: "a", Location: "berlin", Completed: now}},
+			orderID:     "a",
+			wantErr:     ErrNotFound,
+		},
+		{
+			name:        "other order",
+			delegations: []entities.Delegation{{OrderID: "b", Location: "berlin"}},
+			orderID:     "a",
+			wantErr:     ErrNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			c.delegations = append(c.delegations, test.delegations...)
+
+			delegation, err := c.CompleteDelegation(entities.Delegation{OrderID: test.orderID, Completed: now})
+			if err != test.wantErr {
+				t.Fatalf("CompleteDelegation returned %v, want %v", err, test.wantErr)
+			}
+			if delegation.Location != test.want {
+				t.Errorf("location = %q, want %q", delegation.Location, test.want)
+			}
+
+			// a second call never completes the same delegation again
+			_, err = c.CompleteDelegation(entities.Delegation{OrderID: test.orderID, Completed: now})
+			if err != ErrNotFound {
+				t.Errorf("second CompleteDelegation returned %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestIncrementFactoryLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		load  int
+		delta int
+		want  int
+	}{
+		{name: "increase", load: 1, delta: 1, want: 2},
+		{name: "decrease", load: 2, delta: -1, want: 1},
+		{name: "never below zero", load: 0, delta: -1, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			c.factoryStatus["berlin"] = entities.FactoryStatus{Location: "berlin", CurrentLoad: test.load}
+
+			status, err := c.IncrementFactoryLoad("berlin", test.delta)
+			if err != nil {
+				t.Fatalf("IncrementFactoryLoad returned %v", err)
+			}
+			if status.CurrentLoad != test.want {
+				t.Errorf("load = %d, want %d", status.CurrentLoad, test.want)
+			}
+		})
+	}
+
+	_, err := New().IncrementFactoryLoad("unknown", 1)
+	if err != ErrNotFound {
+		t.Errorf("IncrementFactoryLoad of an unknown factory returned %v, want ErrNotFound", err)
+	}
+}
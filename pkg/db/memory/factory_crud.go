@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateOrderFactory stores an order received by a factory
+// Factory orders are referenced by their order id instead of the generated object id
+func (c *Client) CreateOrderFactory(order entities.Order) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order.ObjectID = newObjectID()
+	c.factoryOrders[order.OrderID] = copyOrder(order)
+
+	return order.ObjectID, nil
+}
+
+// UpdateOrderStatusFactory updates the status of a factory order
+func (c *Client) UpdateOrderStatusFactory(order entities.Order) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryOrders[order.OrderID]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = order.Status
+	stored.LastUpdate = order.LastUpdate
+	c.factoryOrders[order.OrderID] = stored
+
+	return nil
+}
+
+// FindOrderFactory returns the factory order with the given order id
+func (c *Client) FindOrderFactory(orderID string) (entities.Order, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	order, ok := c.factoryOrders[orderID]
+	if !ok {
+		return entities.Order{}, ErrNotFound
+	}
+
+	return copyOrder(order), nil
+}
+
+// UpdateOrderCosts updates the costs of parts of a factory order
+func (c *Client) UpdateOrderCosts(order entities.Order) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.factoryOrders[order.OrderID]
+	if !ok {
+		return nil
+	}
+
+	stored.CostsOfParts = order.CostsOfParts
+	c.factoryOrders[order.OrderID] = stored
+
+	return nil
+}
+
+// AggregateKPI sums up all factory orders the same way the mongo aggregation does
+// Aborted and cancelled orders don't count towards the revenue
+// The result is empty if the factory hasn't received any orders yet
+func (c *Client) AggregateKPI() ([]entities.KPI, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.factoryOrders) == 0 {
+		return nil, nil
+	}
+
+	kpi := entities.KPI{ObjectID: "kpi"}
+	for _, order := range c.factoryOrders {
+		kpi.Total++
+		if order.Status == "shipped" {
+			kpi.CompletedOrders++
+		}
+		kpi.CostsOfParts += order.CostsOfParts
+		if order.Status != "failed" && order.Status != "cancelled" {
+			kpi.Revenue += order.Total
+		}
+	}
+
+	return []entities.KPI{kpi}, nil
+}
+
+// CountOpenOrdersFactory returns the number of orders the factory hasn't shipped, aborted or cancelled
+func (c *Client) CountOpenOrdersFactory() (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	count := 0
+	for _, order := range c.factoryOrders {
+		if order.Status != "shipped" && order.Status != "failed" && order.Status != "cancelled" {
+			count++
+		}
+	}
+
+	return count, nil
+}
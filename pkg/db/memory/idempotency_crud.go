@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// ReserveIdempotencyKey stores a new idempotency key, a stored key created before expired is replaced
+// as well as a key without response created before abandoned
+// It returns false if the key is already stored and neither expired nor abandoned
+func (c *Client) ReserveIdempotencyKey(key entities.IdempotencyKey, expired, abandoned time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.keys[key.Key]
+	pending := stored.StatusCode == 0 && stored.Created.Before(abandoned)
+	if ok && !stored.Created.Before(expired) && !pending {
+		return false, nil
+	}
+
+	c.keys[key.Key] = key
+	return true, nil
+}
+
+// FindIdempotencyKey returns the stored idempotency key
+func (c *Client) FindIdempotencyKey(key string) (entities.IdempotencyKey, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stored, ok := c.keys[key]
+	if !ok {
+		return entities.IdempotencyKey{}, ErrNotFound
+	}
+
+	return stored, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request of an idempotency key
+func (c *Client) CompleteIdempotencyKey(key entities.IdempotencyKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.keys[key.Key]
+	if !ok {
+		return nil
+	}
+
+	stored.OrderID = key.OrderID
+	stored.StatusCode = key.StatusCode
+	stored.Response = append([]byte(nil), key.Response...)
+	c.keys[key.Key] = stored
+
+	return nil
+}
+
+// DeleteIdempotencyKey removes an idempotency key so that the request can be sent again
+func (c *Client) DeleteIdempotencyKey(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.keys, key)
+	return nil
+}
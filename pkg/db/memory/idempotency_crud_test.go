@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	now := time.Now().UTC()
+	expired := now.Add(-24 * time.Hour)
+	abandoned := now.Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		stored *entities.IdempotencyKey
+		want   bool
+	}{
+		{name: "new key", want: true},
+		{
+			name:   "completed key",
+			stored: &entities.IdempotencyKey{Key: "k", StatusCode: 200, Created: now.Add(-time.Hour)},
+			want:   false,
+		},
+		{
+			name:   "expired key",
+			stored: &entities.IdempotencyKey{Key: "k", StatusCode: 200, Created: now.Add(-25 * time.Hour)},
+			want:   true,
+		},
+		{
+			name:   "pending key",
+			stored: &entities.IdempotencyKey{Key: "k", Created: now.Add(-time.Second)},
+			want:   false,
+		},
+		{
+			name:   "abandoned key",
+			stored: &entities.IdempotencyKey{Key: "k", Created: now.Add(-2 * time.Minute)},
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			if test.stored != nil {
+				c.keys["k"] = *test.stored
+			}
+
+			reserved, err := c.ReserveIdempotencyKey(entities.IdempotencyKey{Key: "k", RequestHash: "new", Created: now}, expired, abandoned)
+			if err != nil {
+				t.Fatalf("ReserveIdempotencyKey returned %v", err)
+			}
+			if reserved != test.want {
+				t.Errorf("reserved = %v, want %v", reserved, test.want)
+			}
+
+			stored, _ := c.FindIdempotencyKey("k")
+			if (stored.RequestHash == "new") != test.want {
+				t.Errorf("request hash = %q after reserving %v", stored.RequestHash, reserved)
+			}
+		})
+	}
+}
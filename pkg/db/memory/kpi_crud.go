@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"sort"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateKPI stores a new kpi entry and returns its generated id
+func (c *Client) CreateKPI(kpi entities.KPI) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kpi.ObjectID = newObjectID()
+	c.kpis = append(c.kpis, kpi)
+
+	return kpi.ObjectID, nil
+}
+
+// FindKPI returns the latest kpi of a location or the latest kpi of every location if no location is given
+func (c *Client) FindKPI(location string) ([]entities.KPI, error) {
+	if location == "" {
+		return c.latestKPIs(), nil
+	}
+	return c.FindLastNKPI(location, 1)
+}
+
+// FindLastNKPI returns the last n kpis of a location, newest first
+func (c *Client) FindLastNKPI(location string, n int64) ([]entities.KPI, error) {
+	var kpis []entities.KPI
+	for _, kpi := range c.sortedKPIs() {
+		if int64(len(kpis)) >= n {
+			break
+		}
+		if kpi.Location == location {
+			kpis = append(kpis, kpi)
+		}
+	}
+
+	return kpis, nil
+}
+
+// latestKPIs returns the newest kpi entry of each location
+func (c *Client) latestKPIs() []entities.KPI {
+	var kpis []entities.KPI
+	seen := make(map[string]bool)
+
+	for _, kpi := range c.sortedKPIs() {
+		if seen[kpi.Location] {
+			continue
+		}
+		seen[kpi.Location] = true
+		kpis = append(kpis, kpi)
+	}
+
+	return kpis
+}
+
+// sortedKPIs returns a copy of all kpi entries sorted by their creation time, newest first
+func (c *Client) sortedKPIs() []entities.KPI {
+	c.mu.RLock()
+	kpis := append([]entities.KPI(nil), c.kpis...)
+	c.mu.RUnlock()
+
+	sort.SliceStable(kpis, func(i, j int) bool {
+		return kpis[i].Created.After(kpis[j].Created)
+	})
+
+	return kpis
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"sort"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// FindModel returns the model with the given id
+func (c *Client) FindModel(id int) (entities.Model, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	model, ok := c.models[id]
+	if !ok {
+		return entities.Model{}, ErrNotFound
+	}
+
+	return copyModel(model), nil
+}
+
+// AllModels returns all stored models ordered by their id
+func (c *Client) AllModels() ([]entities.Model, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var models []entities.Model
+	for _, model := range c.models {
+		models = append(models, copyModel(model))
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
+
+// UpdateModelPart updates the price of a part in all models it is being used in
+func (c *Client) UpdateModelPart(part entities.Part) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, model := range c.models {
+		model = copyModel(model)
+		for i := range model.Parts {
+			if model.Parts[i].ID == part.ID {
+				model.Parts[i].Price = part.Price
+			}
+		}
+		c.models[id] = model
+	}
+
+	return nil
+}
+
+// InitModelDatabase resets the models to the initial values
+func (c *Client) InitModelDatabase() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.models = make(map[int]entities.Model)
+	for _, model := range seed.Models(seed.Parts(seed.Suppliers())) {
+		model.ObjectID = newObjectID()
+		c.models[model.ID] = model
+	}
+
+	return nil
+}
+
+// copyModel returns a deep copy of a model so that callers can't modify the stored entry
+func copyModel(model entities.Model) entities.Model {
+	if model.Parts != nil {
+		model.Parts = append([]entities.Part(nil), model.Parts...)
+	}
+	return model
+}
@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"reflect"
+	"testing"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// partPrices returns the prices of the parts of a model in their order
+func partPrices(model entities.Model) []int {
+	var prices []int
+	for _, part := range model.Parts {
+		prices = append(prices, part.Price)
+	}
+	return prices
+}
+
+func TestUpdateModelPart(t *testing.T) {
+	tests := []struct {
+		name   string
+		models []entities.Model
+		part   entities.Part
+		want   map[int][]int
+	}{
+		{
+			name: "part of a single model",
+			models: []entities.Model{
+				{ID: 1, Parts: []entities.Part{{ID: 10, Price: 5}, {ID: 11, Price: 7}}},
+				{ID: 2, Parts: []entities.Part{{ID: 12, Price: 3}}},
+			},
+			part: entities.Part{ID: 11, Price: 9},
+			want: map[int][]int{1: {5, 9}, 2: {3}},
+		},
+		{
+			name: "part of several models",
+			models: []entities.Model{
+				{ID: 1, Parts: []entities.Part{{ID: 10, Price: 5}, {ID: 11, Price: 7}}},
+				{ID: 2, Parts: []entities.Part{{ID: 11, Price: 7}}},
+			},
+			part: entities.Part{ID: 11, Price: 1},
+			want: map[int][]int{1: {5, 1}, 2: {1}},
+		},
+		{
+			// mongo and postgres update every occurrence as well
+			name: "part used twice within a model",
+			models: []entities.Model{
+				{ID: 1, Parts: []entities.Part{{ID: 10, Price: 5}, {ID: 11, Price: 7}, {ID: 10, Price: 5}}},
+			},
+			part: entities.Part{ID: 10, Price: 6},
+			want: map[int][]int{1: {6, 7, 6}},
+		},
+		{
+			name: "unknown part",
+			models: []entities.Model{
+				{ID: 1, Parts: []entities.Part{{ID: 10, Price: 5}}},
+			},
+			part: entities.Part{ID: 99, Price: 1},
+			want: map[int][]int{1: {5}},
+		},
+		{
+			name: "model without parts",
+			models: []entities.Model{
+				{ID: 1},
+			},
+			part: entities.Part{ID: 10, Price: 1},
+			want: map[int][]int{1: nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			for _, model := range test.models {
+				c.models[model.ID] = model
+			}
+
+			err := c.UpdateModelPart(test.part)
+			if err != nil {
+				t.Fatalf("UpdateModelPart returned %v", err)
+			}
+
+			for id, want := range test.want {
+				model, err := c.FindModel(id)
+				if err != nil {
+					t.Fatalf("FindModel(%d) returned %v", id, err)
+				}
+				if got := partPrices(model); !reflect.DeepEqual(got, want) {
+					t.Errorf("prices of model %d = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateModelPartDoesNotChangeReturnedModels(t *testing.T) {
+	c := New()
+	c.models[1] = entities.Model{ID: 1, Parts: []entities.Part{{ID: 10, Price: 5}}}
+
+	before, err := c.FindModel(1)
+	if err != nil {
+		t.Fatalf("FindModel returned %v", err)
+	}
+
+	err = c.UpdateModelPart(entities.Part{ID: 10, Price: 8})
+	if err != nil {
+		t.Fatalf("UpdateModelPart returned %v", err)
+	}
+
+	if before.Parts[0].Price != 5 {
+		t.Errorf("price of a previously returned model = %d, want 5", before.Parts[0].Price)
+	}
+}
+
+func TestFindModel(t *testing.T) {
+	c := New()
+	c.models[1] = entities.Model{ID: 1, Name: "Basic"}
+
+	model, err := c.FindModel(1)
+	if err != nil || model.Name != "Basic" {
+		t.Errorf("FindModel(1) = %v, %v, want Basic", model.Name, err)
+	}
+
+	_, err = c.FindModel(2)
+	if err != ErrNotFound {
+		t.Errorf("FindModel(2) returned %v, want ErrNotFound", err)
+	}
+}
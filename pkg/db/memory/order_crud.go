@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateOrder stores a new order and returns its generated id
+func (c *Client) CreateOrder(order entities.Order) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order.ObjectID = newObjectID()
+	c.orders[order.ObjectID] = copyOrder(order)
+
+	return order.ObjectID, nil
+}
+
+// UpdateOrderStatus updates the status, the time of the last update and the progress of an order
+// The order is only updated while its stored status is the previous status, otherwise it returns false
+func (c *Client) UpdateOrderStatus(order entities.Order, previous string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.orders[order.ObjectID]
+	if !ok || stored.Status != previous {
+		return false, nil
+	}
+
+	stored.Status = order.Status
+	stored.LastUpdate = order.LastUpdate
+	stored.Location = order.Location
+	stored.Deadline = order.Deadline
+	stored.Redelegations = order.Redelegations
+	stored.Reason = order.Reason
+	c.orders[order.ObjectID] = stored
+
+	return true, nil
+}
+
+// FindOrder returns the order with the given id
+func (c *Client) FindOrder(id string) (entities.Order, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	order, ok := c.orders[id]
+	if !ok {
+		return entities.Order{}, ErrNotFound
+	}
+
+	return copyOrder(order), nil
+}
+
+// AllOrders returns all stored orders
+func (c *Client) AllOrders() ([]entities.Order, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var orders []entities.Order
+	for _, order := range c.orders {
+		orders = append(orders, copyOrder(order))
+	}
+
+	return orders, nil
+}
+
+// OverdueOrders returns all orders whose current step wasn't finished before its deadline
+func (c *Client) OverdueOrders(now time.Time) ([]entities.Order, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var orders []entities.Order
+	for _, order := range c.orders {
+		if !order.Deadline.IsZero() && order.Deadline.Before(now) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+
+	return orders, nil
+}
+
+// AddStatusChange adds a status change to the history of an order
+func (c *Client) AddStatusChange(change entities.StatusChange) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	change.ObjectID = newObjectID()
+	c.history = append(c.history, change)
+
+	return change.ObjectID, nil
+}
+
+// OrderHistory returns all status changes of an order sorted by time
+func (c *Client) OrderHistory(orderID string) ([]entities.StatusChange, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var history []entities.StatusChange
+	for _, change := range c.history {
+		if change.OrderID == orderID {
+			history = append(history, change)
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(history[j].Timestamp)
+	})
+
+	return history, nil
+}
+
+// copyOrder returns a deep copy of an order so that callers can't modify the stored entry
+func copyOrder(order entities.Order) entities.Order {
+	if order.Items != nil {
+		order.Items = append([]int(nil), order.Items...)
+	}
+	if order.LineItems != nil {
+		order.LineItems = append([]entities.LineItem(nil), order.LineItems...)
+	}
+	return order
+}
+
+// FindOrders returns a page of the orders matching the query together with the number of all matching orders
+func (c *Client) FindOrders(q query.Orders) ([]entities.Order, int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var orders []entities.Order
+	for _, order := range c.orders {
+		if q.Customer != "" && order.Customer != q.Customer {
+			continue
+		}
+		if !query.HasStatus(q.Status, order.Status) || !q.Created.Contains(order.Created) || !q.Updated.Contains(order.LastUpdate) {
+			continue
+		}
+		orders = append(orders, copyOrder(order))
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		compared := 0
+		switch q.Sort.Field {
+		case query.SortCreated:
+			compared = compareTimes(orders[i].Created, orders[j].Created)
+		case query.SortLastUpdate:
+			compared = compareTimes(orders[i].LastUpdate, orders[j].LastUpdate)
+		}
+		return less(compared, orders[i].ObjectID, orders[j].ObjectID, q.Sort.Descending)
+	})
+
+	start, end := q.Page.Bounds(len(orders))
+	return orders[start:end], len(orders), nil
+}
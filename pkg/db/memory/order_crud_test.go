@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"testing"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      string
+		previous    string
+		wantUpdated bool
+		wantStatus  string
+	}{
+		{name: "expected status", stored: "production", previous: "production", wantUpdated: true, wantStatus: "failed"},
+		{name: "order moved on", stored: "partsdelivered", previous: "production", wantStatus: "partsdelivered"},
+		{name: "already failed", stored: "failed", previous: "production", wantStatus: "failed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			id, err := c.CreateOrder(entities.Order{Status: test.stored})
+			if err != nil {
+				t.Fatalf("CreateOrder returned %v", err)
+			}
+
+			updated, err := c.UpdateOrderStatus(entities.Order{ObjectID: id, Status: "failed", Reason: "timeout"}, test.previous)
+			if err != nil {
+				t.Fatalf("UpdateOrderStatus returned %v", err)
+			}
+			if updated != test.wantUpdated {
+				t.Errorf("updated = %v, want %v", updated, test.wantUpdated)
+			}
+
+			order, _ := c.FindOrder(id)
+			if order.Status != test.wantStatus {
+				t.Errorf("status = %s, want %s", order.Status, test.wantStatus)
+			}
+			if (order.Reason == "timeout") != test.wantUpdated {
+				t.Errorf("reason = %q after updating %v", order.Reason, updated)
+			}
+		})
+	}
+
+	updated, err := New().UpdateOrderStatus(entities.Order{ObjectID: "unknown", Status: "failed"}, "production")
+	if updated || err != nil {
+		t.Errorf("UpdateOrderStatus of an unknown order = %v, %v, want false", updated, err)
+	}
+}
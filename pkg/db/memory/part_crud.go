@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// UpdatePart updates the price of a part
+func (c *Client) UpdatePart(part entities.Part) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.parts[part.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.Price = part.Price
+	c.parts[part.ID] = stored
+
+	return nil
+}
+
+// FindPart returns the part with the given id
+func (c *Client) FindPart(id int) (entities.Part, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	part, ok := c.parts[id]
+	if !ok {
+		return entities.Part{}, ErrNotFound
+	}
+
+	return part, nil
+}
+
+// FindSupplier returns the supplier with the given id
+func (c *Client) FindSupplier(id string) (entities.Supplier, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	supplier, ok := c.suppliers[id]
+	if !ok {
+		return entities.Supplier{}, ErrNotFound
+	}
+
+	return supplier, nil
+}
+
+// InitPartDatabase resets the parts and suppliers to the initial values
+func (c *Client) InitPartDatabase() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	suppliers := seed.Suppliers()
+	for i := range suppliers {
+		suppliers[i].ObjectID = newObjectID()
+	}
+
+	c.suppliers = make(map[string]entities.Supplier)
+	for _, supplier := range suppliers {
+		c.suppliers[supplier.ObjectID] = supplier
+	}
+
+	c.parts = make(map[int]entities.Part)
+	for _, part := range seed.Parts(suppliers) {
+		part.ObjectID = newObjectID()
+		c.parts[part.ID] = part
+	}
+
+	return nil
+}
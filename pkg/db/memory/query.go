@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"time"
+)
+
+// less orders two entries by the result of comparing their sort field and by their id if the fields are equal
+// This matches the order of the other backends which always sort by the id second
+func less(compared int, idA string, idB string, descending bool) bool {
+	if compared == 0 {
+		compared = compareStrings(idA, idB)
+	}
+	if descending {
+		return compared > 0
+	}
+	return compared < 0
+}
+
+// compareTimes returns -1 if a is before b, 1 if it is after b and 0 if both are equal
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareStrings returns -1 if a is sorted before b, 1 if it is sorted after b and 0 if both are equal
+func compareStrings(a string, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
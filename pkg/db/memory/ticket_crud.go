@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"sort"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateTicket stores a new ticket and returns its generated id
+func (c *Client) CreateTicket(ticket entities.Ticket) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ticket.ObjectID = newObjectID()
+	c.tickets[ticket.ObjectID] = ticket
+
+	return ticket.ObjectID, nil
+}
+
+// UpdateTicket updates the status, the closing time and the response of a ticket
+func (c *Client) UpdateTicket(ticket entities.Ticket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.tickets[ticket.ObjectID]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = ticket.Status
+	stored.Closed = ticket.Closed
+	stored.Response = ticket.Response
+	c.tickets[ticket.ObjectID] = stored
+
+	return nil
+}
+
+// FindTicket returns the ticket with the given id
+func (c *Client) FindTicket(id string) (entities.Ticket, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ticket, ok := c.tickets[id]
+	if !ok {
+		return entities.Ticket{}, ErrNotFound
+	}
+
+	return ticket, nil
+}
+
+// AllTickets returns all stored tickets
+func (c *Client) AllTickets() ([]entities.Ticket, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var tickets []entities.Ticket
+	for _, ticket := range c.tickets {
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, nil
+}
+
+// FindTickets returns a page of the tickets matching the query together with the number of all matching tickets
+func (c *Client) FindTickets(q query.Tickets) ([]entities.Ticket, int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var tickets []entities.Ticket
+	for _, ticket := range c.tickets {
+		if q.Location != "" && ticket.Location != q.Location {
+			continue
+		}
+		if !query.HasStatus(q.Status, ticket.Status) || !q.Created.Contains(ticket.Created) {
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	sort.Slice(tickets, func(i, j int) bool {
+		compared := 0
+		switch q.Sort.Field {
+		case query.SortCreated:
+			compared = compareTimes(tickets[i].Created, tickets[j].Created)
+		case query.SortClosed:
+			compared = compareTimes(tickets[i].Closed, tickets[j].Closed)
+		}
+		return less(compared, tickets[i].ObjectID, tickets[j].ObjectID, q.Sort.Descending)
+	})
+
+	start, end := q.Page.Bounds(len(tickets))
+	return tickets[start:end], len(tickets), nil
+}
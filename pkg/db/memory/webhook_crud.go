@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// CreateWebhook stores a new webhook subscription
+func (c *Client) CreateWebhook(webhook entities.Webhook) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	webhook.ObjectID = newObjectID()
+	webhook.Events = append([]string(nil), webhook.Events...)
+	c.webhooks[webhook.ObjectID] = webhook
+
+	return webhook.ObjectID, nil
+}
+
+// FindWebhook returns the webhook with the given id
+func (c *Client) FindWebhook(id string) (entities.Webhook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	webhook, ok := c.webhooks[id]
+	if !ok {
+		return entities.Webhook{}, ErrNotFound
+	}
+
+	return webhook, nil
+}
+
+// AllWebhooks returns all webhooks, the oldest first
+func (c *Client) AllWebhooks() ([]entities.Webhook, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	webhooks := make([]entities.Webhook, 0, len(c.webhooks))
+	for _, webhook := range c.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return less(compareTimes(webhooks[i].Created, webhooks[j].Created), webhooks[i].ObjectID, webhooks[j].ObjectID, false)
+	})
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook, its deliveries are kept as log
+func (c *Client) DeleteWebhook(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(c.webhooks, id)
+	return nil
+}
+
+// CreateDelivery stores a new delivery of an event to a webhook
+func (c *Client) CreateDelivery(delivery entities.WebhookDelivery) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delivery.ObjectID = newObjectID()
+	delivery.Payload = append([]byte(nil), delivery.Payload...)
+	c.deliveries[delivery.ObjectID] = delivery
+
+	return delivery.ObjectID, nil
+}
+
+// UpdateDelivery stores the result of an attempt to deliver an event
+func (c *Client) UpdateDelivery(delivery entities.WebhookDelivery) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.deliveries[delivery.ObjectID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttempt = delivery.NextAttempt
+	stored.LastAttempt = delivery.LastAttempt
+	stored.StatusCode = delivery.StatusCode
+	stored.Error = delivery.Error
+	c.deliveries[delivery.ObjectID] = stored
+
+	return nil
+}
+
+// FindDelivery returns the delivery with the given id
+func (c *Client) FindDelivery(id string) (entities.WebhookDelivery, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	delivery, ok := c.deliveries[id]
+	if !ok {
+		return entities.WebhookDelivery{}, ErrNotFound
+	}
+
+	return delivery, nil
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, the newest first
+func (c *Client) WebhookDeliveries(webhookID string) ([]entities.WebhookDelivery, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var deliveries []entities.WebhookDelivery
+	for _, delivery := range c.deliveries {
+		if delivery.Webhook == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return less(compareTimes(deliveries[i].Created, deliveries[j].Created), deliveries[i].ObjectID, deliveries[j].ObjectID, true)
+	})
+
+	return deliveries, nil
+}
+
+// DueDeliveries returns the deliveries whose next attempt is due at the given time, the oldest attempt first
+// Deliveries without next attempt were either delivered or failed for good
+func (c *Client) DueDeliveries(now time.Time) ([]entities.WebhookDelivery, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var deliveries []entities.WebhookDelivery
+	for _, delivery := range c.deliveries {
+		if !delivery.NextAttempt.IsZero() && !delivery.NextAttempt.After(now) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return less(compareTimes(deliveries[i].NextAttempt, deliveries[j].NextAttempt), deliveries[i].ObjectID, deliveries[j].ObjectID, false)
+	})
+
+	return deliveries, nil
+}
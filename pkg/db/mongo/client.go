@@ -7,20 +7,24 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 const (
 	customerDB  = "customer"
 	customerCol = "data"
 
-	orderDB  = "order"
-	orderCol = "data"
+	orderDB    = "order"
+	orderCol   = "data"
+	historyCol = "history"
+	keysCol    = "idempotency"
 
 	factoryDB  = "factory"
 	factoryCol = "data"
 
-	delegationDB  = "delegation"
-	delegationCol = "status"
+	delegationDB   = "delegation"
+	delegationCol  = "status"
+	delegationsCol = "delegations"
 
 	ticketDB  = "ticket"
 	ticketCol = "data"
@@ -34,6 +38,10 @@ const (
 	partDB      = "parts"
 	partCol     = "data"
 	supplierCol = "data"
+
+	webhookDB   = "webhook"
+	webhookCol  = "subscriptions"
+	deliveryCol = "deliveries"
 )
 
 // Client is a wrapper for a database connection
@@ -59,6 +67,13 @@ func New(user, password, host string) (*Client, error) {
 	}, nil
 }
 
+// Ping checks whether the database server is reachable
+func (c *Client) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return c.mongoClient.Ping(ctx, readpref.Primary())
+}
+
 // Close ends current database connection
 func (c *Client) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -52,3 +53,38 @@ func (c *Client) AllCustomers() ([]entities.Customer, error) {
 
 	return customers, err
 }
+
+// customerSortFields maps the sort fields of a query to the fields of the customer documents
+var customerSortFields = map[string]string{
+	query.SortCreated:  "created",
+	query.SortLastName: "lastname",
+}
+
+// FindCustomers returns a page of the customers matching the query together with the number of all matching customers
+func (c *Client) FindCustomers(q query.Customers) ([]entities.Customer, int, error) {
+	var customers []entities.Customer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.Country != "" {
+		filter["address.country"] = q.Country
+	}
+	addRange(filter, "created", q.Created)
+
+	collection := c.mongoClient.Database(customerDB).Collection(customerCol)
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions(q.Sort, customerSortFields, q.Page))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cursor.All(ctx, &customers)
+	return customers, int(total), err
+}
@@ -7,6 +7,8 @@ import (
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // GetFactoryStatus returns the current status of a factory
@@ -39,9 +41,9 @@ func (c *Client) UpdateFactoryStatus(status entities.FactoryStatus) error {
 	return err
 }
 
-// InitDelegationDatabase initializes delegation database with values
+// InitDelegationDatabase initializes delegation database with the given factories
 // Function is called once after the storage is initialized
-func (c *Client) InitDelegationDatabase() error {
+func (c *Client) InitDelegationDatabase(factories []entities.FactoryStatus) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -50,20 +52,196 @@ func (c *Client) InitDelegationDatabase() error {
 		return err
 	}
 
-	var status []interface{}
+	if len(factories) == 0 {
+		return nil
+	}
 
-	status = append(status, entities.FactoryStatus{
-		Location:            "usa",
-		CurrentLoad:         0,
-		MaxConcurrentOrders: 10,
-	})
+	var status []interface{}
 
-	status = append(status, entities.FactoryStatus{
-		Location:            "china",
-		CurrentLoad:         0,
-		MaxConcurrentOrders: 20,
-	})
+	for _, factory := range factories {
+		status = append(status, entities.FactoryStatus{
+			Location:            factory.Location,
+			CurrentLoad:         0,
+			MaxConcurrentOrders: factory.MaxConcurrentOrders,
+		})
+	}
 
 	_, err = c.mongoClient.Database(delegationDB).Collection(delegationCol).InsertMany(ctx, status)
 	return err
 }
+
+// RegisterFactory adds a factory or updates its capacity and heartbeat, the current load of a known factory is kept
+func (c *Client) RegisterFactory(status entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.mongoClient.Database(delegationDB).Collection(delegationCol).UpdateOne(
+		ctx,
+		bson.M{"location": status.Location},
+		bson.D{
+			primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: "maxConcurrentOrders", Value: status.MaxConcurrentOrders},
+				primitive.E{Key: "lastHeartbeat", Value: status.LastHeartbeat}},
+			},
+			primitive.E{Key: "$setOnInsert", Value: bson.D{
+				primitive.E{Key: "currentLoad", Value: 0}},
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// AllFactoryStatus returns the status of every registered factory
+func (c *Client) AllFactoryStatus() ([]entities.FactoryStatus, error) {
+	var status []entities.FactoryStatus
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(delegationDB).Collection(delegationCol).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &status)
+
+	return status, err
+}
+
+// CreateDelegation stores the decision that delegated an order to a factory
+func (c *Client) CreateDelegation(delegation entities.Delegation) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.mongoClient.Database(delegationDB).Collection(delegationsCol).InsertOne(ctx, delegation)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// UpdateFactorySettings sets the mode and the capacity override of a factory
+// It returns mongo.ErrNoDocuments if the factory isn't registered
+func (c *Client) UpdateFactorySettings(status entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.mongoClient.Database(delegationDB).Collection(delegationCol).UpdateOne(
+		ctx,
+		bson.M{"location": status.Location},
+		bson.D{
+			primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: "mode", Value: status.Mode},
+				primitive.E{Key: "capacityOverride", Value: status.CapacityOverride}},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// CompleteDelegation atomically marks the open delegation of an order as completed and returns it
+// It returns mongo.ErrNoDocuments if the order has no open delegation, e.g. because it was already completed
+func (c *Client) CompleteDelegation(delegation entities.Delegation) (entities.Delegation, error) {
+	completed := entities.Delegation{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := c.mongoClient.Database(delegationDB).Collection(delegationsCol).FindOneAndUpdate(
+		ctx,
+		bson.M{"orderID": delegation.OrderID, "completed": bson.M{"$exists": false}},
+		bson.D{
+			primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: "completed", Value: delegation.Completed}},
+			},
+		},
+		options.FindOneAndUpdate().SetSort(bson.M{"created": -1}).SetReturnDocument(options.After),
+	)
+	err := result.Decode(&completed)
+
+	return completed, err
+}
+
+// OpenDelegations returns all orders delegated to a factory that aren't completed yet
+func (c *Client) OpenDelegations(location string) ([]entities.Delegation, error) {
+	var delegations []entities.Delegation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(delegationDB).Collection(delegationsCol).Find(
+		ctx,
+		bson.M{"location": location, "completed": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.M{"created": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &delegations)
+
+	return delegations, err
+}
+
+// FindOpenDelegation returns the latest delegation of an order that isn't completed yet
+func (c *Client) FindOpenDelegation(orderID string) (entities.Delegation, error) {
+	delegation := entities.Delegation{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := c.mongoClient.Database(delegationDB).Collection(delegationsCol).FindOne(
+		ctx,
+		bson.M{"orderID": orderID, "completed": bson.M{"$exists": false}},
+		options.FindOne().SetSort(bson.M{"created": -1}),
+	)
+	err := result.Decode(&delegation)
+
+	return delegation, err
+}
+
+// IncrementFactoryLoad atomically changes the current load of a factory by delta and returns the updated status
+// The load is never decreased below zero
+func (c *Client) IncrementFactoryLoad(location string, delta int) (entities.FactoryStatus, error) {
+	status := entities.FactoryStatus{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"location": location}
+	if delta < 0 {
+		filter["currentLoad"] = bson.M{"$gte": -delta}
+	}
+
+	result := c.mongoClient.Database(delegationDB).Collection(delegationCol).FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.D{
+			primitive.E{Key: "$inc", Value: bson.D{
+				primitive.E{Key: "currentLoad", Value: delta}},
+			},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	err := result.Decode(&status)
+	if err == mongo.ErrNoDocuments && delta < 0 {
+		// the load is already lower than the decrement, it is set to zero instead
+		err = c.UpdateFactoryStatus(entities.FactoryStatus{Location: location, CurrentLoad: 0})
+		if err != nil {
+			return status, err
+		}
+		return c.GetFactoryStatus(location)
+	}
+
+	return status, err
+}
@@ -60,6 +60,7 @@ func (c *Client) UpdateOrderCosts(order entities.Order) error {
 }
 
 // AggregateKPI notifies KPI service of current factory load
+// The revenue only contains orders that weren't aborted or cancelled
 // Function is called cyclic with a timer to simulate multiple KPI exchanges per day
 func (c *Client) AggregateKPI() ([]entities.KPI, error) {
 	var kpis []entities.KPI
@@ -97,6 +98,15 @@ func (c *Client) AggregateKPI() ([]entities.KPI, error) {
 					},
 				}},
 				primitive.E{Key: "costsOfParts", Value: bson.M{"$sum": "$costsOfParts"}},
+				primitive.E{Key: "revenue", Value: bson.M{
+					"$sum": bson.M{
+						"$cond": bson.A{
+							bson.M{"$in": bson.A{"$status", bson.A{"failed", "cancelled"}}},
+							0,
+							"$total",
+						},
+					},
+				}},
 			},
 		},
 	}
@@ -109,3 +119,28 @@ func (c *Client) AggregateKPI() ([]entities.KPI, error) {
 	err = cursor.All(ctx, &kpis)
 	return kpis, err
 }
+
+// CountOpenOrdersFactory returns the number of orders the factory hasn't shipped, aborted or cancelled
+func (c *Client) CountOpenOrdersFactory() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := c.mongoClient.Database(factoryDB).Collection(factoryCol).CountDocuments(
+		ctx,
+		bson.M{"status": bson.M{"$nin": bson.A{"shipped", "failed", "cancelled"}}},
+	)
+	return int(count), err
+}
+
+// FindOrderFactory returns the order with the given order id from the factory database
+func (c *Client) FindOrderFactory(orderID string) (entities.Order, error) {
+	order := entities.Order{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := c.mongoClient.Database(factoryDB).Collection(factoryCol).FindOne(ctx, bson.M{"orderID": orderID})
+	err := result.Decode(&order)
+
+	return order, err
+}
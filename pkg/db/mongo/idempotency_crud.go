@@ -0,0 +1,96 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the error code mongo uses when a write violates a unique index
+const duplicateKeyCode = 11000
+
+// ReserveIdempotencyKey stores a new idempotency key, a stored key created before expired is replaced
+// as well as a key without response created before abandoned
+// It returns false if the key is already stored and neither expired nor abandoned
+func (c *Client) ReserveIdempotencyKey(key entities.IdempotencyKey, expired, abandoned time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the filter only matches expired and abandoned keys, for keys that are still valid the upsert fails because the id exists
+	_, err := c.mongoClient.Database(orderDB).Collection(keysCol).ReplaceOne(
+		ctx,
+		bson.M{"_id": key.Key, "$or": bson.A{
+			bson.M{"created": bson.M{"$lt": expired}},
+			bson.M{"created": bson.M{"$lt": abandoned}, "statusCode": bson.M{"$in": bson.A{nil, 0}}},
+		}},
+		key,
+		options.Replace().SetUpsert(true),
+	)
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// FindIdempotencyKey returns the stored idempotency key
+func (c *Client) FindIdempotencyKey(key string) (entities.IdempotencyKey, error) {
+	stored := entities.IdempotencyKey{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := c.mongoClient.Database(orderDB).Collection(keysCol).FindOne(ctx, bson.M{"_id": key})
+	err := result.Decode(&stored)
+
+	return stored, err
+}
+
+// CompleteIdempotencyKey stores the response to the request of an idempotency key
+func (c *Client) CompleteIdempotencyKey(key entities.IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.mongoClient.Database(orderDB).Collection(keysCol).UpdateOne(
+		ctx,
+		bson.M{"_id": key.Key},
+		bson.D{
+			primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: "orderID", Value: key.OrderID},
+				primitive.E{Key: "statusCode", Value: key.StatusCode},
+				primitive.E{Key: "response", Value: key.Response}},
+			},
+		},
+	)
+	return err
+}
+
+// DeleteIdempotencyKey removes an idempotency key so that the request can be sent again
+func (c *Client) DeleteIdempotencyKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.mongoClient.Database(orderDB).Collection(keysCol).DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// isDuplicateKey checks whether a write failed because a document with the same id exists
+func isDuplicateKey(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == duplicateKeyCode
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // FindModel finds a model specified by its ID
@@ -43,12 +45,16 @@ func (c *Client) UpdateModelPart(part entities.Part) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// the positional operator $ would only update the first occurrence of the part within a model
 	_, err := c.mongoClient.Database(modelDB).Collection(modelCol).UpdateMany(
 		ctx,
 		bson.M{"parts.id": part.ID},
 		bson.M{
-			"$set": bson.M{"parts.$.price": part.Price},
+			"$set": bson.M{"parts.$[part].price": part.Price},
 		},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"part.id": part.ID}},
+		}),
 	)
 	return err
 }
@@ -64,90 +70,12 @@ func (c *Client) InitModelDatabase() error {
 		return err
 	}
 
+	// the seed data is shared with the other storage backends
 	var data []interface{}
-
-	suppliers := []entities.Supplier{
-		{
-			Name: "electroStuff.com",
-			Address: entities.Address{
-				Country: "Germany",
-				City:    "Reinheim",
-				ZIP:     64354,
-				Address: "Anne-Frank-Straße 23",
-			},
-		},
-		{
-			Name: "coolMechanics.com",
-			Address: entities.Address{
-				Country: "Denmark",
-				City:    "Anaago",
-				ZIP:     33674,
-				Address: "Bjutsche 7",
-			},
-		},
-	}
-
-	parts := []entities.Part{
-		{
-			Price:    139,
-			ID:       1,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    53,
-			ID:       2,
-			Supplier: suppliers[1],
-		},
-		{
-			Price:    18,
-			ID:       3,
-			Supplier: suppliers[1],
-		},
-		{
-			Price:    223,
-			ID:       4,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    140,
-			ID:       5,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    98,
-			ID:       6,
-			Supplier: suppliers[1],
-		},
+	for _, model := range seed.Models(seed.Parts(seed.Suppliers())) {
+		data = append(data, model)
 	}
 
-	data = append(data, entities.Model{
-		Name:         "UltraCool9000",
-		ID:           1,
-		AssemblyTime: 10,
-		Parts:        []entities.Part{parts[0], parts[2], parts[5]},
-	})
-
-	data = append(data, entities.Model{
-		Name:         "IcyX",
-		ID:           2,
-		AssemblyTime: 7,
-		Parts:        []entities.Part{parts[0], parts[1], parts[4], parts[1], parts[3]},
-	})
-
-	data = append(data, entities.Model{
-		Name:         "Chiller",
-		ID:           3,
-		AssemblyTime: 15,
-		Parts:        []entities.Part{parts[0], parts[2], parts[2], parts[1], parts[3]},
-	})
-
-	data = append(data, entities.Model{
-		Name:         "CoolBoy",
-		ID:           4,
-		AssemblyTime: 8,
-		Parts:        []entities.Part{parts[0], parts[2], parts[4], parts[5]},
-	})
-
 	_, err = c.mongoClient.Database(modelDB).Collection(modelCol).InsertMany(ctx, data)
 	return err
 }
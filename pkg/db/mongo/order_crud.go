@@ -4,9 +4,11 @@ import (
 	"context"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // CreateOrder reates order in order database
@@ -21,25 +23,32 @@ func (c *Client) CreateOrder(order entities.Order) (string, error) {
 }
 
 // UpdateOrderStatus status updates the status of a given order
-// Status are updated after every manufactoring, assembling and shipping step
-func (c *Client) UpdateOrderStatus(order entities.Order) error {
+// Status are updated after every manufactoring, assembling and shipping step together with the deadline of the next step
+// The order is only updated while its stored status is the previous status, otherwise it returns false
+func (c *Client) UpdateOrderStatus(order entities.Order, previous string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	objectID, _ := primitive.ObjectIDFromHex(order.ObjectID)
-	_, err := c.mongoClient.Database(orderDB).Collection(orderCol).UpdateOne(
+	result, err := c.mongoClient.Database(orderDB).Collection(orderCol).UpdateOne(
 		ctx,
-		bson.M{"_id": objectID},
+		bson.M{"_id": objectID, "status": previous},
 		bson.D{
 			primitive.E{Key: "$set", Value: bson.D{
-				primitive.E{Key: "status", Value: order.Status}},
-			},
-			primitive.E{Key: "$set", Value: bson.D{
-				primitive.E{Key: "lastUpdate", Value: order.LastUpdate}},
+				primitive.E{Key: "status", Value: order.Status},
+				primitive.E{Key: "lastUpdate", Value: order.LastUpdate},
+				primitive.E{Key: "location", Value: order.Location},
+				primitive.E{Key: "deadline", Value: order.Deadline},
+				primitive.E{Key: "redelegations", Value: order.Redelegations},
+				primitive.E{Key: "reason", Value: order.Reason}},
 			},
 		},
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
 }
 
 // FindOrder returns the order with the given ID from the order database
@@ -73,3 +82,95 @@ func (c *Client) AllOrders() ([]entities.Order, error) {
 
 	return orders, err
 }
+
+// OverdueOrders returns all orders whose current step wasn't finished before its deadline
+func (c *Client) OverdueOrders(now time.Time) ([]entities.Order, error) {
+	var orders []entities.Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// finished orders have a zero deadline which has to be excluded
+	cursor, err := c.mongoClient.Database(orderDB).Collection(orderCol).Find(
+		ctx,
+		bson.M{"deadline": bson.M{"$gt": time.Time{}, "$lt": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &orders)
+
+	return orders, err
+}
+
+// AddStatusChange adds a status change to the history of an order
+func (c *Client) AddStatusChange(change entities.StatusChange) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.mongoClient.Database(orderDB).Collection(historyCol).InsertOne(ctx, change)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// OrderHistory returns all status changes of an order sorted by time
+func (c *Client) OrderHistory(orderID string) ([]entities.StatusChange, error) {
+	var history []entities.StatusChange
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(orderDB).Collection(historyCol).Find(
+		ctx,
+		bson.M{"orderID": orderID},
+		options.Find().SetSort(bson.M{"timestamp": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &history)
+
+	return history, err
+}
+
+// orderSortFields maps the sort fields of a query to the fields of the order documents
+var orderSortFields = map[string]string{
+	query.SortCreated:    "created",
+	query.SortLastUpdate: "lastUpdate",
+}
+
+// FindOrders returns a page of the orders matching the query together with the number of all matching orders
+func (c *Client) FindOrders(q query.Orders) ([]entities.Order, int, error) {
+	var orders []entities.Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.Customer != "" {
+		filter["customer"] = q.Customer
+	}
+	addStatus(filter, q.Status)
+	addRange(filter, "created", q.Created)
+	addRange(filter, "lastUpdate", q.Updated)
+
+	collection := c.mongoClient.Database(orderDB).Collection(orderCol)
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions(q.Sort, orderSortFields, q.Page))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cursor.All(ctx, &orders)
+	return orders, int(total), err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -70,74 +71,12 @@ func (c *Client) InitPartDatabase() error {
 		return err
 	}
 
+	// the seed data is shared with the other storage backends
 	var data []interface{}
-
-	suppliers := []entities.Supplier{
-		{
-			Name: "electroStuff.com",
-			Address: entities.Address{
-				Country: "Germany",
-				City:    "Reinheim",
-				ZIP:     64354,
-				Address: "Anne-Frank-Straße 23",
-			},
-		},
-		{
-			Name: "coolMechanics.com",
-			Address: entities.Address{
-				Country: "Denmark",
-				City:    "Anaago",
-				ZIP:     33674,
-				Address: "Bjutsche 7",
-			},
-		},
+	for _, part := range seed.Parts(seed.Suppliers()) {
+		data = append(data, part)
 	}
 
-	parts := []entities.Part{
-		{
-			Price:    139,
-			ID:       1,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    53,
-			ID:       2,
-			Supplier: suppliers[1],
-		},
-		{
-			Price:    18,
-			ID:       3,
-			Supplier: suppliers[1],
-		},
-		{
-			Price:    223,
-			ID:       4,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    140,
-			ID:       5,
-			Supplier: suppliers[0],
-		},
-		{
-			Price:    98,
-			ID:       6,
-			Supplier: suppliers[1],
-		},
-	}
-
-	data = append(data, parts[0])
-
-	data = append(data, parts[1])
-
-	data = append(data, parts[2])
-
-	data = append(data, parts[3])
-
-	data = append(data, parts[4])
-
-	data = append(data, parts[5])
-
 	_, err = c.mongoClient.Database(partDB).Collection(partCol).InsertMany(ctx, data)
 	return err
 }
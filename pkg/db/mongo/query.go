@@ -0,0 +1,53 @@
+package mongo
+
+import (
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// addRange limits a time field of a filter to a time range
+func addRange(filter bson.M, field string, r query.TimeRange) {
+	limits := bson.M{}
+	if !r.From.IsZero() {
+		limits["$gte"] = r.From
+	}
+	if !r.To.IsZero() {
+		limits["$lt"] = r.To
+	}
+	if len(limits) > 0 {
+		filter[field] = limits
+	}
+}
+
+// addStatus limits the status of a filter to a list of statuses
+func addStatus(filter bson.M, status []string) {
+	if len(status) > 0 {
+		filter["status"] = bson.M{"$in": status}
+	}
+}
+
+// findOptions returns the sort order and the page of a query, unknown sort fields sort by id
+func findOptions(sort query.Sort, fields map[string]string, page query.Page) *options.FindOptions {
+	direction := 1
+	if sort.Descending {
+		direction = -1
+	}
+
+	order := bson.D{}
+	if field, ok := fields[sort.Field]; ok {
+		order = append(order, primitive.E{Key: field, Value: direction})
+	}
+	order = append(order, primitive.E{Key: "_id", Value: direction})
+
+	opts := options.Find().SetSort(order)
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+	if page.Offset > 0 {
+		opts.SetSkip(int64(page.Offset))
+	}
+
+	return opts
+}
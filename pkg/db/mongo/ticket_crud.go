@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -74,3 +75,39 @@ func (c *Client) AllTickets() ([]entities.Ticket, error) {
 
 	return tickets, err
 }
+
+// ticketSortFields maps the sort fields of a query to the fields of the ticket documents
+var ticketSortFields = map[string]string{
+	query.SortCreated: "created",
+	query.SortClosed:  "closed",
+}
+
+// FindTickets returns a page of the tickets matching the query together with the number of all matching tickets
+func (c *Client) FindTickets(q query.Tickets) ([]entities.Ticket, int, error) {
+	var tickets []entities.Ticket
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if q.Location != "" {
+		filter["location"] = q.Location
+	}
+	addStatus(filter, q.Status)
+	addRange(filter, "created", q.Created)
+
+	collection := c.mongoClient.Database(ticketDB).Collection(ticketCol)
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions(q.Sort, ticketSortFields, q.Page))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = cursor.All(ctx, &tickets)
+	return tickets, int(total), err
+}
@@ -0,0 +1,180 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateWebhook stores a new webhook subscription
+func (c *Client) CreateWebhook(webhook entities.Webhook) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.mongoClient.Database(webhookDB).Collection(webhookCol).InsertOne(ctx, webhook)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// FindWebhook returns the webhook with the given id
+func (c *Client) FindWebhook(id string) (entities.Webhook, error) {
+	webhook := entities.Webhook{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	result := c.mongoClient.Database(webhookDB).Collection(webhookCol).FindOne(ctx, bson.M{"_id": objectID})
+	err := result.Decode(&webhook)
+
+	return webhook, err
+}
+
+// AllWebhooks returns all webhooks, the oldest first
+func (c *Client) AllWebhooks() ([]entities.Webhook, error) {
+	var webhooks []entities.Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(webhookDB).Collection(webhookCol).Find(
+		ctx,
+		bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &webhooks)
+
+	return webhooks, err
+}
+
+// DeleteWebhook removes a webhook, its deliveries are kept as log
+func (c *Client) DeleteWebhook(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	result, err := c.mongoClient.Database(webhookDB).Collection(webhookCol).DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// CreateDelivery stores a new delivery of an event to a webhook
+func (c *Client) CreateDelivery(delivery entities.WebhookDelivery) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.mongoClient.Database(webhookDB).Collection(deliveryCol).InsertOne(ctx, delivery)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// UpdateDelivery stores the result of an attempt to deliver an event
+// Times that are zero are removed, so delivered and failed deliveries don't match DueDeliveries anymore
+func (c *Client) UpdateDelivery(delivery entities.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.D{
+		primitive.E{Key: "status", Value: delivery.Status},
+		primitive.E{Key: "attempts", Value: delivery.Attempts},
+		primitive.E{Key: "lastAttempt", Value: delivery.LastAttempt},
+		primitive.E{Key: "statusCode", Value: delivery.StatusCode},
+		primitive.E{Key: "error", Value: delivery.Error},
+	}
+	unset := bson.M{}
+	if delivery.NextAttempt.IsZero() {
+		unset["nextAttempt"] = ""
+	} else {
+		set = append(set, primitive.E{Key: "nextAttempt", Value: delivery.NextAttempt})
+	}
+
+	update := bson.D{primitive.E{Key: "$set", Value: set}}
+	if len(unset) > 0 {
+		update = append(update, primitive.E{Key: "$unset", Value: unset})
+	}
+
+	objectID, _ := primitive.ObjectIDFromHex(delivery.ObjectID)
+	_, err := c.mongoClient.Database(webhookDB).Collection(deliveryCol).UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	return err
+}
+
+// FindDelivery returns the delivery with the given id
+func (c *Client) FindDelivery(id string) (entities.WebhookDelivery, error) {
+	delivery := entities.WebhookDelivery{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, _ := primitive.ObjectIDFromHex(id)
+
+	result := c.mongoClient.Database(webhookDB).Collection(deliveryCol).FindOne(ctx, bson.M{"_id": objectID})
+	err := result.Decode(&delivery)
+
+	return delivery, err
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, the newest first
+func (c *Client) WebhookDeliveries(webhookID string) ([]entities.WebhookDelivery, error) {
+	var deliveries []entities.WebhookDelivery
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(webhookDB).Collection(deliveryCol).Find(
+		ctx,
+		bson.M{"webhook": webhookID},
+		options.Find().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &deliveries)
+
+	return deliveries, err
+}
+
+// DueDeliveries returns the deliveries whose next attempt is due at the given time, the oldest attempt first
+// Deliveries without next attempt were either delivered or failed for good
+func (c *Client) DueDeliveries(now time.Time) ([]entities.WebhookDelivery, error) {
+	var deliveries []entities.WebhookDelivery
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := c.mongoClient.Database(webhookDB).Collection(deliveryCol).Find(
+		ctx,
+		bson.M{"nextAttempt": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "nextAttempt", Value: 1}, {Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &deliveries)
+
+	return deliveries, err
+}
@@ -0,0 +1,274 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	// registers the postgres driver for database/sql
+	_ "github.com/lib/pq"
+)
+
+// schema contains the statements used to create all tables
+// Every service uses its own database, so each service only fills the tables it needs
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS customers (
+		id        BIGSERIAL PRIMARY KEY,
+		firstname TEXT NOT NULL DEFAULT '',
+		lastname  TEXT NOT NULL DEFAULT '',
+		country   TEXT NOT NULL DEFAULT '',
+		city      TEXT NOT NULL DEFAULT '',
+		zip       INTEGER NOT NULL DEFAULT 0,
+		address   TEXT NOT NULL DEFAULT '',
+		created   TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS orders (
+		id             BIGSERIAL PRIMARY KEY,
+		customer       TEXT NOT NULL DEFAULT '',
+		status         TEXT NOT NULL DEFAULT '',
+		items          INTEGER[] NOT NULL DEFAULT '{}',
+		created        TIMESTAMPTZ NOT NULL,
+		last_update    TIMESTAMPTZ NOT NULL,
+		costs_of_parts INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS order_history (
+		id        BIGSERIAL PRIMARY KEY,
+		order_id  TEXT NOT NULL,
+		status    TEXT NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL,
+		location  TEXT NOT NULL DEFAULT '',
+		source    TEXT NOT NULL DEFAULT '',
+		reason    TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS order_history_order ON order_history (order_id, timestamp)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key          TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		order_id     TEXT NOT NULL DEFAULT '',
+		status_code  INTEGER NOT NULL DEFAULT 0,
+		response     BYTEA,
+		created      TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS factory_orders (
+		id             BIGSERIAL PRIMARY KEY,
+		order_id       TEXT NOT NULL UNIQUE,
+		customer       TEXT NOT NULL DEFAULT '',
+		status         TEXT NOT NULL DEFAULT '',
+		items          INTEGER[] NOT NULL DEFAULT '{}',
+		created        TIMESTAMPTZ NOT NULL,
+		last_update    TIMESTAMPTZ NOT NULL,
+		costs_of_parts INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS factory_status (
+		id                    BIGSERIAL PRIMARY KEY,
+		location              TEXT NOT NULL UNIQUE,
+		current_load          INTEGER NOT NULL DEFAULT 0,
+		max_concurrent_orders INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS delegations (
+		id       BIGSERIAL PRIMARY KEY,
+		order_id TEXT NOT NULL,
+		location TEXT NOT NULL,
+		strategy TEXT NOT NULL DEFAULT '',
+		reason   TEXT NOT NULL DEFAULT '',
+		created  TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS delegations_location ON delegations (location)`,
+	`CREATE TABLE IF NOT EXISTS tickets (
+		id       BIGSERIAL PRIMARY KEY,
+		created  TIMESTAMPTZ NOT NULL,
+		closed   TIMESTAMPTZ NOT NULL,
+		status   TEXT NOT NULL DEFAULT '',
+		text     TEXT NOT NULL DEFAULT '',
+		response TEXT NOT NULL DEFAULT '',
+		location TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS kpis (
+		id                BIGSERIAL PRIMARY KEY,
+		created           TIMESTAMPTZ NOT NULL,
+		location          TEXT NOT NULL,
+		incomplete_orders INTEGER NOT NULL DEFAULT 0,
+		completed_orders  INTEGER NOT NULL DEFAULT 0,
+		total             INTEGER NOT NULL DEFAULT 0,
+		costs_of_parts    INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS kpis_location_created ON kpis (location, created DESC)`,
+	`CREATE TABLE IF NOT EXISTS suppliers (
+		id      BIGSERIAL PRIMARY KEY,
+		name    TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT '',
+		city    TEXT NOT NULL DEFAULT '',
+		zip     INTEGER NOT NULL DEFAULT 0,
+		address TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE IF NOT EXISTS parts (
+		id       INTEGER PRIMARY KEY,
+		price    INTEGER NOT NULL DEFAULT 0,
+		supplier BIGINT REFERENCES suppliers (id) ON DELETE SET NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS models (
+		id            INTEGER PRIMARY KEY,
+		name          TEXT NOT NULL DEFAULT '',
+		assembly_time INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS model_parts (
+		model            INTEGER NOT NULL REFERENCES models (id) ON DELETE CASCADE,
+		position         INTEGER NOT NULL,
+		part             INTEGER NOT NULL,
+		price            INTEGER NOT NULL DEFAULT 0,
+		supplier_name    TEXT NOT NULL DEFAULT '',
+		supplier_country TEXT NOT NULL DEFAULT '',
+		supplier_city    TEXT NOT NULL DEFAULT '',
+		supplier_zip     INTEGER NOT NULL DEFAULT 0,
+		supplier_address TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (model, position)
+	)`,
+	`CREATE INDEX IF NOT EXISTS model_parts_part ON model_parts (part)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id      BIGSERIAL PRIMARY KEY,
+		url     TEXT NOT NULL,
+		events  TEXT[] NOT NULL DEFAULT '{}',
+		secret  TEXT NOT NULL,
+		created TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id            BIGSERIAL PRIMARY KEY,
+		webhook       TEXT NOT NULL,
+		event         TEXT NOT NULL,
+		payload       JSONB NOT NULL,
+		status        TEXT NOT NULL,
+		attempts      INTEGER NOT NULL DEFAULT 0,
+		next_attempt  TIMESTAMPTZ,
+		last_attempt  TIMESTAMPTZ,
+		status_code   INTEGER NOT NULL DEFAULT 0,
+		error         TEXT NOT NULL DEFAULT '',
+		redelivery_of TEXT NOT NULL DEFAULT '',
+		created       TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook ON webhook_deliveries (webhook, created DESC)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt) WHERE next_attempt IS NOT NULL`,
+
+	// columns added after the tables were first created
+	`ALTER TABLE factory_status ADD COLUMN IF NOT EXISTS last_heartbeat TIMESTAMPTZ`,
+	`ALTER TABLE factory_status ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE factory_status ADD COLUMN IF NOT EXISTS capacity_override INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE delegations ADD COLUMN IF NOT EXISTS completed TIMESTAMPTZ`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS location TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS redelegations INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS idempotency_key TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS line_items JSONB NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS total INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE factory_orders ADD COLUMN IF NOT EXISTS line_items JSONB NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE factory_orders ADD COLUMN IF NOT EXISTS total INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE kpis ADD COLUMN IF NOT EXISTS revenue INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE models ADD COLUMN IF NOT EXISTS price INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS orders_deadline ON orders (deadline) WHERE deadline IS NOT NULL`,
+}
+
+// Client is a wrapper for a database connection
+type Client struct {
+	db *sql.DB
+}
+
+// New connects to the database and creates all missing tables
+func New(user, password, host, database string) (*Client, error) {
+	if database == "" {
+		database = "postgres"
+	}
+
+	url := fmt.Sprintf("postgres://%s:%s@%s:5432/%s?sslmode=disable", user, password, host, database)
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{db: db}
+
+	// the database container might not accept connections yet, so wait a little before giving up
+	for attempt := 1; ; attempt++ {
+		err = client.createSchema()
+		if err == nil || attempt == 10 {
+			break
+		}
+		time.Sleep(3 * time.Second)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// createSchema creates all tables and indices that don't exist yet
+func (c *Client) createSchema() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	for _, statement := range schema {
+		_, err := c.db.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping checks whether the database server is reachable
+func (c *Client) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return c.db.PingContext(ctx)
+}
+
+// Close ends current database connection
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// parseID converts an object id to the numeric primary key
+// Ids that can't be parsed can't exist in the database and are therefore reported as missing rows
+func parseID(id string) (int64, error) {
+	key, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+// formatID converts a numeric primary key to an object id
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// nullTime converts a time so that it can be written to a nullable column, zero times are stored as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// toInt64s converts a list of ints so that it can be written to an array column
+func toInt64s(values []int) []int64 {
+	result := make([]int64, 0, len(values))
+	for _, value := range values {
+		result = append(result, int64(value))
+	}
+	return result
+}
+
+// toInts converts the content of an array column back to a list of ints
+func toInts(values []int64) []int {
+	if values == nil {
+		return nil
+	}
+	result := make([]int, 0, len(values))
+	for _, value := range values {
+		result = append(result, int(value))
+	}
+	return result
+}
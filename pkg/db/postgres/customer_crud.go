@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+const customerColumns = `id, firstname, lastname, country, city, zip, address, created`
+
+// CreateCustomer creates a customer
+// The customer entity is given from the outside
+func (c *Client) CreateCustomer(customer entities.Customer) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO customers (firstname, lastname, country, city, zip, address, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		customer.FirstName, customer.LastName, customer.Address.Country, customer.Address.City,
+		customer.Address.ZIP, customer.Address.Address, customer.Created,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// FindCustomer searches database for customer with given ID
+func (c *Client) FindCustomer(id string) (entities.Customer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return entities.Customer{}, err
+	}
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+customerColumns+` FROM customers WHERE id = $1`, key)
+	return scanCustomer(row)
+}
+
+// AllCustomers returns all customers to caller
+func (c *Client) AllCustomers() ([]entities.Customer, error) {
+	var customers []entities.Customer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+customerColumns+` FROM customers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+
+	return customers, rows.Err()
+}
+
+// scanCustomer reads a single customer row
+func scanCustomer(row scanner) (entities.Customer, error) {
+	var id int64
+	customer := entities.Customer{}
+
+	err := row.Scan(
+		&id, &customer.FirstName, &customer.LastName, &customer.Address.Country,
+		&customer.Address.City, &customer.Address.ZIP, &customer.Address.Address, &customer.Created,
+	)
+	customer.ObjectID = formatID(id)
+
+	return customer, err
+}
+
+// customerSortColumns maps the sort fields of a query to the columns of the customers table
+var customerSortColumns = map[string]string{
+	query.SortCreated:  "created",
+	query.SortLastName: "lastname",
+}
+
+// FindCustomers returns a page of the customers matching the query together with the number of all matching customers
+func (c *Client) FindCustomers(q query.Customers) ([]entities.Customer, int, error) {
+	var customers []entities.Customer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := &conditions{}
+	if q.Country != "" {
+		filter.add("country = $%d", q.Country)
+	}
+	filter.addRange("created", q.Created)
+
+	var total int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM customers`+filter.where(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clause, args := filter.page(q.Sort, customerSortColumns, q.Page)
+	rows, err := c.db.QueryContext(ctx, `SELECT `+customerColumns+` FROM customers`+clause, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		customer, err := scanCustomer(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		customers = append(customers, customer)
+	}
+
+	return customers, total, rows.Err()
+}
@@ -0,0 +1,248 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// factoryStatusColumns are the columns read by scanFactoryStatus
+const factoryStatusColumns = `id, location, current_load, max_concurrent_orders, last_heartbeat, mode, capacity_override`
+
+// GetFactoryStatus returns the current status of a factory
+func (c *Client) GetFactoryStatus(location string) (entities.FactoryStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	row := c.db.QueryRowContext(ctx,
+		`SELECT `+factoryStatusColumns+` FROM factory_status WHERE location = $1`,
+		location,
+	)
+
+	return scanFactoryStatus(row)
+}
+
+// UpdateFactoryStatus can be used to set a factory to a new status
+func (c *Client) UpdateFactoryStatus(status entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE factory_status SET current_load = $2 WHERE location = $1`,
+		status.Location, status.CurrentLoad,
+	)
+	return err
+}
+
+// InitDelegationDatabase initializes delegation database with the given factories
+// Function is called once after the storage is initialized
+func (c *Client) InitDelegationDatabase(factories []entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE factory_status`)
+	if err != nil {
+		return err
+	}
+
+	for _, factory := range factories {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO factory_status (location, current_load, max_concurrent_orders) VALUES ($1, 0, $2)`,
+			factory.Location, factory.MaxConcurrentOrders,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RegisterFactory adds a factory or updates its capacity and heartbeat, the current load of a known factory is kept
+func (c *Client) RegisterFactory(status entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx,
+		`INSERT INTO factory_status (location, current_load, max_concurrent_orders, last_heartbeat)
+		VALUES ($1, 0, $2, $3)
+		ON CONFLICT (location) DO UPDATE SET max_concurrent_orders = EXCLUDED.max_concurrent_orders, last_heartbeat = EXCLUDED.last_heartbeat`,
+		status.Location, status.MaxConcurrentOrders, status.LastHeartbeat,
+	)
+	return err
+}
+
+// AllFactoryStatus returns the status of every registered factory
+func (c *Client) AllFactoryStatus() ([]entities.FactoryStatus, error) {
+	var factories []entities.FactoryStatus
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+factoryStatusColumns+` FROM factory_status ORDER BY location`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		status, err := scanFactoryStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		factories = append(factories, status)
+	}
+
+	return factories, rows.Err()
+}
+
+// scanFactoryStatus reads a single factory status row
+func scanFactoryStatus(row scanner) (entities.FactoryStatus, error) {
+	var id int64
+	var lastHeartbeat sql.NullTime
+	status := entities.FactoryStatus{}
+
+	err := row.Scan(&id, &status.Location, &status.CurrentLoad, &status.MaxConcurrentOrders, &lastHeartbeat,
+		&status.Mode, &status.CapacityOverride)
+	status.ObjectID = formatID(id)
+	status.LastHeartbeat = lastHeartbeat.Time.UTC()
+
+	return status, err
+}
+
+// CreateDelegation stores the decision that delegated an order to a factory
+func (c *Client) CreateDelegation(delegation entities.Delegation) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO delegations (order_id, location, strategy, reason, created)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		delegation.OrderID, delegation.Location, delegation.Strategy, delegation.Reason, delegation.Created,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// UpdateFactorySettings sets the mode and the capacity override of a factory
+// It returns sql.ErrNoRows if the factory isn't registered
+func (c *Client) UpdateFactorySettings(status entities.FactoryStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.db.ExecContext(ctx,
+		`UPDATE factory_status SET mode = $2, capacity_override = $3 WHERE location = $1`,
+		status.Location, status.Mode, status.CapacityOverride,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CompleteDelegation atomically marks the open delegation of an order as completed and returns it
+// It returns sql.ErrNoRows if the order has no open delegation, e.g. because it was already completed
+func (c *Client) CompleteDelegation(delegation entities.Delegation) (entities.Delegation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	completed := entities.Delegation{}
+
+	// concurrent calls wait for the row lock and don't match the row anymore once the first call committed
+	err := c.db.QueryRowContext(ctx,
+		`UPDATE delegations SET completed = $2 WHERE order_id = $1 AND completed IS NULL
+		RETURNING id, order_id, location, strategy, reason, created, completed`,
+		delegation.OrderID, delegation.Completed,
+	).Scan(&id, &completed.OrderID, &completed.Location, &completed.Strategy, &completed.Reason, &completed.Created, &completed.Completed)
+	if err != nil {
+		return entities.Delegation{}, err
+	}
+
+	completed.ObjectID = formatID(id)
+	return completed, nil
+}
+
+// OpenDelegations returns all orders delegated to a factory that aren't completed yet
+func (c *Client) OpenDelegations(location string) ([]entities.Delegation, error) {
+	var delegations []entities.Delegation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, order_id, location, strategy, reason, created FROM delegations
+		WHERE location = $1 AND completed IS NULL ORDER BY created`,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		delegation := entities.Delegation{}
+
+		err := rows.Scan(&id, &delegation.OrderID, &delegation.Location, &delegation.Strategy, &delegation.Reason, &delegation.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		delegation.ObjectID = formatID(id)
+		delegations = append(delegations, delegation)
+	}
+
+	return delegations, rows.Err()
+}
+
+// FindOpenDelegation returns the latest delegation of an order that isn't completed yet
+func (c *Client) FindOpenDelegation(orderID string) (entities.Delegation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	delegation := entities.Delegation{}
+
+	err := c.db.QueryRowContext(ctx,
+		`SELECT id, order_id, location, strategy, reason, created FROM delegations
+		WHERE order_id = $1 AND completed IS NULL ORDER BY created DESC LIMIT 1`,
+		orderID,
+	).Scan(&id, &delegation.OrderID, &delegation.Location, &delegation.Strategy, &delegation.Reason, &delegation.Created)
+	delegation.ObjectID = formatID(id)
+
+	return delegation, err
+}
+
+// IncrementFactoryLoad atomically changes the current load of a factory by delta and returns the updated status
+// The load is never decreased below zero
+func (c *Client) IncrementFactoryLoad(location string, delta int) (entities.FactoryStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	row := c.db.QueryRowContext(ctx,
+		`UPDATE factory_status SET current_load = GREATEST(current_load + $2, 0) WHERE location = $1
+		RETURNING `+factoryStatusColumns,
+		location, delta,
+	)
+
+	return scanFactoryStatus(row)
+}
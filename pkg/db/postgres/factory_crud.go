@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"github.com/lib/pq"
+)
+
+// CreateOrderFactory creates an order that is written into factory database
+// Service is required because factory service and order service have two seperate databases
+func (c *Client) CreateOrderFactory(order entities.Order) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lineItems, err := marshalLineItems(order.LineItems)
+	if err != nil {
+		return "", err
+	}
+
+	var id int64
+	err = c.db.QueryRowContext(ctx,
+		`INSERT INTO factory_orders (order_id, customer, status, items, created, last_update, costs_of_parts, line_items, total)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		order.OrderID, order.Customer, order.Status, pq.Array(toInt64s(order.Items)), order.Created, order.LastUpdate, order.CostsOfParts,
+		lineItems, order.Total,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// UpdateOrderStatusFactory updates the status of an order in the factory database
+func (c *Client) UpdateOrderStatusFactory(order entities.Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE factory_orders SET status = $2, last_update = $3 WHERE order_id = $1`,
+		order.OrderID, order.Status, order.LastUpdate,
+	)
+	return err
+}
+
+// FindOrderFactory returns the order with the given order id from the factory database
+func (c *Client) FindOrderFactory(orderID string) (entities.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	var items []int64
+	var lineItems []byte
+	order := entities.Order{}
+
+	err := c.db.QueryRowContext(ctx,
+		`SELECT id, order_id, customer, status, items, created, last_update, costs_of_parts, line_items, total
+		FROM factory_orders WHERE order_id = $1`,
+		orderID,
+	).Scan(&id, &order.OrderID, &order.Customer, &order.Status, pq.Array(&items), &order.Created, &order.LastUpdate, &order.CostsOfParts,
+		&lineItems, &order.Total)
+	if err != nil {
+		return order, err
+	}
+	order.ObjectID = formatID(id)
+	order.Items = toInts(items)
+	order.LineItems, err = unmarshalLineItems(lineItems)
+
+	return order, err
+}
+
+// UpdateOrderCosts updates the costs of parts of an order in the factory database
+func (c *Client) UpdateOrderCosts(order entities.Order) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE factory_orders SET costs_of_parts = $2 WHERE order_id = $1`,
+		order.OrderID, order.CostsOfParts,
+	)
+	return err
+}
+
+// AggregateKPI sums up the complete and incomplete orders of the factory as well as their part costs and revenue
+// Like the mongo aggregation, the result is empty if the factory hasn't received any orders yet
+func (c *Client) AggregateKPI() ([]entities.KPI, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kpi := entities.KPI{ObjectID: "kpi"}
+	err := c.db.QueryRowContext(ctx,
+		`SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'shipped'),
+			COALESCE(SUM(costs_of_parts), 0),
+			COALESCE(SUM(total) FILTER (WHERE status NOT IN ('failed', 'cancelled')), 0)
+		FROM factory_orders`,
+	).Scan(&kpi.Total, &kpi.CompletedOrders, &kpi.CostsOfParts, &kpi.Revenue)
+	if err != nil {
+		return nil, err
+	}
+
+	if kpi.Total == 0 {
+		return nil, nil
+	}
+
+	return []entities.KPI{kpi}, nil
+}
+
+// CountOpenOrdersFactory returns the number of orders the factory hasn't shipped, aborted or cancelled
+func (c *Client) CountOpenOrdersFactory() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var count int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM factory_orders WHERE status NOT IN ('shipped', 'failed', 'cancelled')`).Scan(&count)
+
+	return count, err
+}
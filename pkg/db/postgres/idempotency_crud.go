@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// ReserveIdempotencyKey stores a new idempotency key, a stored key created before expired is replaced
+// as well as a key without response created before abandoned
+// It returns false if the key is already stored and neither expired nor abandoned
+func (c *Client) ReserveIdempotencyKey(key entities.IdempotencyKey, expired, abandoned time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := c.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (key, request_hash, created) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET request_hash = EXCLUDED.request_hash, order_id = '', status_code = 0,
+			response = NULL, created = EXCLUDED.created
+		WHERE idempotency_keys.created < $4 OR (idempotency_keys.status_code = 0 AND idempotency_keys.created < $5)`,
+		key.Key, key.RequestHash, key.Created, expired, abandoned,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	reserved, err := result.RowsAffected()
+	return reserved > 0, err
+}
+
+// FindIdempotencyKey returns the stored idempotency key
+func (c *Client) FindIdempotencyKey(key string) (entities.IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored := entities.IdempotencyKey{}
+	err := c.db.QueryRowContext(ctx,
+		`SELECT key, request_hash, order_id, status_code, response, created FROM idempotency_keys WHERE key = $1`,
+		key,
+	).Scan(&stored.Key, &stored.RequestHash, &stored.OrderID, &stored.StatusCode, &stored.Response, &stored.Created)
+
+	return stored, err
+}
+
+// CompleteIdempotencyKey stores the response to the request of an idempotency key
+func (c *Client) CompleteIdempotencyKey(key entities.IdempotencyKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET order_id = $2, status_code = $3, response = $4 WHERE key = $1`,
+		key.Key, key.OrderID, key.StatusCode, key.Response,
+	)
+	return err
+}
+
+// DeleteIdempotencyKey removes an idempotency key so that the request can be sent again
+func (c *Client) DeleteIdempotencyKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+const kpiColumns = `id, created, location, incomplete_orders, completed_orders, total, costs_of_parts, revenue`
+
+// CreateKPI creates a new KPI entrance in KPI database
+func (c *Client) CreateKPI(kpi entities.KPI) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO kpis (created, location, incomplete_orders, completed_orders, total, costs_of_parts, revenue)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		kpi.Created, kpi.Location, kpi.IncompleteOrders, kpi.CompletedOrders, kpi.Total, kpi.CostsOfParts, kpi.Revenue,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// FindKPI returns the latest KPI of the given factory location or the latest KPI of every location if none is given
+func (c *Client) FindKPI(location string) ([]entities.KPI, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if location == "" {
+		return c.aggregateKPIs(ctx)
+	}
+	return c.findKPIForLocation(ctx, location, 1)
+}
+
+// FindLastNKPI searches and returns the last n KPIs of given factory location
+func (c *Client) FindLastNKPI(location string, n int64) ([]entities.KPI, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return c.findKPIForLocation(ctx, location, n)
+}
+
+// aggregateKPIs returns the newest kpi entry of each location
+func (c *Client) aggregateKPIs(ctx context.Context) ([]entities.KPI, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT DISTINCT ON (location) `+kpiColumns+`
+		FROM kpis
+		ORDER BY location, created DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanKPIs(rows)
+}
+
+// findKPIForLocation returns the last n kpi reports for a specific factory
+func (c *Client) findKPIForLocation(ctx context.Context, location string, limit int64) ([]entities.KPI, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT `+kpiColumns+`
+		FROM kpis
+		WHERE location = $1
+		ORDER BY created DESC
+		LIMIT $2`,
+		location, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanKPIs(rows)
+}
+
+// scanKPIs reads all kpi rows and closes them
+func scanKPIs(rows *sql.Rows) ([]entities.KPI, error) {
+	var kpis []entities.KPI
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		kpi := entities.KPI{}
+
+		err := rows.Scan(&id, &kpi.Created, &kpi.Location, &kpi.IncompleteOrders, &kpi.CompletedOrders, &kpi.Total, &kpi.CostsOfParts,
+			&kpi.Revenue)
+		if err != nil {
+			return nil, err
+		}
+		kpi.ObjectID = formatID(id)
+
+		kpis = append(kpis, kpi)
+	}
+
+	return kpis, rows.Err()
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// FindModel finds a model specified by its ID
+func (c *Client) FindModel(id int) (entities.Model, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model := entities.Model{}
+	err := c.db.QueryRowContext(ctx, `SELECT id, name, assembly_time, price FROM models WHERE id = $1`, id).
+		Scan(&model.ID, &model.Name, &model.AssemblyTime, &model.Price)
+	if err != nil {
+		return model, err
+	}
+	model.ObjectID = formatID(int64(model.ID))
+
+	parts, err := c.modelParts(ctx, `WHERE model = $1`, id)
+	if err != nil {
+		return model, err
+	}
+	model.Parts = parts[model.ID]
+
+	return model, nil
+}
+
+// AllModels returns all models in model database
+func (c *Client) AllModels() ([]entities.Model, error) {
+	var models []entities.Model
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT id, name, assembly_time, price FROM models ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		model := entities.Model{}
+		err = rows.Scan(&model.ID, &model.Name, &model.AssemblyTime, &model.Price)
+		if err != nil {
+			return nil, err
+		}
+		model.ObjectID = formatID(int64(model.ID))
+		models = append(models, model)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	parts, err := c.modelParts(ctx, ``)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range models {
+		models[i].Parts = parts[models[i].ID]
+	}
+
+	return models, nil
+}
+
+// UpdateModelPart updates a part in all models it is being used in
+func (c *Client) UpdateModelPart(part entities.Part) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE model_parts SET price = $2 WHERE part = $1`, part.ID, part.Price)
+	return err
+}
+
+// InitModelDatabase initializes model database with the seed values
+func (c *Client) InitModelDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE models, model_parts`)
+	if err != nil {
+		return err
+	}
+
+	for _, model := range seed.Models(seed.Parts(seed.Suppliers())) {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO models (id, name, assembly_time, price) VALUES ($1, $2, $3, $4)`,
+			model.ID, model.Name, model.AssemblyTime, model.Price,
+		)
+		if err != nil {
+			return err
+		}
+
+		for position, part := range model.Parts {
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO model_parts
+				(model, position, part, price, supplier_name, supplier_country, supplier_city, supplier_zip, supplier_address)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+				model.ID, position, part.ID, part.Price, part.Supplier.Name, part.Supplier.Address.Country,
+				part.Supplier.Address.City, part.Supplier.Address.ZIP, part.Supplier.Address.Address,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// modelParts returns the parts of all models matching the filter grouped by the model id
+func (c *Client) modelParts(ctx context.Context, filter string, args ...interface{}) (map[int][]entities.Part, error) {
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT model, part, price, supplier_name, supplier_country, supplier_city, supplier_zip, supplier_address
+		FROM model_parts `+filter+`
+		ORDER BY model, position`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanModelParts(rows)
+}
+
+// scanModelParts reads all model part rows and closes them
+func scanModelParts(rows *sql.Rows) (map[int][]entities.Part, error) {
+	parts := make(map[int][]entities.Part)
+	defer rows.Close()
+
+	for rows.Next() {
+		var model int
+		part := entities.Part{}
+
+		err := rows.Scan(
+			&model, &part.ID, &part.Price, &part.Supplier.Name, &part.Supplier.Address.Country,
+			&part.Supplier.Address.City, &part.Supplier.Address.ZIP, &part.Supplier.Address.Address,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		parts[model] = append(parts[model], part)
+	}
+
+	return parts, rows.Err()
+}
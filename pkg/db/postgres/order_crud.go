@@ -0,0 +1,266 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"github.com/lib/pq"
+)
+
+const orderColumns = `id, customer, status, items, created, last_update, costs_of_parts, location, deadline, redelegations, reason,
+	idempotency_key, line_items, total`
+
+// scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// CreateOrder creates order in order database
+func (c *Client) CreateOrder(order entities.Order) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lineItems, err := marshalLineItems(order.LineItems)
+	if err != nil {
+		return "", err
+	}
+
+	var id int64
+	err = c.db.QueryRowContext(ctx,
+		`INSERT INTO orders (customer, status, items, created, last_update, costs_of_parts, deadline, idempotency_key, line_items, total)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		order.Customer, order.Status, pq.Array(toInt64s(order.Items)), order.Created, order.LastUpdate, order.CostsOfParts,
+		nullTime(order.Deadline), order.IdempotencyKey, lineItems, order.Total,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// UpdateOrderStatus updates the status of a given order together with the deadline of its next step
+// The order is only updated while its stored status is the previous status, otherwise it returns false
+func (c *Client) UpdateOrderStatus(order entities.Order, previous string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(order.ObjectID)
+	if err != nil {
+		return false, nil
+	}
+
+	result, err := c.db.ExecContext(ctx,
+		`UPDATE orders SET status = $2, last_update = $3, location = $4, deadline = $5, redelegations = $6, reason = $7
+		WHERE id = $1 AND status = $8`,
+		key, order.Status, order.LastUpdate, order.Location, nullTime(order.Deadline), order.Redelegations, order.Reason,
+		previous,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// FindOrder returns the order with the given ID from the order database
+func (c *Client) FindOrder(id string) (entities.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return entities.Order{}, err
+	}
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+orderColumns+` FROM orders WHERE id = $1`, key)
+	return scanOrder(row)
+}
+
+// AllOrders returns all orders within order database
+func (c *Client) AllOrders() ([]entities.Order, error) {
+	var orders []entities.Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+orderColumns+` FROM orders ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+// OverdueOrders returns all orders whose current step wasn't finished before its deadline
+func (c *Client) OverdueOrders(now time.Time) ([]entities.Order, error) {
+	var orders []entities.Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+orderColumns+` FROM orders WHERE deadline < $1 ORDER BY deadline`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+// scanOrder reads a single order row
+func scanOrder(row scanner) (entities.Order, error) {
+	var id int64
+	var items []int64
+	var deadline sql.NullTime
+	var lineItems []byte
+	order := entities.Order{}
+
+	err := row.Scan(&id, &order.Customer, &order.Status, pq.Array(&items), &order.Created, &order.LastUpdate, &order.CostsOfParts,
+		&order.Location, &deadline, &order.Redelegations, &order.Reason, &order.IdempotencyKey, &lineItems, &order.Total)
+	if err != nil {
+		return order, err
+	}
+	order.ObjectID = formatID(id)
+	order.Items = toInts(items)
+	order.Deadline = deadline.Time
+	order.LineItems, err = unmarshalLineItems(lineItems)
+
+	return order, err
+}
+
+// marshalLineItems converts line items so that they can be written to a jsonb column
+func marshalLineItems(lineItems []entities.LineItem) ([]byte, error) {
+	if lineItems == nil {
+		lineItems = []entities.LineItem{}
+	}
+	return json.Marshal(lineItems)
+}
+
+// unmarshalLineItems converts the content of a jsonb column back to line items, orders without line items return nil
+func unmarshalLineItems(content []byte) ([]entities.LineItem, error) {
+	var lineItems []entities.LineItem
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	err := json.Unmarshal(content, &lineItems)
+	if len(lineItems) == 0 {
+		return nil, err
+	}
+	return lineItems, err
+}
+
+// AddStatusChange adds a status change to the history of an order
+func (c *Client) AddStatusChange(change entities.StatusChange) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO order_history (order_id, status, timestamp, location, source, reason)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		change.OrderID, change.Status, change.Timestamp, change.Location, change.Source, change.Reason,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// OrderHistory returns all status changes of an order sorted by time
+func (c *Client) OrderHistory(orderID string) ([]entities.StatusChange, error) {
+	var history []entities.StatusChange
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, order_id, status, timestamp, location, source, reason FROM order_history
+		WHERE order_id = $1 ORDER BY timestamp, id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		change := entities.StatusChange{}
+
+		err := rows.Scan(&id, &change.OrderID, &change.Status, &change.Timestamp, &change.Location, &change.Source, &change.Reason)
+		if err != nil {
+			return nil, err
+		}
+
+		change.ObjectID = formatID(id)
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// orderSortColumns maps the sort fields of a query to the columns of the orders table
+var orderSortColumns = map[string]string{
+	query.SortCreated:    "created",
+	query.SortLastUpdate: "last_update",
+}
+
+// FindOrders returns a page of the orders matching the query together with the number of all matching orders
+func (c *Client) FindOrders(q query.Orders) ([]entities.Order, int, error) {
+	var orders []entities.Order
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := &conditions{}
+	if q.Customer != "" {
+		filter.add("customer = $%d", q.Customer)
+	}
+	if len(q.Status) > 0 {
+		filter.add("status = ANY($%d)", pq.Array(q.Status))
+	}
+	filter.addRange("created", q.Created)
+	filter.addRange("last_update", q.Updated)
+
+	var total int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders`+filter.where(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clause, args := filter.page(q.Sort, orderSortColumns, q.Page)
+	rows, err := c.db.QueryContext(ctx, `SELECT `+orderColumns+` FROM orders`+clause, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, total, rows.Err()
+}
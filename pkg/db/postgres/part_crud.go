@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/seed"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// UpdatePart updates price of a part
+// The part service is responsible for the update of part prices
+// Price updates are received from the model service as soon as is it notified of a price change by a supplier
+func (c *Client) UpdatePart(part entities.Part) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.db.ExecContext(ctx, `UPDATE parts SET price = $2 WHERE id = $1`, part.ID, part.Price)
+	return err
+}
+
+// FindPart finds and returns a part specified by its ID
+func (c *Client) FindPart(id int) (entities.Part, error) {
+	var supplierID sql.NullInt64
+	var name, country, city, address sql.NullString
+	var zip sql.NullInt64
+	part := entities.Part{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := c.db.QueryRowContext(ctx,
+		`SELECT p.id, p.price, s.id, s.name, s.country, s.city, s.zip, s.address
+		FROM parts p LEFT JOIN suppliers s ON s.id = p.supplier
+		WHERE p.id = $1`,
+		id,
+	).Scan(&part.ID, &part.Price, &supplierID, &name, &country, &city, &zip, &address)
+	if err != nil {
+		return part, err
+	}
+
+	part.ObjectID = formatID(int64(part.ID))
+	if supplierID.Valid {
+		part.Supplier = entities.Supplier{
+			ObjectID: formatID(supplierID.Int64),
+			Name:     name.String,
+			Address: entities.Address{
+				Country: country.String,
+				City:    city.String,
+				ZIP:     int(zip.Int64),
+				Address: address.String,
+			},
+		}
+	}
+
+	return part, nil
+}
+
+// FindSupplier finds and returns a supplier specified by its ID
+func (c *Client) FindSupplier(id string) (entities.Supplier, error) {
+	supplier := entities.Supplier{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return supplier, err
+	}
+
+	err = c.db.QueryRowContext(ctx,
+		`SELECT id, name, country, city, zip, address FROM suppliers WHERE id = $1`,
+		key,
+	).Scan(&key, &supplier.Name, &supplier.Address.Country, &supplier.Address.City, &supplier.Address.ZIP, &supplier.Address.Address)
+	supplier.ObjectID = formatID(key)
+
+	return supplier, err
+}
+
+// InitPartDatabase initializes the part database with the seed values
+func (c *Client) InitPartDatabase() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE parts, suppliers RESTART IDENTITY`)
+	if err != nil {
+		return err
+	}
+
+	suppliers := seed.Suppliers()
+	for i, supplier := range suppliers {
+		var id int64
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO suppliers (name, country, city, zip, address) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			supplier.Name, supplier.Address.Country, supplier.Address.City, supplier.Address.ZIP, supplier.Address.Address,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		suppliers[i].ObjectID = formatID(id)
+	}
+
+	for _, part := range seed.Parts(suppliers) {
+		supplierID, _ := parseID(part.Supplier.ObjectID)
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO parts (id, price, supplier) VALUES ($1, $2, $3)`,
+			part.ID, part.Price, supplierID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+)
+
+// conditions collects the where clause of a query together with its arguments
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+// add adds a condition, the format contains a single %d which is replaced with the number of the argument
+func (c *conditions) add(format string, arg interface{}) {
+	c.args = append(c.args, arg)
+	c.clauses = append(c.clauses, fmt.Sprintf(format, len(c.args)))
+}
+
+// addRange limits a time column to a time range
+func (c *conditions) addRange(column string, r query.TimeRange) {
+	if !r.From.IsZero() {
+		c.add(column+" >= $%d", r.From)
+	}
+	if !r.To.IsZero() {
+		c.add(column+" < $%d", r.To)
+	}
+}
+
+// where returns the where clause, it is empty if there are no conditions
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// page returns the where clause followed by the order and the limit of a query together with all arguments
+// The sort field is looked up in the given columns so that it can't be used to inject sql, unknown fields sort by id
+func (c *conditions) page(sort query.Sort, columns map[string]string, page query.Page) (string, []interface{}) {
+	direction := "ASC"
+	if sort.Descending {
+		direction = "DESC"
+	}
+
+	order := "id " + direction
+	if column, ok := columns[sort.Field]; ok {
+		order = fmt.Sprintf("%s %s, id %s", column, direction, direction)
+	}
+
+	clause := c.where() + " ORDER BY " + order
+	args := append([]interface{}(nil), c.args...)
+
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		clause += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if page.Offset > 0 {
+		args = append(args, page.Offset)
+		clause += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	return clause, args
+}
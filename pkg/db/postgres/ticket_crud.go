@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"github.com/lib/pq"
+)
+
+const ticketColumns = `id, created, closed, status, text, response, location`
+
+// CreateTicket creates a ticket after it was opened by a customer
+func (c *Client) CreateTicket(ticket entities.Ticket) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO tickets (created, closed, status, text, response, location)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		ticket.Created, ticket.Closed, ticket.Status, ticket.Text, ticket.Response, ticket.Location,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// UpdateTicket updates a given ticket in ticket database
+func (c *Client) UpdateTicket(ticket entities.Ticket) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(ticket.ObjectID)
+	if err != nil {
+		return nil
+	}
+
+	_, err = c.db.ExecContext(ctx,
+		`UPDATE tickets SET status = $2, closed = $3, response = $4 WHERE id = $1`,
+		key, ticket.Status, ticket.Closed, ticket.Response,
+	)
+	return err
+}
+
+// FindTicket finds and returns a ticket with given ID
+func (c *Client) FindTicket(id string) (entities.Ticket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return entities.Ticket{}, err
+	}
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+ticketColumns+` FROM tickets WHERE id = $1`, key)
+	return scanTicket(row)
+}
+
+// AllTickets returns all tickets in ticket database
+func (c *Client) AllTickets() ([]entities.Ticket, error) {
+	var tickets []entities.Ticket
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+ticketColumns+` FROM tickets ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ticket, err := scanTicket(rows)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, rows.Err()
+}
+
+// scanTicket reads a single ticket row
+func scanTicket(row scanner) (entities.Ticket, error) {
+	var id int64
+	ticket := entities.Ticket{}
+
+	err := row.Scan(&id, &ticket.Created, &ticket.Closed, &ticket.Status, &ticket.Text, &ticket.Response, &ticket.Location)
+	ticket.ObjectID = formatID(id)
+
+	return ticket, err
+}
+
+// ticketSortColumns maps the sort fields of a query to the columns of the tickets table
+var ticketSortColumns = map[string]string{
+	query.SortCreated: "created",
+	query.SortClosed:  "closed",
+}
+
+// FindTickets returns a page of the tickets matching the query together with the number of all matching tickets
+func (c *Client) FindTickets(q query.Tickets) ([]entities.Ticket, int, error) {
+	var tickets []entities.Ticket
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := &conditions{}
+	if q.Location != "" {
+		filter.add("location = $%d", q.Location)
+	}
+	if len(q.Status) > 0 {
+		filter.add("status = ANY($%d)", pq.Array(q.Status))
+	}
+	filter.addRange("created", q.Created)
+
+	var total int
+	err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM tickets`+filter.where(), filter.args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	clause, args := filter.page(q.Sort, ticketSortColumns, q.Page)
+	rows, err := c.db.QueryContext(ctx, `SELECT `+ticketColumns+` FROM tickets`+clause, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ticket, err := scanTicket(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, total, rows.Err()
+}
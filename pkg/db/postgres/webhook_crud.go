@@ -0,0 +1,223 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"github.com/lib/pq"
+)
+
+const (
+	webhookColumns  = `id, url, events, secret, created`
+	deliveryColumns = `id, webhook, event, payload, status, attempts, next_attempt, last_attempt, status_code, error,
+		redelivery_of, created`
+)
+
+// CreateWebhook stores a new webhook subscription
+func (c *Client) CreateWebhook(webhook entities.Webhook) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO webhooks (url, events, secret, created) VALUES ($1, $2, $3, $4) RETURNING id`,
+		webhook.URL, pq.Array(webhook.Events), webhook.Secret, webhook.Created,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// FindWebhook returns the webhook with the given id
+func (c *Client) FindWebhook(id string) (entities.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return entities.Webhook{}, err
+	}
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, key)
+	return scanWebhook(row)
+}
+
+// AllWebhooks returns all webhooks, the oldest first
+func (c *Client) AllWebhooks() ([]entities.Webhook, error) {
+	var webhooks []entities.Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY created, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook, its deliveries are kept as log
+func (c *Client) DeleteWebhook(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, key)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		err = sql.ErrNoRows
+	}
+	return err
+}
+
+// scanWebhook reads a single webhook row
+func scanWebhook(row scanner) (entities.Webhook, error) {
+	var id int64
+	webhook := entities.Webhook{}
+
+	err := row.Scan(&id, &webhook.URL, pq.Array(&webhook.Events), &webhook.Secret, &webhook.Created)
+	webhook.ObjectID = formatID(id)
+
+	return webhook, err
+}
+
+// CreateDelivery stores a new delivery of an event to a webhook
+func (c *Client) CreateDelivery(delivery entities.WebhookDelivery) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	err := c.db.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries (webhook, event, payload, status, attempts, next_attempt, last_attempt, status_code,
+			error, redelivery_of, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		delivery.Webhook, delivery.Event, string(delivery.Payload), delivery.Status, delivery.Attempts,
+		nullTime(delivery.NextAttempt), nullTime(delivery.LastAttempt), delivery.StatusCode, delivery.Error,
+		delivery.RedeliveryOf, delivery.Created,
+	).Scan(&id)
+
+	return formatID(id), err
+}
+
+// UpdateDelivery stores the result of an attempt to deliver an event
+func (c *Client) UpdateDelivery(delivery entities.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(delivery.ObjectID)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt = $4, last_attempt = $5, status_code = $6,
+			error = $7
+		WHERE id = $1`,
+		key, delivery.Status, delivery.Attempts, nullTime(delivery.NextAttempt), nullTime(delivery.LastAttempt),
+		delivery.StatusCode, delivery.Error,
+	)
+	return err
+}
+
+// FindDelivery returns the delivery with the given id
+func (c *Client) FindDelivery(id string) (entities.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := parseID(id)
+	if err != nil {
+		return entities.WebhookDelivery{}, err
+	}
+
+	row := c.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1`, key)
+	return scanDelivery(row)
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, the newest first
+func (c *Client) WebhookDeliveries(webhookID string) ([]entities.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook = $1 ORDER BY created DESC, id DESC`,
+		webhookID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+// DueDeliveries returns the deliveries whose next attempt is due at the given time, the oldest attempt first
+// Deliveries without next attempt were either delivered or failed for good
+func (c *Client) DueDeliveries(now time.Time) ([]entities.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE next_attempt <= $1 ORDER BY next_attempt, id`,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+// scanDeliveries reads all delivery rows
+func scanDeliveries(rows *sql.Rows) ([]entities.WebhookDelivery, error) {
+	var deliveries []entities.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// scanDelivery reads a single delivery row
+func scanDelivery(row scanner) (entities.WebhookDelivery, error) {
+	var id int64
+	var payload []byte
+	var nextAttempt, lastAttempt sql.NullTime
+	delivery := entities.WebhookDelivery{}
+
+	err := row.Scan(&id, &delivery.Webhook, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts, &nextAttempt,
+		&lastAttempt, &delivery.StatusCode, &delivery.Error, &delivery.RedeliveryOf, &delivery.Created)
+	if err != nil {
+		return delivery, err
+	}
+
+	delivery.ObjectID = formatID(id)
+	delivery.Payload = payload
+	delivery.NextAttempt = nextAttempt.Time
+	delivery.LastAttempt = lastAttempt.Time
+
+	return delivery, nil
+}
@@ -0,0 +1,107 @@
+// Package query contains the filters, sort orders and pages used to search orders, customers and tickets
+// It doesn't depend on any storage backend, so every backend can translate a query into its own query language
+package query
+
+import "time"
+
+const (
+	// DefaultLimit is the page size used if a request only defines the offset of a page
+	DefaultLimit = 50
+	// MaxLimit is the largest page a query may request
+	MaxLimit = 500
+)
+
+// fields entries can be sorted by, the names match the json fields of the entities
+const (
+	SortCreated    = "created"
+	SortLastUpdate = "lastUpdate"
+	SortClosed     = "closed"
+	SortLastName   = "lastname"
+)
+
+// TimeRange limits a time field, From is inclusive and To exclusive
+// A zero time leaves the range open on that side
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains checks whether a time lies within the range
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !t.Before(r.To) {
+		return false
+	}
+	return true
+}
+
+// Sort defines the order of the results, entries with the same value are ordered by their id
+type Sort struct {
+	Field      string
+	Descending bool
+}
+
+// Page selects a part of the results, a page without limit contains all results after the offset
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// Orders filters orders, empty fields don't filter
+type Orders struct {
+	Customer string
+	// Status contains all accepted statuses
+	Status  []string
+	Created TimeRange
+	Updated TimeRange
+	Sort    Sort
+	Page    Page
+}
+
+// Customers filters customers, empty fields don't filter
+type Customers struct {
+	Country string
+	Created TimeRange
+	Sort    Sort
+	Page    Page
+}
+
+// Tickets filters tickets, empty fields don't filter
+type Tickets struct {
+	// Status contains all accepted statuses
+	Status   []string
+	Location string
+	Created  TimeRange
+	Sort     Sort
+	Page     Page
+}
+
+// HasStatus checks whether a status is accepted by a list of statuses, an empty list accepts every status
+func HasStatus(accepted []string, status string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, candidate := range accepted {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the start and end index of a page within a list of the given length
+func (p Page) Bounds(length int) (int, int) {
+	start := p.Offset
+	if start > length {
+		start = length
+	}
+
+	end := length
+	if p.Limit > 0 && start+p.Limit < length {
+		end = start + p.Limit
+	}
+
+	return start, end
+}
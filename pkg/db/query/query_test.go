@@ -0,0 +1,60 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		page      Page
+		length    int
+		wantStart int
+		wantEnd   int
+	}{
+		{name: "first page", page: Page{Limit: 2}, length: 5, wantStart: 0, wantEnd: 2},
+		{name: "middle page", page: Page{Limit: 2, Offset: 2}, length: 5, wantStart: 2, wantEnd: 4},
+		{name: "last partial page", page: Page{Limit: 2, Offset: 4}, length: 5, wantStart: 4, wantEnd: 5},
+		{name: "page ends with the list", page: Page{Limit: 2, Offset: 3}, length: 5, wantStart: 3, wantEnd: 5},
+		{name: "offset behind the list", page: Page{Limit: 2, Offset: 10}, length: 5, wantStart: 5, wantEnd: 5},
+		{name: "offset at the end of the list", page: Page{Limit: 2, Offset: 5}, length: 5, wantStart: 5, wantEnd: 5},
+		{name: "without limit", page: Page{Offset: 1}, length: 5, wantStart: 1, wantEnd: 5},
+		{name: "empty list", page: Page{Limit: 2}, length: 0, wantStart: 0, wantEnd: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := test.page.Bounds(test.length)
+			if start != test.wantStart || end != test.wantEnd {
+				t.Errorf("Bounds(%d) = %d, %d, want %d, %d", test.length, start, end, test.wantStart, test.wantEnd)
+			}
+		})
+	}
+}
+
+func TestTimeRangeContains(t *testing.T) {
+	from := time.Date(2020, 7, 8, 12, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		timeRange TimeRange
+		time      time.Time
+		want      bool
+	}{
+		{name: "open range", timeRange: TimeRange{}, time: from, want: true},
+		{name: "from is inclusive", timeRange: TimeRange{From: from, To: to}, time: from, want: true},
+		{name: "to is exclusive", timeRange: TimeRange{From: from, To: to}, time: to, want: false},
+		{name: "before from", timeRange: TimeRange{From: from}, time: from.Add(-time.Second), want: false},
+		{name: "within", timeRange: TimeRange{From: from, To: to}, time: from.Add(time.Minute), want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.timeRange.Contains(test.time); got != test.want {
+				t.Errorf("Contains(%s) = %v, want %v", test.time, got, test.want)
+			}
+		})
+	}
+}
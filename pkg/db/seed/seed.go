@@ -0,0 +1,77 @@
+// Package seed contains the initial data used by the storage backends to simulate a full database
+// These values are made up or were found in the requirements document
+package seed
+
+import (
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+// Suppliers returns the suppliers used to initialize the part and model storage
+func Suppliers() []entities.Supplier {
+	return []entities.Supplier{
+		{
+			Name: "electroStuff.com",
+			Address: entities.Address{
+				Country: "Germany",
+				City:    "Reinheim",
+				ZIP:     64354,
+				Address: "Anne-Frank-Straße 23",
+			},
+		},
+		{
+			Name: "coolMechanics.com",
+			Address: entities.Address{
+				Country: "Denmark",
+				City:    "Anaago",
+				ZIP:     33674,
+				Address: "Bjutsche 7",
+			},
+		},
+	}
+}
+
+// Parts returns the parts used to initialize the part and model storage
+func Parts(suppliers []entities.Supplier) []entities.Part {
+	return []entities.Part{
+		{Price: 139, ID: 1, Supplier: suppliers[0]},
+		{Price: 53, ID: 2, Supplier: suppliers[1]},
+		{Price: 18, ID: 3, Supplier: suppliers[1]},
+		{Price: 223, ID: 4, Supplier: suppliers[0]},
+		{Price: 140, ID: 5, Supplier: suppliers[0]},
+		{Price: 98, ID: 6, Supplier: suppliers[1]},
+	}
+}
+
+// Models returns the models used to initialize the model storage
+func Models(parts []entities.Part) []entities.Model {
+	return []entities.Model{
+		{
+			Name:         "UltraCool9000",
+			ID:           1,
+			AssemblyTime: 10,
+			Price:        599,
+			Parts:        []entities.Part{parts[0], parts[2], parts[5]},
+		},
+		{
+			Name:         "IcyX",
+			ID:           2,
+			AssemblyTime: 7,
+			Price:        1199,
+			Parts:        []entities.Part{parts[0], parts[1], parts[4], parts[1], parts[3]},
+		},
+		{
+			Name:         "Chiller",
+			ID:           3,
+			AssemblyTime: 15,
+			Price:        899,
+			Parts:        []entities.Part{parts[0], parts[2], parts[2], parts[1], parts[3]},
+		},
+		{
+			Name:         "CoolBoy",
+			ID:           4,
+			AssemblyTime: 8,
+			Price:        749,
+			Parts:        []entities.Part{parts[0], parts[2], parts[4], parts[5]},
+		},
+	}
+}
@@ -1,6 +1,9 @@
 package entities
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 /*
 This package is a collection of all data objects shared between the services as well as databases
@@ -31,9 +34,60 @@ type Order struct {
 	Created      time.Time `json:"created" bson:"created"`
 	Customer     string    `json:"customer" bson:"customer"`
 	Status       string    `json:"status" bson:"status"`
-	Items        []int     `json:"items" bson:"items"`
+	Items        []int     `json:"items,omitempty" bson:"items,omitempty"`
 	LastUpdate   time.Time `json:"lastUpdate" bson:"lastUpdate"`
 	CostsOfParts int       `json:"costsOfParts,omitempty" bson:"costsOfParts,omitempty"`
+	// LineItems are the ordered models, older clients send a flat list of model ids as Items instead
+	// which is converted to line items when the order is created
+	LineItems []LineItem `json:"lineItems,omitempty" bson:"lineItems,omitempty"`
+	// Total is the price of all line items
+	Total int `json:"total,omitempty" bson:"total,omitempty"`
+	// Location is the factory that accepted the order
+	Location string `json:"location,omitempty" bson:"location,omitempty"`
+	// Deadline is the time the current step of the order has to be finished by, it is empty once the order is finished
+	Deadline time.Time `json:"deadline" bson:"deadline,omitempty"`
+	// Redelegations counts how often the order was delegated again because no factory accepted it in time
+	Redelegations int `json:"redelegations,omitempty" bson:"redelegations,omitempty"`
+	// Reason explains why an order failed
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+	// IdempotencyKey is the key the client sent with the request that created the order
+	IdempotencyKey string `json:"idempotencyKey,omitempty" bson:"idempotencyKey,omitempty"`
+}
+
+// LineItem is a model ordered in a given quantity
+// The unit price is taken from the model catalogue when the order is created and doesn't change afterwards
+type LineItem struct {
+	Model     int `json:"model" bson:"model"`
+	Quantity  int `json:"quantity" bson:"quantity"`
+	UnitPrice int `json:"unitPrice" bson:"unitPrice"`
+	// Total is the unit price multiplied with the quantity
+	Total int `json:"total" bson:"total"`
+}
+
+// IdempotencyKey stores the response to a request that was sent with an Idempotency-Key header,
+// so that the response can be repeated if the client sends the request again
+type IdempotencyKey struct {
+	Key string `json:"key" bson:"_id"`
+	// RequestHash is the hash of the request body, a key may only be reused with the same body
+	RequestHash string `json:"requestHash" bson:"requestHash"`
+	OrderID     string `json:"orderID,omitempty" bson:"orderID,omitempty"`
+	// StatusCode and Response are empty while the first request is being processed
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Response   []byte    `json:"response,omitempty" bson:"response,omitempty"`
+	Created    time.Time `json:"created" bson:"created"`
+}
+
+// StatusChange records a single status change of an order
+type StatusChange struct {
+	ObjectID  string    `json:"objectID,omitempty" bson:"_id,omitempty"`
+	OrderID   string    `json:"orderID" bson:"orderID"`
+	Status    string    `json:"status" bson:"status"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	// Location is the factory the order was at when the status changed
+	Location string `json:"location,omitempty" bson:"location,omitempty"`
+	// Source is the service that reported the status change
+	Source string `json:"source" bson:"source"`
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
 }
 
 // FactoryStatus is the entity that holds information about the load of a single factory
@@ -43,6 +97,24 @@ type FactoryStatus struct {
 	Location            string `json:"location" bson:"location"`
 	CurrentLoad         int    `json:"currentLoad" bson:"currentLoad"`
 	MaxConcurrentOrders int    `json:"maxConcurrentOrders" bson:"maxConcurrentOrders"`
+	// LastHeartbeat is the time the factory last announced itself to the delegation service
+	LastHeartbeat time.Time `json:"lastHeartbeat" bson:"lastHeartbeat"`
+	// Mode is set by an administrator to stop delegating new orders to the factory, it is empty for active factories
+	Mode string `json:"mode,omitempty" bson:"mode,omitempty"`
+	// CapacityOverride replaces the capacity announced by the factory if it is set by an administrator
+	CapacityOverride int `json:"capacityOverride,omitempty" bson:"capacityOverride,omitempty"`
+}
+
+// Delegation records which factory an order was delegated to, the strategy that chose the factory and its reason
+type Delegation struct {
+	ObjectID string    `json:"objectID,omitempty" bson:"_id,omitempty"`
+	OrderID  string    `json:"orderID" bson:"orderID"`
+	Location string    `json:"location" bson:"location"`
+	Strategy string    `json:"strategy" bson:"strategy"`
+	Reason   string    `json:"reason" bson:"reason"`
+	Created  time.Time `json:"created" bson:"created"`
+	// Completed is set once the factory reported the order as complete
+	Completed time.Time `json:"completed" bson:"completed,omitempty"`
 }
 
 // Ticket is the entity used to hold information of a support ticket
@@ -67,6 +139,8 @@ type KPI struct {
 	CompletedOrders  int       `json:"completedOrders" bson:"completedOrders"`
 	Total            int       `json:"total" bson:"total"`
 	CostsOfParts     int       `json:"costsOfParts" bson:"costsOfParts"`
+	// Revenue is the price of all orders that weren't aborted or cancelled, it can be compared with CostsOfParts
+	Revenue int `json:"revenue" bson:"revenue"`
 }
 
 // Supplier is the supplier object
@@ -91,5 +165,38 @@ type Model struct {
 	ID           int    `json:"id,omitempty" bson:"id,omitempty"`
 	Name         string `json:"name,omitempty" bson:"name,omitempty"`
 	AssemblyTime int    `json:"assemblytime,omitempty" bson:"assemblyTime,omitempty"`
-	Parts        []Part `json:"parts" bson:"parts"`
+	// Price is the price a customer pays for a single fridge of the model
+	Price int    `json:"price,omitempty" bson:"price,omitempty"`
+	Parts []Part `json:"parts" bson:"parts"`
+}
+
+// Webhook is the subscription of an external system to the events of a service
+type Webhook struct {
+	ObjectID string `json:"objectID,omitempty" bson:"_id,omitempty"`
+	URL      string `json:"url" bson:"url"`
+	// Events contains the types of the events that are sent to the webhook
+	Events []string `json:"events" bson:"events"`
+	// Secret is used to sign the payloads, the rest api only returns it once after the webhook was created
+	Secret  string    `json:"secret,omitempty" bson:"secret"`
+	Created time.Time `json:"created" bson:"created"`
+}
+
+// WebhookDelivery is a single event sent to a webhook together with the result of its latest attempt
+type WebhookDelivery struct {
+	ObjectID string          `json:"objectID,omitempty" bson:"_id,omitempty"`
+	Webhook  string          `json:"webhook" bson:"webhook"`
+	Event    string          `json:"event" bson:"event"`
+	Payload  json.RawMessage `json:"payload" bson:"payload"`
+	// Status is pending until the webhook accepted the payload or all attempts failed
+	Status   string `json:"status" bson:"status"`
+	Attempts int    `json:"attempts" bson:"attempts"`
+	// NextAttempt is the time of the next attempt of a pending delivery
+	NextAttempt time.Time `json:"nextAttempt" bson:"nextAttempt,omitempty"`
+	LastAttempt time.Time `json:"lastAttempt" bson:"lastAttempt,omitempty"`
+	// StatusCode and Error describe the response to the latest attempt
+	StatusCode int    `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	// RedeliveryOf is the delivery that was sent again manually
+	RedeliveryOf string    `json:"redeliveryOf,omitempty" bson:"redeliveryOf,omitempty"`
+	Created      time.Time `json:"created" bson:"created"`
 }
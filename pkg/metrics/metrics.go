@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+/*
+This package contains all prometheus metrics exported by the services
+Every service exposes the metrics on the /metrics endpoint of its management server
+*/
+
+const namespace = "efridge"
+
+var (
+	// HTTPRequests counts the handled http requests per chi route, method and status code
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of handled http requests",
+	}, []string{"route", "method", "code"})
+
+	// HTTPDuration observes the latency of http requests per chi route and method
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// MessagesConsumed counts the messages received from rabbitmq
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rbmq_messages_consumed_total",
+		Help:      "Number of messages received from rabbitmq",
+	}, []string{"exchange", "routing_key", "type"})
+
+	// MessagesPublished counts the messages sent to rabbitmq, result is either ok or error
+	MessagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rbmq_messages_published_total",
+		Help:      "Number of messages sent to rabbitmq",
+	}, []string{"exchange", "routing_key", "type", "result"})
+
+	// HandlerErrors counts the messages that couldn't be handled, reason is one of decode, unhandled or failed
+	HandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rbmq_handler_errors_total",
+		Help:      "Number of messages that couldn't be handled",
+	}, []string{"type", "reason"})
+
+	// HandlerDuration observes how long it takes to handle a message
+	HandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rbmq_handler_duration_seconds",
+		Help:      "Time spent handling a message",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type"})
+
+	// StorageDuration observes the latency of the storage operations per db.Client method
+	StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Latency of storage operations",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// FactoryLoad is the number of orders a factory is currently working on as seen by the delegation service
+	FactoryLoad = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "factory_load",
+		Help:      "Number of orders a factory is currently working on",
+	}, []string{"location"})
+
+	// FactoryCapacity is the number of orders a factory can work on at the same time
+	FactoryCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "factory_capacity",
+		Help:      "Number of orders a factory can work on at the same time",
+	}, []string{"location"})
+
+	// FactoryUp is 1 while the delegation service receives heartbeats of a factory and 0 after they stopped
+	FactoryUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "factory_up",
+		Help:      "Whether a factory sends heartbeats to the delegation service",
+	}, []string{"location"})
+
+	// FactoryLoadCorrections counts how often the reconciliation corrected the load of a factory
+	FactoryLoadCorrections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "factory_load_corrections_total",
+		Help:      "Number of times the tracked load of a factory differed from its real number of unfinished orders",
+	}, []string{"location"})
+
+	// Delegations counts the orders delegated to each factory by each delegation strategy
+	Delegations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delegations_total",
+		Help:      "Number of orders delegated to a factory",
+	}, []string{"strategy", "location"})
+
+	// OrderTimeouts counts the orders that missed the deadline of a step and how they were compensated
+	OrderTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_timeouts_total",
+		Help:      "Number of orders that missed the deadline of a step",
+	}, []string{"status", "action"})
+
+	// WebhookDeliveries counts the attempts to deliver an event to a webhook and their results
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of attempts to deliver an event to a webhook",
+	}, []string{"event", "result"})
+
+	// OpenTickets is the number of support tickets that haven't been resolved yet
+	OpenTickets = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "open_tickets",
+		Help:      "Number of unresolved support tickets",
+	})
+)
+
+// Instrument wraps a chi router and records the count and latency of all requests it handles
+// The router has to be wrapped instead of using a middleware, because the route pattern is only known after routing
+func Instrument(router *chi.Mux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// the router fills the route context provided here instead of creating its own
+		routeContext := chi.RouteContext(r.Context())
+		if routeContext == nil {
+			routeContext = chi.NewRouteContext()
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+		}
+		routeContext.Routes = router
+
+		writer := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		router.ServeHTTP(writer, r)
+
+		// requests that didn't match any route are grouped to keep the number of labels small
+		route := routeContext.RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		status := writer.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
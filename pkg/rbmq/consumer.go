@@ -1,46 +1,138 @@
 package rbmq
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/streadway/amqp"
+	"go.uber.org/zap"
 )
 
 // Consumer wraps a rabbitmq consumer
 type Consumer struct {
-	channel     *amqp.Channel
-	consumerTag string
-	done        chan error
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	config   Config
+	messages chan<- Message
+	closing  bool
+	active   bool
+	done     chan error
+	logger   *zap.SugaredLogger
 }
 
 // Message is a wrapper for a rabbitmq message
 type Message struct {
+	Exchange   string
 	RoutingKey string
 	Body       []byte
+	Envelope   Envelope
+
+	// ctx carries the span of the handler, it is set by the router
+	ctx context.Context
+
+	// settle acknowledges the underlying delivery, it is nil if the consumer uses auto ack
+	settle func(error)
+}
+
+// Context returns the context of the message handler, it carries the span used to trace the handler
+func (m Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Ack reports the result of handling a message back to the consumer
+// In manual ack mode the message is acknowledged if err is nil, otherwise it is retried or dead-lettered
+// With auto ack the message was already acknowledged on delivery and Ack does nothing
+func (m Message) Ack(err error) {
+	if m.settle != nil {
+		m.settle(err)
+	}
 }
 
-// initConsumer initializes and returns a new rabbitmq consumer instance
-func initConsumer(conn *amqp.Connection, config Config, messages chan<- Message) (*Consumer, error) {
-	done := make(chan error)
+// connect declares the exchange, queue and binding of the consumer on the given connection and starts consuming
+// It is called once when the consumer is created and again whenever the connection or channel has to be restored
+func (c *Consumer) connect(conn *amqp.Connection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	channel, err := conn.Channel()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	err = channel.ExchangeDeclare(
-		config.ExchangeName, // name
-		config.ExchangeType, // type
-		true,                // durable
-		false,               // auto-deleted
-		false,               // internal
-		false,               // no-wait
-		nil,                 // arguments
+		c.config.ExchangeName, // name
+		c.config.ExchangeType, // type
+		true,                  // durable
+		false,                 // auto-deleted
+		false,                 // internal
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	// limit the number of unacknowledged messages, the broker ignores this with auto ack
+	if c.config.Prefetch > 0 {
+		err = channel.Qos(
+			c.config.Prefetch, // prefetch count
+			0,                 // prefetch size
+			false,             // global
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	var queueName string
+	if c.config.ManualAck {
+		queueName, err = c.declareDurableQueues(channel)
+	} else {
+		queueName, err = c.declareExclusiveQueue(channel)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = channel.QueueBind(
+		queueName,             // queue name
+		c.config.BindingKey,   // routing key
+		c.config.ExchangeName, // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := channel.Consume(
+		queueName,            // queue
+		c.config.ConsumerTag, // consumer
+		!c.config.ManualAck,  // auto ack
+		false,                // exclusive
+		false,                // no local
+		false,                // no wait
+		nil,                  // args
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	c.channel = channel
+	c.active = true
+
+	go c.handle(conn, channel, deliveries)
+
+	return nil
+}
+
+// declareExclusiveQueue declares a server-named queue that only lives as long as the connection
+func (c *Consumer) declareExclusiveQueue(channel *amqp.Channel) (string, error) {
 	queue, err := channel.QueueDeclare(
 		"",    // name
 		false, // durable
@@ -49,60 +141,270 @@ func initConsumer(conn *amqp.Connection, config Config, messages chan<- Message)
 		false, // no-wait
 		nil,   // arguments
 	)
+	return queue.Name, err
+}
+
+// declareDurableQueues declares the durable queue used in manual ack mode together with its retry and dead letter queues
+// Rejected messages are moved to the retry queue by the broker and return to the main queue once their ttl expired
+func (c *Consumer) declareDurableQueues(channel *amqp.Channel) (string, error) {
+	_, err := channel.QueueDeclare(
+		c.config.queueName(), // name
+		true,                 // durable
+		false,                // delete when unused
+		false,                // exclusive
+		false,                // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.config.retryQueueName(),
+		},
+	)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	err = channel.QueueBind(
-		queue.Name,          // queue name
-		config.BindingKey,   // routing key
-		config.ExchangeName, // exchange
-		false,
-		nil,
+	_, err = channel.QueueDeclare(
+		c.config.retryQueueName(), // name
+		true,                      // durable
+		false,                     // delete when unused
+		false,                     // exclusive
+		false,                     // no-wait
+		amqp.Table{
+			"x-message-ttl":             int64(c.config.RetryDelay / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.config.queueName(),
+		},
 	)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	deliveries, err := channel.Consume(
-		queue.Name,         // queue
-		config.ConsumerTag, // consumer
-		true,               // auto ack
-		false,              // exclusive
-		false,              // no local
-		false,              // no wait
-		nil,                // args
+	err = channel.ExchangeDeclare(
+		c.config.deadLetterExchangeName(), // name
+		amqp.ExchangeFanout,               // type
+		true,                              // durable
+		false,                             // auto-deleted
+		false,                             // internal
+		false,                             // no-wait
+		nil,                               // arguments
 	)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	go handle(deliveries, messages, done)
+	_, err = channel.QueueDeclare(
+		c.config.deadLetterQueueName(), // name
+		true,                           // durable
+		false,                          // delete when unused
+		false,                          // exclusive
+		false,                          // no-wait
+		nil,                            // arguments
+	)
+	if err != nil {
+		return "", err
+	}
 
-	consumer := &Consumer{
-		channel:     channel,
-		consumerTag: config.ConsumerTag,
-		done:        done,
+	err = channel.QueueBind(
+		c.config.deadLetterQueueName(),    // queue name
+		"",                                // routing key
+		c.config.deadLetterExchangeName(), // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return "", err
 	}
 
-	return consumer, nil
+	return c.config.queueName(), nil
 }
 
 // handle is the function that forwards incoming messages to the message channel declared in /cmd/service/main.go
-func handle(deliveries <-chan amqp.Delivery, messages chan<- Message, done chan error) {
+func (c *Consumer) handle(conn *amqp.Connection, channel *amqp.Channel, deliveries <-chan amqp.Delivery) {
 	for delivery := range deliveries {
 		msg := Message{
+			Exchange:   delivery.Exchange,
 			RoutingKey: delivery.RoutingKey,
 			Body:       delivery.Body,
+			Envelope:   envelopeFromDelivery(delivery),
 		}
-		messages <- msg
+
+		if c.config.ManualAck {
+			delivery := delivery
+			msg.settle = func(err error) {
+				c.settle(channel, delivery, err)
+			}
+		}
+
+		c.messages <- msg
+	}
+
+	c.mu.Lock()
+	closing := c.closing
+	c.active = false
+	c.mu.Unlock()
+
+	if closing {
+		c.done <- nil
+		return
+	}
+
+	// a closed connection is restored by the session, only a closed channel on an open connection
+	// has to be restored by the consumer itself
+	if conn.IsClosed() {
+		return
+	}
+
+	c.logger.Warnw("Consumer channel was closed, reopening", "consumer", c.config.ConsumerTag)
+
+	err := c.connect(conn)
+	if err != nil {
+		c.logger.Errorw("Failed to reopen consumer channel", "consumer", c.config.ConsumerTag, "err", err)
 	}
-	done <- nil
+}
+
+// settle acknowledges a delivery based on the error returned by the message handler
+func (c *Consumer) settle(channel *amqp.Channel, delivery amqp.Delivery, handlerErr error) {
+	var err error
+
+	if handlerErr == nil {
+		err = delivery.Ack(false)
+		if err != nil {
+			c.logger.Errorw("Failed to acknowledge message", "queue", c.config.queueName(), "err", err)
+		}
+		return
+	}
+
+	retries := c.retries(delivery)
+
+	if !IsPermanent(handlerErr) && retries < c.config.MaxRetries {
+		c.logger.Warnw("Failed to handle message, scheduling retry",
+			"queue", c.config.queueName(), "retry", retries+1, "maxRetries", c.config.MaxRetries, "err", handlerErr)
+
+		// the broker moves rejected messages to the retry queue
+		err = delivery.Nack(false, false)
+		if err != nil {
+			c.logger.Errorw("Failed to reject message", "queue", c.config.queueName(), "err", err)
+		}
+		return
+	}
+
+	c.logger.Errorw("Failed to handle message, moving it to the dead letter queue",
+		"queue", c.config.queueName(), "retries", retries, "err", handlerErr)
+
+	err = c.deadLetter(channel, delivery, handlerErr, retries)
+	if err != nil {
+		c.logger.Errorw("Failed to dead-letter message, scheduling retry", "queue", c.config.queueName(), "err", err)
+		err = delivery.Nack(false, false)
+	} else {
+		err = delivery.Ack(false)
+	}
+	if err != nil {
+		c.logger.Errorw("Failed to settle message", "queue", c.config.queueName(), "err", err)
+	}
+}
+
+// retries returns how often a delivery was already rejected by this consumer's queue
+// The count is taken from the x-death header the broker adds whenever a message is dead-lettered
+func (c *Consumer) retries(delivery amqp.Delivery) int {
+	deaths, ok := delivery.Headers["x-death"].([]interface{})
+	if !ok {
+		return 0
+	}
+
+	for _, death := range deaths {
+		table, ok := death.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		if table["queue"] != c.config.queueName() || table["reason"] != "rejected" {
+			continue
+		}
+
+		if count, ok := table["count"].(int64); ok {
+			return int(count)
+		}
+	}
+
+	return 0
+}
+
+// originalRoutingKey returns the routing key a delivery was published with
+// Retried messages return from the retry queue with the queue name as routing key, so the original key is taken
+// from the x-death entry the broker added when the message was first rejected by this consumer's queue
+func (c *Consumer) originalRoutingKey(delivery amqp.Delivery) string {
+	deaths, _ := delivery.Headers["x-death"].([]interface{})
+	for _, death := range deaths {
+		table, ok := death.(amqp.Table)
+		if !ok || table["queue"] != c.config.queueName() {
+			continue
+		}
+
+		keys, _ := table["routing-keys"].([]interface{})
+		if len(keys) > 0 {
+			if key, ok := keys[0].(string); ok {
+				return key
+			}
+		}
+	}
+
+	return delivery.RoutingKey
+}
+
+// deadLetter publishes a copy of the delivery to the dead letter exchange
+// The error and the original routing information are added as headers so that the message can be inspected and replayed
+func (c *Consumer) deadLetter(channel *amqp.Channel, delivery amqp.Delivery, handlerErr error, retries int) error {
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		if key == "x-death" {
+			continue
+		}
+		headers[key] = value
+	}
+
+	headers["x-error"] = handlerErr.Error()
+	headers["x-retries"] = int64(retries)
+	headers["x-original-queue"] = c.config.queueName()
+	headers["x-original-routing-key"] = c.originalRoutingKey(delivery)
+
+	return channel.Publish(
+		c.config.deadLetterExchangeName(), // exchange
+		"",                                // routing key
+		false,                             // mandatory
+		false,                             // immediate
+		republishing(delivery, headers),
+	)
+}
+
+// republishing copies a delivery including its envelope into a persistent publishing with the given headers
+func republishing(delivery amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   delivery.ContentType,
+		Body:          delivery.Body,
+		DeliveryMode:  amqp.Persistent,
+		Timestamp:     delivery.Timestamp,
+		MessageId:     delivery.MessageId,
+		CorrelationId: delivery.CorrelationId,
+		Type:          delivery.Type,
+	}
+}
+
+// Active returns true while the consumer receives deliveries from its queue
+func (c *Consumer) Active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.active
 }
 
 // Close is used to shut down a consumer instance
 func (c *Consumer) Close() error {
-	if err := c.channel.Cancel(c.consumerTag, true); err != nil {
+	c.mu.Lock()
+	c.closing = true
+	channel := c.channel
+	c.mu.Unlock()
+
+	if err := channel.Cancel(c.config.ConsumerTag, true); err != nil {
 		return fmt.Errorf("consumer cancel failed: %s", err)
 	}
 
@@ -0,0 +1,85 @@
+package rbmq
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRetries(t *testing.T) {
+	c := &Consumer{config: Config{ExchangeName: "berlin", BindingKey: "factory"}}
+
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{name: "first delivery", want: 0},
+		{
+			name: "rejected by this queue",
+			headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{"queue": "berlin.factory.retry", "reason": "expired", "count": int64(2)},
+				amqp.Table{"queue": "berlin.factory", "reason": "rejected", "count": int64(2)},
+			}},
+			want: 2,
+		},
+		{
+			name: "rejected by another queue",
+			headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{"queue": "london.factory", "reason": "rejected", "count": int64(3)},
+			}},
+			want: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := c.retries(amqp.Delivery{Headers: test.headers}); got != test.want {
+				t.Errorf("retries = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOriginalRoutingKey(t *testing.T) {
+	c := &Consumer{config: Config{ExchangeName: "berlin", BindingKey: "#"}}
+
+	tests := []struct {
+		name     string
+		delivery amqp.Delivery
+		want     string
+	}{
+		{
+			name:     "first delivery",
+			delivery: amqp.Delivery{RoutingKey: "berlin.neworder"},
+			want:     "berlin.neworder",
+		},
+		{
+			name: "returned from the retry queue",
+			delivery: amqp.Delivery{
+				RoutingKey: "berlin.#",
+				Headers: amqp.Table{"x-death": []interface{}{
+					amqp.Table{"queue": "berlin.#.retry", "reason": "expired", "routing-keys": []interface{}{"berlin.#.retry"}},
+					amqp.Table{"queue": "berlin.#", "reason": "rejected", "routing-keys": []interface{}{"berlin.cancelorder"}},
+				}},
+			},
+			want: "berlin.cancelorder",
+		},
+		{
+			name: "x-death without routing keys",
+			delivery: amqp.Delivery{
+				RoutingKey: "berlin.neworder",
+				Headers:    amqp.Table{"x-death": []interface{}{amqp.Table{"queue": "berlin.#", "reason": "rejected"}}},
+			},
+			want: "berlin.neworder",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := c.originalRoutingKey(test.delivery); got != test.want {
+				t.Errorf("originalRoutingKey = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package rbmq
+
+import (
+	"context"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+	"github.com/streadway/amqp"
+)
+
+// SchemaVersion is the version of the message formats in messages.go
+// It has to be increased whenever a message format changes in a way that older services can't handle
+// Version 0 are messages without envelope, version 1 added the envelope and version 2 the quantity and prices of items,
+// older versions send one item per fridge, see Item.Units
+const SchemaVersion = 2
+
+// headers used to transport the envelope fields that have no matching amqp property
+const (
+	headerSchemaVersion  = "x-schema-version"
+	headerCausationID    = "x-causation-id"
+	headerSourceService  = "x-source-service"
+	headerSourceLocation = "x-source-location"
+)
+
+// Envelope contains the metadata shared by all message formats
+// It is transported in the amqp properties and headers, so the json bodies of the messages stay unchanged
+type Envelope struct {
+	MessageID     string
+	SchemaVersion int
+	Type          string
+	Timestamp     time.Time
+
+	// CorrelationID is the id of the order or ticket a message belongs to, it is shared by all messages caused by it
+	CorrelationID string
+	// CausationID is the id of the message whose handling caused this message
+	CausationID string
+
+	// Service and Location describe the service that published the message
+	Service  string
+	Location string
+
+	// Traceparent is the w3c trace context of the span that published the message
+	// For received messages the router replaces it with the span handling the message,
+	// so that messages published by the handler continue the trace
+	Traceparent string
+}
+
+// NewEnvelope returns the envelope of a message that isn't caused by another message, e.g. a new order
+// If no correlation id is given a new one is generated
+func NewEnvelope(msgType string, correlationID string) Envelope {
+	if correlationID == "" {
+		correlationID = newMessageID()
+	}
+
+	return Envelope{
+		Type:          msgType,
+		CorrelationID: correlationID,
+	}
+}
+
+// Follow returns the envelope of a message that is sent while handling the message of this envelope
+func (e Envelope) Follow(msgType string) Envelope {
+	return Envelope{
+		Type:          msgType,
+		CorrelationID: e.CorrelationID,
+		CausationID:   e.MessageID,
+		Traceparent:   e.Traceparent,
+	}
+}
+
+// WithTrace returns a copy of the envelope that continues the trace carried by the context, e.g. of a http request
+func (e Envelope) WithTrace(ctx context.Context) Envelope {
+	e.Traceparent = tracing.SpanFromContext(ctx).Context().Traceparent()
+	return e
+}
+
+// publishing fills in the properties and headers of a publishing based on the envelope
+func (e Envelope) publishing(publishing amqp.Publishing) amqp.Publishing {
+	if publishing.Headers == nil {
+		publishing.Headers = amqp.Table{}
+	}
+
+	publishing.MessageId = e.MessageID
+	publishing.CorrelationId = e.CorrelationID
+	publishing.Type = e.Type
+	publishing.Timestamp = e.Timestamp
+	publishing.Headers[headerSchemaVersion] = int32(e.SchemaVersion)
+	publishing.Headers[headerCausationID] = e.CausationID
+	publishing.Headers[headerSourceService] = e.Service
+	publishing.Headers[headerSourceLocation] = e.Location
+	if e.Traceparent != "" {
+		publishing.Headers[tracing.TraceparentHeader] = e.Traceparent
+	}
+
+	return publishing
+}
+
+// envelopeFromDelivery reads the envelope of a received message
+// Messages published without an envelope return an envelope with schema version 0
+func envelopeFromDelivery(delivery amqp.Delivery) Envelope {
+	envelope := Envelope{
+		MessageID:     delivery.MessageId,
+		Type:          delivery.Type,
+		Timestamp:     delivery.Timestamp,
+		CorrelationID: delivery.CorrelationId,
+	}
+
+	// integer headers are decoded as different types depending on their size on the wire
+	switch version := delivery.Headers[headerSchemaVersion].(type) {
+	case int8:
+		envelope.SchemaVersion = int(version)
+	case int16:
+		envelope.SchemaVersion = int(version)
+	case int32:
+		envelope.SchemaVersion = int(version)
+	case int64:
+		envelope.SchemaVersion = int(version)
+	}
+
+	envelope.CausationID, _ = delivery.Headers[headerCausationID].(string)
+	envelope.Service, _ = delivery.Headers[headerSourceService].(string)
+	envelope.Location, _ = delivery.Headers[headerSourceLocation].(string)
+	envelope.Traceparent, _ = delivery.Headers[tracing.TraceparentHeader].(string)
+
+	return envelope
+}
@@ -0,0 +1,31 @@
+package rbmq
+
+import "errors"
+
+// permanentError marks an error that can't be resolved by handling the message again
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error to signal that a message should be dead-lettered right away instead of being retried,
+// e.g. because its body can't be decoded
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if the error or one of the errors it wraps was marked as permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
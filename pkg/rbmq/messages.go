@@ -16,6 +16,11 @@ type OrderMessage struct {
 	Location     string    `json:"location,omitempty"`
 	Items        []Item    `json:"items,omitempty"`
 	CostsOfParts int       `json:"costsOfParts,omitempty"`
+	// Reason explains why an order is aborted or the outcome of a cancellation
+	Reason string `json:"reason,omitempty"`
+	// Stage is the service that reports the outcome of a cancellation and Cancelled whether it stopped its work in time
+	Stage     string `json:"stage,omitempty"`
+	Cancelled bool   `json:"cancelled,omitempty"`
 }
 
 // PartMessage contains all information about a single part
@@ -31,6 +36,21 @@ type Item struct {
 	ItemID       int   `json:"item,omitempty"`
 	Parts        []int `json:"parts,omitempty"`
 	AssemblyTime int   `json:"assemblytime,omitempty"`
+	// PartsCost and UnitPrice are the costs of parts and the price of a single fridge,
+	// Total is the price of all fridges of the item
+	PartsCost int `json:"partsCost,omitempty"`
+	Quantity  int `json:"quantity,omitempty"`
+	UnitPrice int `json:"unitPrice,omitempty"`
+	Total     int `json:"total,omitempty"`
+}
+
+// Units returns how many fridges of an item are ordered
+// Older services send one item per fridge without quantity
+func (i Item) Units() int {
+	if i.Quantity <= 0 {
+		return 1
+	}
+	return i.Quantity
 }
 
 // TicketMessage contains all information required to resolve a support ticket
@@ -41,6 +61,17 @@ type TicketMessage struct {
 	Response  string    `json:"response,omitempty"`
 }
 
+// FactoryMessage announces a factory and its capacity to the delegation service
+// It is also used to request and report the number of unfinished orders of a factory
+type FactoryMessage struct {
+	Timestamp           time.Time `json:"timestamp,omitempty"`
+	MsgType             string    `json:"type,omitempty"`
+	Location            string    `json:"location,omitempty"`
+	MaxConcurrentOrders int       `json:"maxConcurrentOrders,omitempty"`
+	OpenOrders          int       `json:"openOrders"`
+	RequestedAt         time.Time `json:"requestedAt,omitempty"`
+}
+
 // KPIMessage contains all information used to create new KPI entries
 type KPIMessage struct {
 	Timestamp        time.Time `json:"timestamp,omitempty"`
@@ -50,4 +81,5 @@ type KPIMessage struct {
 	CompletedOrders  int       `json:"completedOrders,omitempty"`
 	Total            int       `json:"total"`
 	CostsOfParts     int       `json:"costsOfParts,omitempty"`
+	Revenue          int       `json:"revenue,omitempty"`
 }
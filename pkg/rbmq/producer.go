@@ -1,63 +1,246 @@
 package rbmq
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
 	"github.com/streadway/amqp"
 )
 
+var (
+	// ErrUnroutable is returned in confirm mode if the broker couldn't route a message to any queue
+	ErrUnroutable = errors.New("message could not be routed to a queue")
+	// ErrNacked is returned in confirm mode if the broker refused to take responsibility for a message
+	ErrNacked = errors.New("message was not acknowledged by the broker")
+	// ErrConfirmTimeout is returned in confirm mode if the broker didn't confirm a message in time
+	ErrConfirmTimeout = errors.New("timed out waiting for the broker to confirm the message")
+)
+
 // Producer wraps a rabbitmq producer
 type Producer struct {
+	mu       sync.RWMutex
+	conn     *amqp.Connection
 	channel  *amqp.Channel
-	exchange string
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	sequence uint64
+
+	// publishMu serializes publishing in confirm mode so that every publish can wait for its own confirmation
+	publishMu sync.Mutex
+
+	exchange       string
+	exchangeType   string
+	confirm        bool
+	confirmTimeout time.Duration
+
+	// service and location identify the publishing service in the envelope of every message
+	service  string
+	location string
 }
 
-// initProducer initializes and returns a new producer instance
-func initProducer(conn *amqp.Connection, exchangeName string, exchangeType string) (*Producer, error) {
+// connect opens a new channel on the given connection and declares the producer's exchange
+// It is called once when the producer is created and again whenever the connection has to be restored
+func (p *Producer) connect(conn *amqp.Connection) error {
 	channel, err := conn.Channel()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	err = channel.ExchangeDeclare(
-		exchangeName, // name
-		exchangeType, // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
+		p.exchange,     // name
+		p.exchangeType, // type
+		true,           // durable
+		false,          // auto-deleted
+		false,          // internal
+		false,          // no-wait
+		nil,            // arguments
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	producer := &Producer{
-		channel:  channel,
-		exchange: exchangeName,
+	var confirms chan amqp.Confirmation
+	var returns chan amqp.Return
+
+	if p.confirm {
+		err = channel.Confirm(false)
+		if err != nil {
+			return err
+		}
+
+		// the channels are buffered so that late confirmations of timed out messages don't block the connection
+		confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 16))
+		returns = channel.NotifyReturn(make(chan amqp.Return, 16))
 	}
 
-	return producer, nil
+	p.mu.Lock()
+	p.conn = conn
+	p.channel = channel
+	p.confirms = confirms
+	p.returns = returns
+	p.sequence = 0
+	p.mu.Unlock()
+
+	return nil
 }
 
 // Publish sends a message to an exchange
-func (p *Producer) Publish(msg []byte, routingKey string) error {
-	err := p.channel.Publish(
+// The envelope is completed with a new message id, the schema version and the publishing service
+// In confirm mode the message is persistent and Publish blocks until the broker confirmed it,
+// unroutable, refused and unconfirmed messages are reported as errors
+// Messages published while the session is reconnecting fail with amqp.ErrClosed
+func (p *Producer) Publish(envelope Envelope, msg []byte, routingKey string) error {
+	envelope.MessageID = newMessageID()
+	envelope.SchemaVersion = SchemaVersion
+	envelope.Timestamp = time.Now().UTC()
+	envelope.Service = p.service
+	envelope.Location = p.location
+	if envelope.CorrelationID == "" {
+		envelope.CorrelationID = envelope.MessageID
+	}
+
+	// trace the publish as child of the span the envelope was created in, consumers continue the trace of this span
+	span := tracing.StartRemote(tracing.ParseTraceparent(envelope.Traceparent), "publish "+envelope.Type, tracing.KindProducer)
+	span.SetAttribute("messaging.destination", p.exchange)
+	span.SetAttribute("messaging.routing_key", routingKey)
+	span.SetAttribute("messaging.message_id", envelope.MessageID)
+	span.SetAttribute("messaging.conversation_id", envelope.CorrelationID)
+	envelope.Traceparent = span.Context().Traceparent()
+
+	if p.confirm {
+		p.publishMu.Lock()
+		defer p.publishMu.Unlock()
+	}
+
+	p.mu.RLock()
+	conn := p.conn
+	p.mu.RUnlock()
+
+	err := p.publish(envelope, msg, routingKey)
+
+	// a channel can be closed by the server while the connection stays open,
+	// in that case the channel is reopened and the message is sent again
+	if err == amqp.ErrClosed && !conn.IsClosed() {
+		err = p.connect(conn)
+		if err != nil {
+			metrics.MessagesPublished.WithLabelValues(p.exchange, routingKey, envelope.Type, "error").Inc()
+			span.SetError(err)
+			span.End()
+			return err
+		}
+
+		err = p.publish(envelope, msg, routingKey)
+	}
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.MessagesPublished.WithLabelValues(p.exchange, routingKey, envelope.Type, result).Inc()
+
+	span.SetError(err)
+	span.End()
+
+	return err
+}
+
+// publish sends a message on the current channel and waits for its confirmation in confirm mode
+func (p *Producer) publish(envelope Envelope, msg []byte, routingKey string) error {
+	publishing := envelope.publishing(amqp.Publishing{
+		Headers:         amqp.Table{},
+		ContentType:     "application/json",
+		ContentEncoding: "",
+		Body:            msg,
+		DeliveryMode:    amqp.Transient, // 1=non-persistent, 2=persistent
+		Priority:        0,              // 0-9
+	})
+
+	if p.confirm {
+		publishing.DeliveryMode = amqp.Persistent
+	}
+
+	p.mu.Lock()
+	channel, confirms, returns := p.channel, p.confirms, p.returns
+
+	err := channel.Publish(
 		p.exchange, // publish to an exchange
 		routingKey, // routing to 0 or more queues
-		false,      // mandatory
+		p.confirm,  // mandatory
 		false,      // immediate
-		amqp.Publishing{
-			Headers:         amqp.Table{},
-			ContentType:     "application/json",
-			ContentEncoding: "",
-			Body:            msg,
-			DeliveryMode:    amqp.Transient, // 1=non-persistent, 2=persistent
-			Priority:        0,              // 0-9
-		},
+		publishing,
 	)
-	return err
+	if err == nil && p.confirm {
+		p.sequence++
+	}
+	tag := p.sequence
+	p.mu.Unlock()
+
+	if err != nil || !p.confirm {
+		return err
+	}
+
+	return p.waitForConfirm(tag, publishing.MessageId, routingKey, confirms, returns)
+}
+
+// waitForConfirm blocks until the broker confirmed the message with the given delivery tag
+// The broker always sends a return before the confirmation if a mandatory message couldn't be routed
+func (p *Producer) waitForConfirm(tag uint64, messageID string, routingKey string, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) error {
+	timeout := time.NewTimer(p.confirmTimeout)
+	defer timeout.Stop()
+
+	var returned *amqp.Return
+
+	for {
+		select {
+		case ret := <-returns:
+			// ignore returns of earlier messages that timed out
+			if ret.MessageId == messageID {
+				returned = &ret
+			}
+
+		case confirmation, ok := <-confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+
+			// ignore confirmations of earlier messages that timed out
+			if confirmation.DeliveryTag < tag {
+				continue
+			}
+
+			if !confirmation.Ack {
+				return fmt.Errorf("%w: exchange %s, routing key %s", ErrNacked, p.exchange, routingKey)
+			}
+
+			if returned != nil {
+				return fmt.Errorf("%w: exchange %s, routing key %s: %s", ErrUnroutable, p.exchange, routingKey, returned.ReplyText)
+			}
+
+			return nil
+
+		case <-timeout.C:
+			return fmt.Errorf("%w: exchange %s, routing key %s", ErrConfirmTimeout, p.exchange, routingKey)
+		}
+	}
 }
 
 // Close shuts down the producer
 func (p *Producer) Close() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	return p.channel.Close()
 }
+
+// newMessageID generates a random id for a message
+func newMessageID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,356 @@
+package rbmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// shardQueueSize is the number of messages buffered for each worker of a router
+const shardQueueSize = 32
+
+// outcomes of handling a message, all outcomes except handled are exported as handler errors
+const (
+	outcomeHandled     = "handled"
+	outcomeFailed      = "failed"
+	outcomeDecodeError = "decode"
+	outcomeUnhandled   = "unhandled"
+)
+
+// Handler processes a single message, the returned error decides whether the message is retried
+type Handler func(msg Message) error
+
+// DecodeError is returned by typed handlers if a message body doesn't match the expected message format
+// Decode errors are permanent, the message is dead-lettered without being retried
+type DecodeError struct {
+	err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode message: %s", e.err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.err
+}
+
+// RouteStats counts how the messages of a single message type were handled
+type RouteStats struct {
+	Handled      uint64 `json:"handled"`
+	Failed       uint64 `json:"failed"`
+	DecodeErrors uint64 `json:"decodeErrors"`
+	Unhandled    uint64 `json:"unhandled"`
+}
+
+// Router dispatches incoming messages to the handler registered for their message type
+// Unknown message types, decode errors and handler errors are logged and counted in one place
+type Router struct {
+	running  int32
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	controls map[string]bool
+	stats    map[string]*RouteStats
+	workers  int
+	logger   *zap.SugaredLogger
+}
+
+// NewRouter returns a router without any handlers that processes messages with the given number of workers
+func NewRouter(workers int, logger *zap.SugaredLogger) *Router {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &Router{
+		handlers: make(map[string]Handler),
+		controls: make(map[string]bool),
+		stats:    make(map[string]*RouteStats),
+		workers:  workers,
+		logger:   logger,
+	}
+}
+
+// Handle registers the handler for a message type, see types.go for all known message types
+func (r *Router) Handle(msgType string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[msgType] = handler
+}
+
+// HandleControl registers the handler for a control message type, e.g. a cancellation
+// Control messages aren't queued behind the other messages of their order but handled immediately in their own
+// goroutine, so that they can affect a message of the same order that is still being handled
+func (r *Router) HandleControl(msgType string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[msgType] = handler
+	r.controls[msgType] = true
+}
+
+// Run blocks and dispatches every message received on the channel until it is closed
+// With more than one worker messages are handled concurrently, messages that belong to the same order or ticket
+// are always passed to the same worker so that they are still handled in the order they were received
+// Control messages bypass the workers, see HandleControl
+func (r *Router) Run(messages <-chan Message) {
+	atomic.StoreInt32(&r.running, 1)
+	defer atomic.StoreInt32(&r.running, 0)
+
+	var wg sync.WaitGroup
+
+	// launch the workers, each one handles the messages of its own shard one at a time
+	shards := make([]chan Message, r.workers)
+	for i := range shards {
+		shards[i] = make(chan Message, shardQueueSize)
+
+		wg.Add(1)
+		go func(shard <-chan Message) {
+			defer wg.Done()
+			for msg := range shard {
+				msg.Ack(r.dispatch(msg))
+			}
+		}(shards[i])
+	}
+
+	// messages without an order or ticket are distributed round robin
+	next := 0
+	for msg := range messages {
+		if r.isControl(msg) {
+			wg.Add(1)
+			go func(msg Message) {
+				defer wg.Done()
+				msg.Ack(r.dispatch(msg))
+			}(msg)
+			continue
+		}
+
+		key := shardKey(msg)
+		if key == "" {
+			shards[next] <- msg
+			next = (next + 1) % len(shards)
+			continue
+		}
+
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		shards[hash.Sum32()%uint32(len(shards))] <- msg
+	}
+
+	for _, shard := range shards {
+		close(shard)
+	}
+	wg.Wait()
+}
+
+// shardKey returns the id of the order or ticket a message belongs to, or an empty string if it belongs to neither
+func shardKey(msg Message) string {
+	ids := struct {
+		OrderID  string `json:"order"`
+		TicketID string `json:"ticketID"`
+	}{}
+
+	// messages that can't be decoded are rejected by the handler, so the error can be ignored here
+	json.Unmarshal(msg.Body, &ids)
+
+	if ids.OrderID != "" {
+		return ids.OrderID
+	}
+	return ids.TicketID
+}
+
+// isControl checks whether a message has a type that was registered with HandleControl
+func (r *Router) isControl(msg Message) bool {
+	header := struct {
+		MsgType string `json:"type"`
+	}{}
+
+	// messages that can't be decoded are rejected by the worker that handles them
+	json.Unmarshal(msg.Body, &header)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.controls[header.MsgType]
+}
+
+// Running returns true while the router dispatches messages
+func (r *Router) Running() bool {
+	return atomic.LoadInt32(&r.running) == 1
+}
+
+// Stats returns a snapshot of the message counters of each message type
+func (r *Router) Stats() map[string]RouteStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := make(map[string]RouteStats, len(r.stats))
+	for msgType, routeStats := range r.stats {
+		stats[msgType] = *routeStats
+	}
+
+	return stats
+}
+
+// dispatch determines the type of a message and passes it to the matching handler
+func (r *Router) dispatch(msg Message) error {
+	// all message formats share the type field, so it can be read without knowing the actual format
+	header := struct {
+		MsgType string `json:"type"`
+	}{}
+
+	err := json.Unmarshal(msg.Body, &header)
+	metrics.MessagesConsumed.WithLabelValues(msg.Exchange, msg.RoutingKey, header.MsgType).Inc()
+	if err != nil {
+		r.count(header.MsgType, outcomeDecodeError)
+		r.logger.Errorw("Failed to parse message", "routingKey", msg.RoutingKey, "err", err, "msg", string(msg.Body))
+		return Permanent(&DecodeError{err: err})
+	}
+
+	// reject messages of newer or invalid formats instead of misinterpreting them,
+	// the handlers understand all older formats
+	if msg.Envelope.SchemaVersion > SchemaVersion || msg.Envelope.SchemaVersion < 0 {
+		r.count(header.MsgType, outcomeDecodeError)
+		r.logger.Errorw("Unsupported schema version", "type", header.MsgType, "version", msg.Envelope.SchemaVersion, "message", msg.Envelope.MessageID)
+		return Permanent(fmt.Errorf("Unsupported schema version %d", msg.Envelope.SchemaVersion))
+	}
+
+	r.mu.RLock()
+	handler, ok := r.handlers[header.MsgType]
+	r.mu.RUnlock()
+
+	if !ok {
+		r.count(header.MsgType, outcomeUnhandled)
+		r.logger.Errorw("Unhandled message type", "type", header.MsgType, "routingKey", msg.RoutingKey, "message", msg.Envelope.MessageID)
+		return Permanent(fmt.Errorf("Unhandled message type %q", header.MsgType))
+	}
+
+	// trace the handler as child of the span that published the message
+	span := tracing.StartRemote(tracing.ParseTraceparent(msg.Envelope.Traceparent), "consume "+header.MsgType, tracing.KindConsumer)
+	span.SetAttribute("messaging.destination", msg.Exchange)
+	span.SetAttribute("messaging.routing_key", msg.RoutingKey)
+	span.SetAttribute("messaging.message_id", msg.Envelope.MessageID)
+	span.SetAttribute("messaging.conversation_id", msg.Envelope.CorrelationID)
+	msg.ctx = tracing.ContextWithSpan(context.Background(), span)
+	msg.Envelope.Traceparent = span.Context().Traceparent()
+
+	start := time.Now()
+	err = handler(msg)
+	metrics.HandlerDuration.WithLabelValues(header.MsgType).Observe(time.Since(start).Seconds())
+
+	span.SetError(err)
+	span.End()
+
+	var decodeErr *DecodeError
+	switch {
+	case err == nil:
+		r.count(header.MsgType, outcomeHandled)
+	case errors.As(err, &decodeErr):
+		r.count(header.MsgType, outcomeDecodeError)
+		r.logger.Errorw("Failed to parse message", "type", header.MsgType, "message", msg.Envelope.MessageID, "err", err, "msg", string(msg.Body))
+	default:
+		r.count(header.MsgType, outcomeFailed)
+		r.logger.Errorw("Failed to handle message", "type", header.MsgType, "message", msg.Envelope.MessageID,
+			"correlation", msg.Envelope.CorrelationID, "source", msg.Envelope.Service, "err", err)
+	}
+
+	return err
+}
+
+// count updates the counters of a message type
+func (r *Router) count(msgType string, outcome string) {
+	if outcome != outcomeHandled {
+		metrics.HandlerErrors.WithLabelValues(msgType, outcome).Inc()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.stats[msgType]
+	if !ok {
+		stats = &RouteStats{}
+		r.stats[msgType] = stats
+	}
+
+	switch outcome {
+	case outcomeHandled:
+		stats.Handled++
+	case outcomeFailed:
+		stats.Failed++
+	case outcomeDecodeError:
+		stats.DecodeErrors++
+	case outcomeUnhandled:
+		stats.Unhandled++
+	}
+}
+
+// decode unmarshals a message body into the given message format
+func decode(msg Message, v interface{}) error {
+	err := json.Unmarshal(msg.Body, v)
+	if err != nil {
+		return Permanent(&DecodeError{err: err})
+	}
+	return nil
+}
+
+// OrderHandler wraps a function that handles OrderMessages
+func OrderHandler(fn func(msg Message, orderMsg OrderMessage) error) Handler {
+	return func(msg Message) error {
+		orderMsg := OrderMessage{}
+		if err := decode(msg, &orderMsg); err != nil {
+			return err
+		}
+		return fn(msg, orderMsg)
+	}
+}
+
+// PartHandler wraps a function that handles PartMessages
+func PartHandler(fn func(msg Message, partMsg PartMessage) error) Handler {
+	return func(msg Message) error {
+		partMsg := PartMessage{}
+		if err := decode(msg, &partMsg); err != nil {
+			return err
+		}
+		return fn(msg, partMsg)
+	}
+}
+
+// TicketHandler wraps a function that handles TicketMessages
+func TicketHandler(fn func(msg Message, ticketMsg TicketMessage) error) Handler {
+	return func(msg Message) error {
+		ticketMsg := TicketMessage{}
+		if err := decode(msg, &ticketMsg); err != nil {
+			return err
+		}
+		return fn(msg, ticketMsg)
+	}
+}
+
+// KPIHandler wraps a function that handles KPIMessages
+func KPIHandler(fn func(msg Message, kpiMsg KPIMessage) error) Handler {
+	return func(msg Message) error {
+		kpiMsg := KPIMessage{}
+		if err := decode(msg, &kpiMsg); err != nil {
+			return err
+		}
+		return fn(msg, kpiMsg)
+	}
+}
+
+// FactoryHandler wraps a function that handles FactoryMessages
+func FactoryHandler(fn func(msg Message, factoryMsg FactoryMessage) error) Handler {
+	return func(msg Message) error {
+		factoryMsg := FactoryMessage{}
+		if err := decode(msg, &factoryMsg); err != nil {
+			return err
+		}
+		return fn(msg, factoryMsg)
+	}
+}
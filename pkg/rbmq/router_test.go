@@ -0,0 +1,330 @@
+package rbmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testMessage returns an in-memory message whose body is the json encoding of v
+// The result passed to Ack is sent to the acks channel if it isn't nil
+func testMessage(t *testing.T, v interface{}, acks chan<- error) Message {
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+
+	msg := Message{Body: body, Envelope: Envelope{SchemaVersion: SchemaVersion}}
+	if acks != nil {
+		msg.settle = func(err error) { acks <- err }
+	}
+	return msg
+}
+
+func TestRouterDispatch(t *testing.T) {
+	errTemporary := errors.New("temporary")
+
+	tests := []struct {
+		name          string
+		body          interface{}
+		rawBody       string
+		schemaVersion int
+		noEnvelope    bool
+		handlerErr    error
+		wantCalled    bool
+		wantErr       bool
+		wantPermanent bool
+		wantStats     RouteStats
+		statsType     string
+	}{
+		{
+			name:       "handled",
+			body:       OrderMessage{MsgType: TypeNewOrder, OrderID: "a"},
+			wantCalled: true,
+			wantStats:  RouteStats{Handled: 1},
+			statsType:  TypeNewOrder,
+		},
+		{
+			name:          "unknown type",
+			body:          OrderMessage{MsgType: "unknown", OrderID: "a"},
+			wantErr:       true,
+			wantPermanent: true,
+			wantStats:     RouteStats{Unhandled: 1},
+			statsType:     "unknown",
+		},
+		{
+			name:          "invalid json",
+			rawBody:       `{"type":`,
+			wantErr:       true,
+			wantPermanent: true,
+			wantStats:     RouteStats{DecodeErrors: 1},
+			statsType:     "",
+		},
+		{
+			name:          "body doesn't match the message format",
+			rawBody:       `{"type": "neworder", "order": 5}`,
+			wantErr:       true,
+			wantPermanent: true,
+			wantStats:     RouteStats{DecodeErrors: 1},
+			statsType:     TypeNewOrder,
+		},
+		{
+			name:          "newer schema version",
+			body:          OrderMessage{MsgType: TypeNewOrder, OrderID: "a"},
+			schemaVersion: SchemaVersion + 1,
+			wantErr:       true,
+			wantPermanent: true,
+			wantStats:     RouteStats{DecodeErrors: 1},
+			statsType:     TypeNewOrder,
+		},
+		{
+			name:       "message without envelope",
+			body:       OrderMessage{MsgType: TypeNewOrder, OrderID: "a"},
+			noEnvelope: true,
+			wantCalled: true,
+			wantStats:  RouteStats{Handled: 1},
+			statsType:  TypeNewOrder,
+		},
+		{
+			name:       "temporary handler error",
+			body:       OrderMessage{MsgType: TypeNewOrder, OrderID: "a"},
+			handlerErr: errTemporary,
+			wantCalled: true,
+			wantErr:    true,
+			wantStats:  RouteStats{Failed: 1},
+			statsType:  TypeNewOrder,
+		},
+		{
+			name:          "permanent handler error",
+			body:          OrderMessage{MsgType: TypeNewOrder, OrderID: "a"},
+			handlerErr:    Permanent(errTemporary),
+			wantCalled:    true,
+			wantErr:       true,
+			wantPermanent: true,
+			wantStats:     RouteStats{Failed: 1},
+			statsType:     TypeNewOrder,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := NewRouter(1, zap.NewNop().Sugar())
+
+			called := false
+			router.Handle(TypeNewOrder, OrderHandler(func(msg Message, orderMsg OrderMessage) error {
+				called = true
+				if orderMsg.OrderID != "a" {
+					t.Errorf("order = %q, want a", orderMsg.OrderID)
+				}
+				return test.handlerErr
+			}))
+			router.Handle(TypeKPIUpdate, KPIHandler(func(msg Message, kpiMsg KPIMessage) error {
+				t.Errorf("Message was passed to the handler of another type")
+				return nil
+			}))
+
+			msg := testMessage(t, test.body, nil)
+			if test.rawBody != "" {
+				msg.Body = []byte(test.rawBody)
+			}
+			if test.schemaVersion != 0 {
+				msg.Envelope.SchemaVersion = test.schemaVersion
+			}
+			if test.noEnvelope {
+				msg.Envelope = Envelope{}
+			}
+
+			err := router.dispatch(msg)
+
+			if called != test.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, test.wantCalled)
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("dispatch returned %v, want error %v", err, test.wantErr)
+			}
+			if IsPermanent(err) != test.wantPermanent {
+				t.Errorf("permanent = %v, want %v", IsPermanent(err), test.wantPermanent)
+			}
+			if test.handlerErr != nil && !errors.Is(err, errTemporary) {
+				t.Errorf("dispatch returned %v, want the error of the handler", err)
+			}
+			if stats := router.Stats()[test.statsType]; stats != test.wantStats {
+				t.Errorf("stats = %+v, want %+v", stats, test.wantStats)
+			}
+		})
+	}
+}
+
+func TestRouterRunAcksEveryMessage(t *testing.T) {
+	router := NewRouter(1, zap.NewNop().Sugar())
+	router.Handle(TypeNewOrder, OrderHandler(func(msg Message, orderMsg OrderMessage) error {
+		if orderMsg.OrderID == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+
+	acks := make(chan error, 3)
+	messages := make(chan Message, 3)
+	messages <- testMessage(t, OrderMessage{MsgType: TypeNewOrder, OrderID: "a"}, acks)
+	messages <- testMessage(t, OrderMessage{MsgType: TypeNewOrder, OrderID: "fail"}, acks)
+	messages <- testMessage(t, OrderMessage{MsgType: "unknown"}, acks)
+	close(messages)
+
+	router.Run(messages)
+	close(acks)
+
+	var results []error
+	for err := range acks {
+		results = append(results, err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("got %d acks, want 3", len(results))
+	}
+	if results[0] != nil {
+		t.Errorf("first message acked with %v, want nil", results[0])
+	}
+	if results[1] == nil || IsPermanent(results[1]) {
+		t.Errorf("second message acked with %v, want a temporary error", results[1])
+	}
+	if !IsPermanent(results[2]) {
+		t.Errorf("third message acked with %v, want a permanent error", results[2])
+	}
+	if router.Running() {
+		t.Errorf("router still running after the channel was closed")
+	}
+}
+
+func TestRouterKeepsOrderWithinShard(t *testing.T) {
+	const (
+		orders   = 8
+		messages = 50
+	)
+
+	router := NewRouter(4, zap.NewNop().Sugar())
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	router.Handle(TypeOrderUpdate, OrderHandler(func(msg Message, orderMsg OrderMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		received[orderMsg.OrderID] = append(received[orderMsg.OrderID], orderMsg.CostsOfParts)
+		return nil
+	}))
+
+	// the messages of all orders are interleaved, the costs carry the position of a message within its order
+	var sent []Message
+	for i := 0; i < messages; i++ {
+		for order := 0; order < orders; order++ {
+			sent = append(sent, testMessage(t, OrderMessage{
+				MsgType:      TypeOrderUpdate,
+				OrderID:      fmt.Sprintf("order-%d", order),
+				CostsOfParts: i,
+			}, nil))
+		}
+	}
+
+	input := make(chan Message)
+	go func() {
+		for _, msg := range sent {
+			input <- msg
+		}
+		close(input)
+	}()
+
+	router.Run(input)
+
+	if len(received) != orders {
+		t.Fatalf("received messages of %d orders, want %d", len(received), orders)
+	}
+	for order, positions := range received {
+		if len(positions) != messages {
+			t.Errorf("%s: received %d messages, want %d", order, len(positions), messages)
+			continue
+		}
+		for i, position := range positions {
+			if position != i {
+				t.Errorf("%s: message %d received at position %d", order, position, i)
+				break
+			}
+		}
+	}
+}
+
+func TestShardKey(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "order", body: `{"type": "orderupdate", "order": "a"}`, want: "a"},
+		{name: "ticket", body: `{"type": "newticket", "ticketID": "t"}`, want: "t"},
+		{name: "neither", body: `{"type": "kpiupdate"}`, want: ""},
+		{name: "invalid json", body: `{`, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := shardKey(Message{Body: []byte(test.body)}); got != test.want {
+				t.Errorf("shardKey = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRouterHandlesControlMessagesImmediately(t *testing.T) {
+	router := NewRouter(1, zap.NewNop().Sugar())
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	stopped := make(chan bool, 1)
+
+	// the handler blocks until the order is cancelled, like the part and assembly services do
+	router.Handle(TypeOrderPart, OrderHandler(func(msg Message, orderMsg OrderMessage) error {
+		close(started)
+		select {
+		case <-cancelled:
+			stopped <- true
+		case <-time.After(5 * time.Second):
+			stopped <- false
+		}
+		return nil
+	}))
+	router.HandleControl(TypeCancelOrder, OrderHandler(func(msg Message, orderMsg OrderMessage) error {
+		close(cancelled)
+		return nil
+	}))
+
+	acks := make(chan error, 2)
+	work := testMessage(t, OrderMessage{MsgType: TypeOrderPart, OrderID: "a"}, acks)
+	cancel := testMessage(t, OrderMessage{MsgType: TypeCancelOrder, OrderID: "a"}, acks)
+
+	messages := make(chan Message)
+	done := make(chan struct{})
+	go func() {
+		router.Run(messages)
+		close(done)
+	}()
+
+	messages <- work
+	<-started
+	messages <- cancel
+	close(messages)
+	<-done
+
+	if !<-stopped {
+		t.Errorf("handler wasn't stopped by the cancellation of its order")
+	}
+	if len(acks) != 2 {
+		t.Errorf("got %d acks, want 2", len(acks))
+	}
+	if stats := router.Stats()[TypeCancelOrder]; stats.Handled != 1 {
+		t.Errorf("cancellation stats = %+v, want handled", stats)
+	}
+}
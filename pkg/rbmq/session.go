@@ -1,17 +1,55 @@
 package rbmq
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 )
 
+const (
+	// minReconnectDelay is the time waited before the first reconnection attempt
+	minReconnectDelay = 1 * time.Second
+	// maxReconnectDelay is the upper limit of the exponential reconnection backoff
+	maxReconnectDelay = 30 * time.Second
+)
+
+// deadLetterHeaders are the headers added by the broker and the consumer when a message is dead-lettered
+var deadLetterHeaders = map[string]bool{
+	"x-death":                true,
+	"x-error":                true,
+	"x-retries":              true,
+	"x-original-queue":       true,
+	"x-original-routing-key": true,
+}
+
+// State describes the connection state of a session
+type State string
+
+const (
+	// StateConnected means the session has an open connection and all producers and consumers are usable
+	StateConnected State = "connected"
+	// StateReconnecting means the connection was lost and the session is trying to restore it
+	StateReconnecting State = "reconnecting"
+	// StateClosed means the session was shut down on purpose
+	StateClosed State = "closed"
+)
+
 // Session wraps a amqp connection and its config
+// The session watches its connection and restores all producers and consumers it created after a connection loss
 type Session struct {
-	conn   *amqp.Connection
-	config Config
+	mu         sync.RWMutex
+	conn       *amqp.Connection
+	config     Config
+	url        string
+	state      State
+	reconnects int
+	producers  []*Producer
+	consumers  []*Consumer
+	logger     *zap.SugaredLogger
 }
 
 // Config contains the data required to establish a connection to a rabbitmq server
@@ -23,6 +61,49 @@ type Config struct {
 	ExchangeType string
 	BindingKey   string
 	ConsumerTag  string
+
+	// ServiceName identifies the service in the envelope of all published messages
+	ServiceName string
+
+	// Workers is the number of messages a service handles concurrently, Prefetch limits the number of
+	// unacknowledged messages the broker delivers to the consumer and only has an effect in manual ack mode
+	Workers  int
+	Prefetch int
+
+	// ManualAck switches the consumer to a durable named queue with explicit acknowledgements,
+	// failed messages are retried MaxRetries times with RetryDelay in between and dead-lettered afterwards
+	ManualAck  bool
+	QueueName  string
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// PublisherConfirms makes every producer publish persistent messages and wait up to ConfirmTimeout
+	// until the broker confirmed them
+	PublisherConfirms bool
+	ConfirmTimeout    time.Duration
+}
+
+// queueName returns the name of the durable queue used in manual ack mode
+func (c Config) queueName() string {
+	if c.QueueName != "" {
+		return c.QueueName
+	}
+	return c.ExchangeName + "." + c.BindingKey
+}
+
+// retryQueueName returns the name of the queue failed messages wait in until they are retried
+func (c Config) retryQueueName() string {
+	return c.queueName() + ".retry"
+}
+
+// deadLetterExchangeName returns the name of the exchange messages are sent to after all retries failed
+func (c Config) deadLetterExchangeName() string {
+	return c.queueName() + ".dlx"
+}
+
+// deadLetterQueueName returns the name of the queue that collects all dead-lettered messages
+func (c Config) deadLetterQueueName() string {
+	return c.queueName() + ".dead"
 }
 
 // NewSession connects to the rabbitmq server and returns an active session
@@ -40,22 +121,229 @@ func NewSession(config Config, logger *zap.SugaredLogger) (*Session, error) {
 		session := &Session{
 			conn:   conn,
 			config: config,
+			url:    amqpURL,
+			state:  StateConnected,
+			logger: logger,
 		}
+
+		// launch a new thread that restores the connection as soon as it is lost
+		go session.watch(conn)
+
 		return session, nil
 	}
 }
 
 // NewConsumer uses the sessions connection to connect a new consumer to the rabbitmq server
 func (s *Session) NewConsumer(messages chan<- Message) (*Consumer, error) {
-	return initConsumer(s.conn, s.config, messages)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	consumer := &Consumer{
+		config:   s.config,
+		messages: messages,
+		done:     make(chan error, 1),
+		logger:   s.logger,
+	}
+
+	err := consumer.connect(s.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	s.consumers = append(s.consumers, consumer)
+	return consumer, nil
 }
 
 // NewProducer uses the sessions connection to connect a new producer to the rabbitmq server
 func (s *Session) NewProducer(exchangeName string, exchangeType string) (*Producer, error) {
-	return initProducer(s.conn, exchangeName, exchangeType)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	producer := &Producer{
+		exchange:       exchangeName,
+		exchangeType:   exchangeType,
+		confirm:        s.config.PublisherConfirms,
+		confirmTimeout: s.config.ConfirmTimeout,
+		service:        s.config.ServiceName,
+		location:       s.config.ExchangeName,
+	}
+
+	err := producer.connect(s.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	s.producers = append(s.producers, producer)
+	return producer, nil
+}
+
+// State returns the current connection state of the session
+func (s *Session) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state
+}
+
+// Reconnects returns how often the session restored a lost connection
+func (s *Session) Reconnects() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.reconnects
 }
 
 // Close shuts down the session and closes the connection
 func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = StateClosed
 	return s.conn.Close()
 }
+
+// ReplayDeadLetters moves all dead-lettered messages of the session's consumer back into its queue
+// It returns the number of replayed messages and only works if manual ack mode is enabled
+func (s *Session) ReplayDeadLetters() (int, error) {
+	if !s.config.ManualAck {
+		return 0, errors.New("Dead letter queues are only available in manual ack mode")
+	}
+
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	replayed := 0
+	for {
+		delivery, ok, err := channel.Get(s.config.deadLetterQueueName(), false)
+		if err != nil {
+			return replayed, err
+		}
+
+		// the queue is empty
+		if !ok {
+			return replayed, nil
+		}
+
+		// remove the headers added while dead-lettering so that the message starts with a fresh retry count
+		headers := amqp.Table{}
+		for key, value := range delivery.Headers {
+			if deadLetterHeaders[key] {
+				continue
+			}
+			headers[key] = value
+		}
+
+		// publish the message directly to the consumer's queue using the default exchange
+		err = channel.Publish(
+			"",                   // exchange
+			s.config.queueName(), // routing key
+			false,                // mandatory
+			false,                // immediate
+			republishing(delivery, headers),
+		)
+		if err != nil {
+			delivery.Nack(false, true)
+			return replayed, err
+		}
+
+		err = delivery.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+
+		replayed++
+	}
+}
+
+// watch blocks until the connection is closed and reconnects if the session wasn't closed on purpose
+func (s *Session) watch(conn *amqp.Connection) {
+	for {
+		reason := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		s.mu.Lock()
+		if s.state == StateClosed {
+			s.mu.Unlock()
+			return
+		}
+		s.state = StateReconnecting
+		s.mu.Unlock()
+
+		s.logger.Warnw("Lost connection to RabbitMQ, reconnecting", "reason", reason)
+
+		conn = s.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials the rabbitmq server with an exponential backoff until the connection and all
+// producers and consumers are restored
+// It returns nil if the session is closed in the meantime
+func (s *Session) reconnect() *amqp.Connection {
+	delay := minReconnectDelay
+
+	for {
+		time.Sleep(delay)
+
+		if s.State() == StateClosed {
+			return nil
+		}
+
+		conn, err := amqp.Dial(s.url)
+		if err == nil {
+			err = s.restore(conn)
+			if err == nil {
+				return conn
+			}
+			conn.Close()
+		}
+
+		s.logger.Warnw("Failed to reconnect to RabbitMQ, retrying", "err", err, "delay", delay)
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// restore redeclares the exchanges, queues, bindings and consumers of every producer and consumer on a new connection
+func (s *Session) restore(conn *amqp.Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == StateClosed {
+		conn.Close()
+		return nil
+	}
+
+	for _, producer := range s.producers {
+		err := producer.connect(conn)
+		if err != nil {
+			return fmt.Errorf("Failed to restore producer for exchange %s: %s", producer.exchange, err)
+		}
+	}
+
+	for _, consumer := range s.consumers {
+		err := consumer.connect(conn)
+		if err != nil {
+			return fmt.Errorf("Failed to restore consumer %s: %s", consumer.config.ConsumerTag, err)
+		}
+	}
+
+	s.conn = conn
+	s.state = StateConnected
+	s.reconnects++
+
+	s.logger.Infow("Restored connection to RabbitMQ", "reconnects", s.reconnects)
+
+	return nil
+}
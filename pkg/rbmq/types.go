@@ -0,0 +1,70 @@
+package rbmq
+
+/*
+This file is the catalogue of all message types sent between the services
+The type of a message is stored in the "type" field of its json body and decides which handler processes it
+*/
+
+const (
+	// TypeDelegate is an OrderMessage sent from the order service to the delegation service
+	// to forward a newly created order to one of the factories
+	TypeDelegate = "delegate"
+
+	// TypeNewOrder is an OrderMessage sent from the delegation service to the factory that has to produce the order
+	TypeNewOrder = "neworder"
+
+	// TypeOrderPart is an OrderMessage sent from a factory to its part service to order all parts of an order
+	TypeOrderPart = "orderpart"
+
+	// TypeOrderUpdate is an OrderMessage that reports the new status of an order
+	// It is exchanged between factory, part, assembly and shipping services and finally sent to the
+	// order and delegation services in the headquarter
+	TypeOrderUpdate = "orderupdate"
+
+	// TypeRedelegate is an OrderMessage sent from the order service to the delegation service when the factory
+	// of an order didn't accept it in time, the order is taken from that factory and delegated to another one
+	TypeRedelegate = "redelegate"
+
+	// TypeAbortOrder is an OrderMessage sent from the order service to the delegation service when a step of an order
+	// missed its deadline, the delegation service releases the load of the factory and forwards the message to it
+	TypeAbortOrder = "abortorder"
+
+	// TypeCancelOrder is an OrderMessage sent from the order service to the delegation service when a customer cancels an order
+	// The delegation service forwards it to the factory of the order, which forwards it to its part or assembly service
+	TypeCancelOrder = "cancelorder"
+
+	// TypeCancelResult is an OrderMessage that reports whether a stage stopped its work on a cancelled order in time
+	// Part and assembly services report to their factory, factories and the delegation service report to the headquarter
+	TypeCancelResult = "cancelresult"
+
+	// TypeReleaseParts is an OrderMessage sent from a factory to its part service to return the parts of an aborted order
+	TypeReleaseParts = "releaseparts"
+
+	// TypeRequestKPI is a KPIMessage sent from the kpi service to every factory to request their current kpis
+	TypeRequestKPI = "requestkpi"
+
+	// TypeKPIUpdate is a KPIMessage sent from a factory to the kpi service as response to TypeRequestKPI
+	TypeKPIUpdate = "kpiupdate"
+
+	// TypeUpdatePart is a PartMessage sent from the model service to every part service when the price of a part changed
+	TypeUpdatePart = "updatepart"
+
+	// TypeNewTicket is a TicketMessage sent from the ticket service to a support center to resolve a new ticket
+	TypeNewTicket = "newticket"
+
+	// TypeRegisterFactory is a FactoryMessage sent from a factory to the delegation service when the factory starts
+	TypeRegisterFactory = "registerfactory"
+
+	// TypeHeartbeat is a FactoryMessage a factory periodically sends to the delegation service,
+	// factories whose heartbeats stop don't receive new orders
+	TypeHeartbeat = "heartbeat"
+
+	// TypeRequestLoad is a FactoryMessage sent from the delegation service to a factory to request its number of unfinished orders
+	TypeRequestLoad = "requestload"
+
+	// TypeLoadReport is a FactoryMessage sent from a factory to the delegation service as response to TypeRequestLoad
+	TypeLoadReport = "loadreport"
+
+	// TypeResolve is a TicketMessage sent from a support center to the ticket service with the response to a ticket
+	TypeResolve = "resolve"
+)
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
+	"github.com/go-chi/chi/middleware"
+)
+
+// error codes returned by the rest apis of all services
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeInternal         = "internal_error"
+	CodeUnavailable      = "unavailable"
+)
+
+// APIError is the json body the rest apis of all services return for failed requests
+type APIError struct {
+	// Status is the http status code of the response, it is not part of the body
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID is the id chi assigned to the request, it is also written to the logs
+	RequestID string `json:"requestID,omitempty"`
+	// Fields lists the invalid fields of a request that failed validation
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface, so handlers can return api errors like any other error
+func (e *APIError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, fmt.Sprintf("%s %s", field.Field, field.Message))
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(fields, ", "))
+}
+
+// NewAPIError creates an error that is returned to the client with the given status code
+func NewAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// InvalidBody creates an error for a request body that can't be decoded
+func InvalidBody(err error) *APIError {
+	return NewAPIError(http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("Invalid request body: %s", err))
+}
+
+// ValidationFailed creates an error for a request whose fields are invalid
+func ValidationFailed(fields ...FieldError) *APIError {
+	apiErr := NewAPIError(http.StatusUnprocessableEntity, CodeValidationFailed, "The request is invalid")
+	apiErr.Fields = fields
+	return apiErr
+}
+
+// HandleAPIError logs a failed request and answers it with an APIError
+// Errors that already are api errors keep their status and message, missing database entries are answered
+// with 404 and all other errors with 500 and the given message, so internal details don't reach the client
+func (s *Service) HandleAPIError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		copied := *apiErr
+		apiErr = &copied
+	case db.IsNotFound(err):
+		apiErr = NewAPIError(http.StatusNotFound, CodeNotFound, msg)
+	default:
+		apiErr = NewAPIError(http.StatusInternalServerError, CodeInternal, msg)
+	}
+	apiErr.RequestID = middleware.GetReqID(r.Context())
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		s.Logger.Errorw(msg, "status", apiErr.Status, "requestID", apiErr.RequestID, "err", err)
+	} else {
+		s.Logger.Infow(msg, "status", apiErr.Status, "requestID", apiErr.RequestID, "err", err)
+	}
+
+	body, err := json.Marshal(apiErr)
+	if err != nil {
+		body = []byte(fmt.Sprintf(`{"code":"%s","message":"Failed to marshal error"}`, CodeInternal))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(body)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Health is the response body of the health and readiness endpoints
+type Health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// InitManagement starts a http server on the management address that reports the health and metrics of the service
+// It is started for every service, including the ones without a business api
+func (s *Service) InitManagement() {
+	router := chi.NewRouter()
+	router.Get("/healthz", s.getHealth)
+	router.Get("/readyz", s.getReadiness)
+	router.Handle("/metrics", promhttp.Handler())
+
+	s.Management = &http.Server{Addr: s.Config.ManagementAddr, Handler: router}
+	err := s.Management.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		s.Logger.Fatalw("Failed to start management server", "err", err)
+	}
+}
+
+// getHealth reports that the service is alive, dependencies are only checked by the readiness endpoint
+func (s *Service) getHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, Health{Status: statusOK})
+}
+
+// getReadiness reports whether the service is able to handle requests and messages
+func (s *Service) getReadiness(w http.ResponseWriter, r *http.Request) {
+	health := s.Readiness()
+	writeHealth(w, health)
+}
+
+// Readiness checks the rabbitmq connection, the consumer, the message router and the database
+func (s *Service) Readiness() Health {
+	checks := make(map[string]string)
+	ready := true
+
+	// the rabbitmq connection is restored automatically, the service isn't ready while it reconnects
+	// services that don't use rabbitmq close their session on purpose
+	state := s.RbmqSession.State()
+	checks["rabbitmq"] = string(state)
+	if state == rbmq.StateReconnecting {
+		ready = false
+	}
+
+	// the consumer channel has to be open and its messages have to be dispatched
+	if state != rbmq.StateClosed {
+		checks["consumer"] = statusOK
+		if !s.Consumer.Active() || !s.Router.Running() {
+			checks["consumer"] = statusUnavailable
+			ready = false
+		}
+	}
+
+	// services without a database don't initialize a storage
+	if s.Storage != nil {
+		checks["storage"] = statusOK
+		if err := s.Storage.Ping(); err != nil {
+			checks["storage"] = err.Error()
+			ready = false
+		}
+	}
+
+	health := Health{Status: statusOK, Checks: checks}
+	if !ready {
+		health.Status = statusUnavailable
+	}
+
+	return health
+}
+
+// writeHealth encodes a health response, unhealthy services respond with 503
+func writeHealth(w http.ResponseWriter, health Health) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if health.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(health)
+}
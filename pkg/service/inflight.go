@@ -0,0 +1,72 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// earlyCancelRetention is how long a cancellation of an order that wasn't started yet is remembered
+const earlyCancelRetention = time.Hour
+
+// InFlight tracks the orders a service is currently working on so that the work on an order can be stopped
+// when the order is cancelled
+type InFlight struct {
+	mu sync.Mutex
+	// orders maps the id of each order in progress to whether it was cancelled
+	orders map[string]bool
+	// early contains the cancellations of orders that weren't started yet, cancellations are handled
+	// as soon as they are received, so they can overtake the message that starts the work on an order
+	early map[string]time.Time
+}
+
+// NewInFlight returns an empty tracker
+func NewInFlight() *InFlight {
+	return &InFlight{orders: make(map[string]bool), early: make(map[string]time.Time)}
+}
+
+// Start marks an order as in progress, an order that was cancelled before it was started is cancelled right away
+func (f *InFlight) Start(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cancelled, ok := f.early[id]
+	f.orders[id] = ok && time.Since(cancelled) < earlyCancelRetention
+	delete(f.early, id)
+}
+
+// Done removes an order once the work on it stopped
+func (f *InFlight) Done(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.orders, id)
+}
+
+// Cancel marks an order in progress as cancelled, it returns false if the order isn't in progress
+// The cancellation of an order that isn't in progress is remembered in case the order is started later
+func (f *InFlight) Cancel(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.orders[id]; ok {
+		f.orders[id] = true
+		return true
+	}
+
+	// most of these orders were already finished, so old cancellations are dropped
+	now := time.Now()
+	for early, cancelled := range f.early {
+		if now.Sub(cancelled) >= earlyCancelRetention {
+			delete(f.early, early)
+		}
+	}
+	f.early[id] = now
+
+	return false
+}
+
+// Cancelled checks whether the work on an order should be stopped
+func (f *InFlight) Cancelled(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.orders[id]
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+	f := NewInFlight()
+
+	f.Start("a")
+	if f.Cancelled("a") {
+		t.Errorf("started order is cancelled")
+	}
+	if !f.Cancel("a") {
+		t.Errorf("Cancel of an order in progress returned false")
+	}
+	if !f.Cancelled("a") {
+		t.Errorf("order isn't cancelled after Cancel")
+	}
+
+	f.Done("a")
+	if f.Cancelled("a") {
+		t.Errorf("finished order is still cancelled")
+	}
+}
+
+func TestInFlightCancelBeforeStart(t *testing.T) {
+	f := NewInFlight()
+
+	if f.Cancel("a") {
+		t.Errorf("Cancel of an order that wasn't started returned true")
+	}
+
+	f.Start("a")
+	if !f.Cancelled("a") {
+		t.Errorf("order cancelled before it was started isn't cancelled")
+	}
+	f.Done("a")
+
+	// the cancellation only applies to the next start
+	f.Start("a")
+	if f.Cancelled("a") {
+		t.Errorf("order is cancelled again after it was restarted")
+	}
+	f.Done("a")
+
+	// old cancellations belong to orders that were finished long ago
+	f.early["b"] = time.Now().Add(-earlyCancelRetention)
+	f.Start("b")
+	if f.Cancelled("b") {
+		t.Errorf("order is cancelled by an expired cancellation")
+	}
+}
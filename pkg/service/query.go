@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+)
+
+// invalidParameter creates an error for a query parameter that can't be used
+func invalidParameter(name string, message string) *APIError {
+	apiErr := NewAPIError(http.StatusBadRequest, CodeInvalidParameter, "Invalid query parameter "+name)
+	apiErr.Fields = []FieldError{{Field: name, Message: message}}
+	return apiErr
+}
+
+// ParsePage reads the page of a list request from the query parameters limit and offset
+// Requests without both parameters get all entries like before lists were paged, requests with only an offset
+// get DefaultLimit entries, larger limits than MaxLimit are rejected
+func ParsePage(r *http.Request) (query.Page, error) {
+	params := r.URL.Query()
+	if params.Get("limit") == "" && params.Get("offset") == "" {
+		return query.Page{}, nil
+	}
+
+	page := query.Page{Limit: query.DefaultLimit}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > query.MaxLimit {
+			return page, invalidParameter("limit", fmt.Sprintf("must be a number between 1 and %d", query.MaxLimit))
+		}
+		page.Limit = limit
+	}
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return page, invalidParameter("offset", "must be a number that isn't negative")
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// ParseSort reads the sort order of a list request from the query parameter sort
+// A field with a leading "-" is sorted in descending order, requests without sort parameter use the fallback
+func ParseSort(r *http.Request, fallback query.Sort, fields ...string) (query.Sort, error) {
+	value := r.URL.Query().Get("sort")
+	if value == "" {
+		return fallback, nil
+	}
+
+	sort := query.Sort{Field: strings.TrimPrefix(value, "-"), Descending: strings.HasPrefix(value, "-")}
+	for _, field := range fields {
+		if field == sort.Field {
+			return sort, nil
+		}
+	}
+
+	return fallback, invalidParameter("sort", "must be one of "+strings.Join(fields, ", "))
+}
+
+// ParseTimeRange reads a time range from the query parameters <name>From and <name>To
+// Both times are RFC 3339 timestamps like 2020-07-08T12:00:00Z
+func ParseTimeRange(r *http.Request, name string) (query.TimeRange, error) {
+	var timeRange query.TimeRange
+	var err error
+
+	for _, bound := range []struct {
+		param  string
+		target *time.Time
+	}{{name + "From", &timeRange.From}, {name + "To", &timeRange.To}} {
+		value := r.URL.Query().Get(bound.param)
+		if value == "" {
+			continue
+		}
+
+		*bound.target, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return timeRange, invalidParameter(bound.param, "must be a RFC 3339 timestamp like 2020-07-08T12:00:00Z")
+		}
+	}
+
+	if !timeRange.From.IsZero() && !timeRange.To.IsZero() && !timeRange.From.Before(timeRange.To) {
+		return timeRange, invalidParameter(name+"To", "must be after "+name+"From")
+	}
+
+	return timeRange, nil
+}
+
+// ParseList reads a comma separated list from a query parameter, the parameter may be repeated as well
+func ParseList(r *http.Request, name string) []string {
+	var values []string
+	for _, param := range r.URL.Query()[name] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// WritePage adds the number of all matching entries and the links to the neighbouring pages to a list response
+// The total is sent as X-Total-Count header and the links as Link header, so the body stays a plain list
+func WritePage(w http.ResponseWriter, r *http.Request, page query.Page, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	// a page without limit contains all remaining entries
+	if page.Limit <= 0 {
+		return
+	}
+
+	var links []string
+	link := func(offset int, rel string) {
+		params := r.URL.Query()
+		params.Set("limit", strconv.Itoa(page.Limit))
+		params.Set("offset", strconv.Itoa(offset))
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, params.Encode(), rel))
+	}
+
+	if page.Offset+page.Limit < total {
+		link(page.Offset+page.Limit, "next")
+	}
+	if page.Offset > 0 {
+		previous := page.Offset - page.Limit
+		if previous < 0 {
+			previous = 0
+		}
+		link(previous, "prev")
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+)
+
+// wantInvalidParameter checks that an error rejects the given query parameter with 400 invalid_parameter
+func wantInvalidParameter(t *testing.T, err error, param string) {
+	t.Helper()
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want an APIError", err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Code != CodeInvalidParameter {
+		t.Errorf("status = %d %s, want 400 %s", apiErr.Status, apiErr.Code, CodeInvalidParameter)
+	}
+	if len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != param {
+		t.Errorf("fields = %+v, want %s", apiErr.Fields, param)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		want      query.Page
+		wantParam string
+	}{
+		{name: "all entries without parameters", url: "/", want: query.Page{}},
+		{name: "default limit with offset", url: "/?offset=20", want: query.Page{Limit: query.DefaultLimit, Offset: 20}},
+		{name: "limit", url: "/?limit=10", want: query.Page{Limit: 10}},
+		{name: "limit and offset", url: "/?limit=10&offset=20", want: query.Page{Limit: 10, Offset: 20}},
+		{name: "largest limit", url: "/?limit=500", want: query.Page{Limit: query.MaxLimit}},
+		{name: "limit too large", url: "/?limit=501", wantParam: "limit"},
+		{name: "zero limit", url: "/?limit=0", wantParam: "limit"},
+		{name: "limit isn't a number", url: "/?limit=ten", wantParam: "limit"},
+		{name: "negative offset", url: "/?offset=-1", wantParam: "offset"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := ParsePage(httptest.NewRequest(http.MethodGet, test.url, nil))
+			if test.wantParam != "" {
+				wantInvalidParameter(t, err, test.wantParam)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParsePage returned %v", err)
+			}
+			if page != test.want {
+				t.Errorf("page = %+v, want %+v", page, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	fallback := query.Sort{Field: query.SortCreated, Descending: true}
+
+	tests := []struct {
+		name      string
+		url       string
+		want      query.Sort
+		wantParam string
+	}{
+		{name: "fallback", url: "/", want: fallback},
+		{name: "ascending", url: "/?sort=lastUpdate", want: query.Sort{Field: query.SortLastUpdate}},
+		{name: "descending", url: "/?sort=-created", want: query.Sort{Field: query.SortCreated, Descending: true}},
+		{name: "unknown field", url: "/?sort=price", wantParam: "sort"},
+		{name: "only the prefix", url: "/?sort=-", wantParam: "sort"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sort, err := ParseSort(httptest.NewRequest(http.MethodGet, test.url, nil), fallback, query.SortCreated, query.SortLastUpdate)
+			if test.wantParam != "" {
+				wantInvalidParameter(t, err, test.wantParam)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseSort returned %v", err)
+			}
+			if sort != test.want {
+				t.Errorf("sort = %+v, want %+v", sort, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	from := time.Date(2020, 7, 8, 12, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 7, 9, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		url       string
+		want      query.TimeRange
+		wantParam string
+	}{
+		{name: "open range", url: "/"},
+		{name: "from", url: "/?createdFrom=2020-07-08T12:00:00Z", want: query.TimeRange{From: from}},
+		{name: "to", url: "/?createdTo=2020-07-09T12:00:00Z", want: query.TimeRange{To: to}},
+		{
+			name: "from and to",
+			url:  "/?createdFrom=2020-07-08T12:00:00Z&createdTo=2020-07-09T12:00:00Z",
+			want: query.TimeRange{From: from, To: to},
+		},
+		{
+			name: "with time zone",
+			url:  "/?createdFrom=2020-07-08T14:00:00%2B02:00",
+			want: query.TimeRange{From: from},
+		},
+		{name: "invalid from", url: "/?createdFrom=2020-07-08", wantParam: "createdFrom"},
+		{name: "invalid to", url: "/?createdTo=yesterday", wantParam: "createdTo"},
+		{
+			name:      "to before from",
+			url:       "/?createdFrom=2020-07-09T12:00:00Z&createdTo=2020-07-08T12:00:00Z",
+			wantParam: "createdTo",
+		},
+		{
+			name:      "empty range",
+			url:       "/?createdFrom=2020-07-08T12:00:00Z&createdTo=2020-07-08T12:00:00Z",
+			wantParam: "createdTo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeRange, err := ParseTimeRange(httptest.NewRequest(http.MethodGet, test.url, nil), "created")
+			if test.wantParam != "" {
+				wantInvalidParameter(t, err, test.wantParam)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseTimeRange returned %v", err)
+			}
+			if !timeRange.From.Equal(test.want.From) || !timeRange.To.Equal(test.want.To) {
+				t.Errorf("range = %+v, want %+v", timeRange, test.want)
+			}
+		})
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		page     query.Page
+		total    int
+		wantLink string
+	}{
+		{
+			name:  "single page",
+			url:   "/orders",
+			page:  query.Page{Limit: 50},
+			total: 10,
+		},
+		{
+			name:  "all entries",
+			url:   "/orders",
+			page:  query.Page{},
+			total: 25,
+		},
+		{
+			name:     "first page",
+			url:      "/orders",
+			page:     query.Page{Limit: 10},
+			total:    25,
+			wantLink: `</orders?limit=10&offset=10>; rel="next"`,
+		},
+		{
+			name:     "middle page keeps the other parameters",
+			url:      "/orders?status=complete&limit=10&offset=10",
+			page:     query.Page{Limit: 10, Offset: 10},
+			total:    25,
+			wantLink: `</orders?limit=10&offset=20&status=complete>; rel="next", </orders?limit=10&offset=0&status=complete>; rel="prev"`,
+		},
+		{
+			name:     "last page",
+			url:      "/orders",
+			page:     query.Page{Limit: 10, Offset: 20},
+			total:    25,
+			wantLink: `</orders?limit=10&offset=10>; rel="prev"`,
+		},
+		{
+			name:     "offset between two pages",
+			url:      "/orders",
+			page:     query.Page{Limit: 10, Offset: 5},
+			total:    25,
+			wantLink: `</orders?limit=10&offset=15>; rel="next", </orders?limit=10&offset=0>; rel="prev"`,
+		},
+		{
+			name:     "offset behind the list",
+			url:      "/orders",
+			page:     query.Page{Limit: 10, Offset: 40},
+			total:    25,
+			wantLink: `</orders?limit=10&offset=30>; rel="prev"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			WritePage(w, httptest.NewRequest(http.MethodGet, test.url, nil), test.page, test.total)
+
+			if got := w.Header().Get("X-Total-Count"); got != strconv.Itoa(test.total) {
+				t.Errorf("X-Total-Count = %q, want %d", got, test.total)
+			}
+			if got := w.Header().Get("Link"); got != test.wantLink {
+				t.Errorf("Link = %s, want %s", got, test.wantLink)
+			}
+		})
+	}
+}
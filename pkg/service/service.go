@@ -4,36 +4,59 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 )
 
 // Service  contains all information required for a service to function
 type Service struct {
-	Config *Config
+	Config   *Config
+	Topology *Topology
 
-	Storage db.Client
-	Api     *http.Server
+	Storage    db.Client
+	Api        *http.Server
+	Management *http.Server
 
 	RbmqSession *rbmq.Session
 	Consumer    *rbmq.Consumer
 	Producer    map[string]*rbmq.Producer
+	Router      *rbmq.Router
+
+	// producerMu guards Producer while producers are created by concurrent message handlers
+	producerMu sync.Mutex
 
 	Logger *zap.SugaredLogger
 }
 
 // Config wraps the database and rabbitmq configuration structs together
+// Settings that only a single service uses are part of the config of that service
 type Config struct {
-	Location string
-	Db       db.Config
-	Rbmq     rbmq.Config
+	Location       string
+	ManagementAddr string
+	TopologyFile   string
+	Db             db.Config
+	Rbmq           rbmq.Config
+	Tracing        tracing.Config
 }
 
 // New initializes the service and all rabbitmq components required for it to function
 func New(config *Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
+	err := tracing.Init(config.Tracing)
+	if err != nil {
+		return nil, err
+	}
+
+	topology, err := LoadTopology(config.TopologyFile)
+	if err != nil {
+		return nil, err
+	}
+
 	rbmqSession, err := rbmq.NewSession(config.Rbmq, logger)
 	if err != nil {
 		return nil, err
@@ -53,13 +76,51 @@ func New(config *Config, messages chan rbmq.Message, logger *zap.SugaredLogger)
 
 	producers[config.Location] = defaultProducer
 
-	return &Service{
+	service := &Service{
 		Config:      config,
+		Topology:    topology,
 		RbmqSession: rbmqSession,
 		Consumer:    consumer,
 		Producer:    producers,
+		Router:      rbmq.NewRouter(config.Rbmq.Workers, logger),
 		Logger:      logger,
-	}, nil
+	}
+
+	// launch the management server in a new thread, the service reports itself as ready once its router runs
+	go service.InitManagement()
+
+	return service, nil
+}
+
+// AddProducers creates a producer for every location the service doesn't have a producer for yet
+func (s *Service) AddProducers(locations ...string) error {
+	for _, location := range locations {
+		_, err := s.ProducerFor(location)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ProducerFor returns the producer of a location and creates it if the service doesn't have one yet
+// It is used to send messages to locations that aren't known when the service starts
+func (s *Service) ProducerFor(location string) (*rbmq.Producer, error) {
+	s.producerMu.Lock()
+	defer s.producerMu.Unlock()
+
+	if producer, ok := s.Producer[location]; ok {
+		return producer, nil
+	}
+
+	producer, err := s.RbmqSession.NewProducer(location, s.Config.Rbmq.ExchangeType)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Producer[location] = producer
+	return producer, nil
 }
 
 // InitStorage connects to the database specified in the config struct
@@ -73,9 +134,15 @@ func (s *Service) InitStorage() error {
 	return nil
 }
 
+// StorageFor returns the storage whose calls are traced as part of the request or message the context belongs to
+func (s *Service) StorageFor(ctx context.Context) db.Client {
+	return db.WithContext(ctx, s.Storage)
+}
+
 // InitAPI starts a http server on port 8080 that uses a chi router for routing
+// All requests are traced and their count and latency are exported as metrics
 func (s *Service) InitAPI(router *chi.Mux) {
-	s.Api = &http.Server{Addr: ":8080", Handler: router}
+	s.Api = &http.Server{Addr: ":8080", Handler: tracing.Middleware(metrics.Instrument(router))}
 	err := s.Api.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		s.Logger.Fatalw("Failed to start API servier", "err", err)
@@ -94,6 +161,13 @@ func (s *Service) Shutdown(ctx context.Context) []error {
 		}
 	}
 
+	if s.Management != nil {
+		err = s.Management.Shutdown(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to shut down management server: %s", err))
+		}
+	}
+
 	err = s.RbmqSession.Close()
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed to close RabbitMQ connection: %e", err))
@@ -106,5 +180,10 @@ func (s *Service) Shutdown(ctx context.Context) []error {
 		}
 	}
 
+	err = tracing.Shutdown()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Failed to export remaining spans: %s", err))
+	}
+
 	return errs
 }
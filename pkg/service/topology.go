@@ -0,0 +1,219 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Topology describes where the services of the system run
+// It defines the headquarter, all factories and support centres and the urls of the services with a rest api
+type Topology struct {
+	Headquarter    string            `json:"headquarter"`
+	Factories      []Factory         `json:"factories"`
+	SupportCentres []SupportCentre   `json:"supportCentres"`
+	Services       map[string]string `json:"services"`
+}
+
+// Factory describes a factory location
+type Factory struct {
+	Location            string `json:"location"`
+	MaxConcurrentOrders int    `json:"maxConcurrentOrders"`
+	// SpeedFactor is multiplied with the assembly time of a product, a factory with a factor below 1 produces faster
+	// and a factory without speed factor produces in real time
+	SpeedFactor float32 `json:"speedFactor"`
+	// CostFactor is multiplied with the prices of parts to estimate the costs of parts at the location
+	CostFactor float32 `json:"costFactor,omitempty"`
+	// Countries are the customer countries the factory is nearest to
+	Countries []string `json:"countries,omitempty"`
+}
+
+// SupportCentre describes a support location and the time window in which it handles new tickets
+// Opens and Closes are utc times like "12:30AM", a support centre without a time window takes all remaining tickets
+type SupportCentre struct {
+	Location string `json:"location"`
+	Opens    string `json:"opens,omitempty"`
+	Closes   string `json:"closes,omitempty"`
+}
+
+// DefaultTopology returns the topology used by the docker-compose setup
+func DefaultTopology() *Topology {
+	return &Topology{
+		Headquarter: "london",
+		Factories: []Factory{
+			{
+				Location:            "usa",
+				MaxConcurrentOrders: 10,
+				SpeedFactor:         0.7,
+				CostFactor:          1.1,
+				Countries:           []string{"USA", "United States", "Canada", "Mexico", "Brazil"},
+			},
+			{
+				Location:            "china",
+				MaxConcurrentOrders: 20,
+				SpeedFactor:         1.2,
+				CostFactor:          0.9,
+				Countries:           []string{"China", "Japan", "South Korea", "India", "Australia"},
+			},
+		},
+		SupportCentres: []SupportCentre{
+			{Location: "india", Opens: "12:30AM", Closes: "02:30PM"},
+			{Location: "mexico"},
+		},
+		Services: map[string]string{
+			"customer":   "http://customer-service:8080",
+			"order":      "http://order-service:8080",
+			"model":      "http://model-service:8080",
+			"delegation": "http://delegation-service:8080",
+		},
+	}
+}
+
+// LoadTopology reads the topology from a json file and falls back to the default topology if no file is given
+// Service urls can be overridden with environment variables like CUSTOMER_SERVICE_URL
+// and the headquarter with TOPOLOGY_HEADQUARTER
+func LoadTopology(path string) (*Topology, error) {
+	topology := DefaultTopology()
+
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read topology file: %s", err)
+		}
+
+		topology = &Topology{}
+		err = json.Unmarshal(content, topology)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse topology file: %s", err)
+		}
+	}
+
+	if topology.Services == nil {
+		topology.Services = make(map[string]string)
+	}
+
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if !strings.HasSuffix(pair[0], "_SERVICE_URL") || pair[1] == "" {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(pair[0], "_SERVICE_URL"))
+		topology.Services[name] = pair[1]
+	}
+
+	if headquarter := os.Getenv("TOPOLOGY_HEADQUARTER"); headquarter != "" {
+		topology.Headquarter = headquarter
+	}
+
+	return topology, topology.validate()
+}
+
+// validate checks that the topology can be used to route messages
+func (t *Topology) validate() error {
+	if t.Headquarter == "" {
+		return fmt.Errorf("The topology has no headquarter")
+	}
+
+	for _, factory := range t.Factories {
+		if factory.Location == "" {
+			return fmt.Errorf("The topology contains a factory without location")
+		}
+	}
+
+	for _, centre := range t.SupportCentres {
+		if centre.Location == "" {
+			return fmt.Errorf("The topology contains a support centre without location")
+		}
+
+		_, _, err := centre.window()
+		if err != nil {
+			return fmt.Errorf("Invalid time window of support centre %s: %s", centre.Location, err)
+		}
+	}
+
+	return nil
+}
+
+// FactoryLocations returns the locations of all factories
+func (t *Topology) FactoryLocations() []string {
+	locations := make([]string, 0, len(t.Factories))
+	for _, factory := range t.Factories {
+		locations = append(locations, factory.Location)
+	}
+	return locations
+}
+
+// SupportLocations returns the locations of all support centres
+func (t *Topology) SupportLocations() []string {
+	locations := make([]string, 0, len(t.SupportCentres))
+	for _, centre := range t.SupportCentres {
+		locations = append(locations, centre.Location)
+	}
+	return locations
+}
+
+// Factory returns the factory at a location
+func (t *Topology) Factory(location string) (Factory, bool) {
+	for _, factory := range t.Factories {
+		if factory.Location == location {
+			return factory, true
+		}
+	}
+	return Factory{}, false
+}
+
+// ServiceURL returns the base url of a service's rest api
+func (t *Topology) ServiceURL(name string) (string, error) {
+	url, ok := t.Services[name]
+	if !ok || url == "" {
+		return "", fmt.Errorf("The topology contains no url for the %s service", name)
+	}
+	return strings.TrimSuffix(url, "/"), nil
+}
+
+// SupportCentreAt returns the location of the support centre that handles tickets created at the given time
+// Support centres are checked in order, the first one whose time window contains the time of day is chosen
+func (t *Topology) SupportCentreAt(now time.Time) (string, error) {
+	minute := now.UTC().Hour()*60 + now.UTC().Minute()
+
+	for _, centre := range t.SupportCentres {
+		opens, closes, err := centre.window()
+		if err != nil {
+			return "", err
+		}
+
+		// windows that end before they start span midnight
+		if opens <= closes && minute >= opens && minute < closes {
+			return centre.Location, nil
+		}
+		if opens > closes && (minute >= opens || minute < closes) {
+			return centre.Location, nil
+		}
+	}
+
+	return "", fmt.Errorf("No support centre is open at %s", now.UTC().Format(time.Kitchen))
+}
+
+// window returns the opening and closing time of a support centre in minutes after midnight
+// A support centre without opening and closing time is open all day
+func (c SupportCentre) window() (int, int, error) {
+	if c.Opens == "" && c.Closes == "" {
+		return 0, 24 * 60, nil
+	}
+
+	opens, err := time.Parse(time.Kitchen, c.Opens)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	closes, err := time.Parse(time.Kitchen, c.Closes)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return opens.Hour()*60 + opens.Minute(), closes.Hour()*60 + closes.Minute(), nil
+}
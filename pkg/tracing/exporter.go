@@ -0,0 +1,331 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize is the maximum number of spans exported at once
+	batchSize = 128
+	// queueSize is the number of finished spans buffered before new spans are dropped
+	queueSize = 2048
+	// flushInterval is the maximum time a finished span waits until it is exported
+	flushInterval = 5 * time.Second
+)
+
+// Config selects the exporter used for finished spans
+type Config struct {
+	// ServiceName is added to every exported span
+	ServiceName string
+	// Exporter is either otlp, file or empty to disable the export, spans are propagated regardless
+	Exporter string
+	// Endpoint is the base url of an otlp/http receiver, e.g. http://collector:4318
+	Endpoint string
+	// File is the path spans are written to by the file exporter, one otlp json document per line
+	File string
+}
+
+// Exporter sends a batch of spans to a tracing backend
+type Exporter interface {
+	Export(payload []byte) error
+	Close() error
+}
+
+// processor batches finished spans and passes them to the exporter
+type processor struct {
+	service  string
+	exporter Exporter
+	spans    chan *Span
+	done     chan struct{}
+}
+
+var (
+	mu      sync.RWMutex
+	current *processor
+)
+
+// Init configures the export of finished spans
+func Init(config Config) error {
+	var exporter Exporter
+	var err error
+
+	switch config.Exporter {
+	case "":
+		return nil
+	case "otlp":
+		exporter, err = newOTLPExporter(config.Endpoint)
+	case "file":
+		exporter, err = newFileExporter(config.File)
+	default:
+		err = fmt.Errorf("Unknown trace exporter %s", config.Exporter)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := &processor{
+		service:  config.ServiceName,
+		exporter: exporter,
+		spans:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	mu.Lock()
+	current = p
+	mu.Unlock()
+
+	go p.run()
+
+	return nil
+}
+
+// Shutdown exports all remaining spans and closes the exporter
+func Shutdown() error {
+	mu.Lock()
+	p := current
+	current = nil
+	mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+
+	close(p.spans)
+	<-p.done
+
+	return p.exporter.Close()
+}
+
+// export queues a finished span, spans are dropped if the queue is full or no exporter is configured
+func export(span *Span) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if current == nil {
+		return
+	}
+
+	select {
+	case current.spans <- span:
+	default:
+	}
+}
+
+// run collects finished spans and exports them whenever a batch is full or the flush interval passed
+func (p *processor) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+
+	for {
+		select {
+		case span, ok := <-p.spans:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush encodes a batch of spans as otlp json and exports it
+func (p *processor) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(p.encode(batch))
+	if err == nil {
+		err = p.exporter.Export(payload)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to export spans:", err)
+	}
+}
+
+// the following types are the otlp/json representation of spans
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// encode converts a batch of spans into an otlp export request
+func (p *processor) encode(batch []*Span) otlpTraces {
+	spans := make([]otlpSpan, 0, len(batch))
+
+	for _, span := range batch {
+		span.mu.Lock()
+
+		encoded := otlpSpan{
+			TraceID:           hex.EncodeToString(span.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.context.SpanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+
+		if span.parent != [8]byte{} {
+			encoded.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+
+		for key, value := range span.attributes {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+
+		if span.err != "" {
+			encoded.Status = otlpStatus{Code: 2, Message: span.err}
+		}
+
+		span.mu.Unlock()
+		spans = append(spans, encoded)
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: p.service}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "efridge"},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+// otlpExporter posts spans to the /v1/traces endpoint of an otlp/http receiver
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("The otlp exporter requires an endpoint")
+	}
+
+	return &otlpExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Export sends a batch of spans, the request itself isn't traced
+func (e *otlpExporter) Export(payload []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Otlp receiver responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (e *otlpExporter) Close() error {
+	return nil
+}
+
+// fileExporter appends every batch of spans as a single line of json to a file
+type fileExporter struct {
+	mu   sync.Mutex
+	file io.WriteCloser
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("The file exporter requires a file")
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileExporter{file: file}, nil
+}
+
+// Export writes a batch of spans
+func (e *fileExporter) Export(payload []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.file.Write(append(payload, '\n'))
+	return err
+}
+
+func (e *fileExporter) Close() error {
+	return e.file.Close()
+}
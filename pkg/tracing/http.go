@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// Middleware starts a server span for every http request, the span continues the trace of the traceparent header
+// It wraps a chi router and names the span after the matched route once the request was handled
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := StartRemote(ParseTraceparent(r.Header.Get(TraceparentHeader)), "HTTP "+r.Method, KindServer)
+		defer span.End()
+
+		ctx := ContextWithSpan(r.Context(), span)
+
+		// the router fills the route context provided here instead of creating its own
+		routeContext := chi.RouteContext(ctx)
+		if routeContext == nil {
+			routeContext = chi.NewRouteContext()
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, routeContext)
+		}
+
+		writer := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		if route := routeContext.RoutePattern(); route != "" {
+			span.SetName("HTTP " + r.Method + " " + route)
+			span.SetAttribute("http.route", route)
+		}
+
+		status := writer.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+	})
+}
+
+// Transport starts a client span for every outgoing request and adds the traceparent header
+// The span is a child of the span carried by the request's context
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip executes a single traced http request
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	_, span := Start(r.Context(), "HTTP "+r.Method, KindClient)
+	defer span.End()
+
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.String())
+
+	// requests must not be modified by a round tripper, so the header is set on a copy
+	r = r.Clone(r.Context())
+	r.Header.Set(TraceparentHeader, span.Context().Traceparent())
+
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
+
+// Client is a http client whose requests are traced
+var Client = &http.Client{Transport: &Transport{}}
+
+// Get sends a traced get request as part of the trace carried by the context
+func Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Client.Do(req)
+}
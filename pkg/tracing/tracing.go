@@ -0,0 +1,200 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+This package implements distributed tracing based on the w3c trace context
+Spans are propagated in the traceparent header of http requests and amqp messages and exported
+in the opentelemetry (otlp) format
+*/
+
+// TraceparentHeader is the name of the header that carries the span context
+const TraceparentHeader = "traceparent"
+
+// SpanKind describes the relationship of a span to its remote parent or children, the values match otlp
+type SpanKind int
+
+const (
+	// KindInternal is used for operations inside a service
+	KindInternal SpanKind = 1
+	// KindServer is used for incoming http requests
+	KindServer SpanKind = 2
+	// KindClient is used for outgoing http requests and database calls
+	KindClient SpanKind = 3
+	// KindProducer is used for published messages
+	KindProducer SpanKind = 4
+	// KindConsumer is used for handled messages
+	KindConsumer SpanKind = 5
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid returns true if the span context belongs to a trace
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent encodes the span context as w3c traceparent header, all spans are sampled
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceparent decodes a w3c traceparent header, invalid headers return an invalid span context
+func ParseTraceparent(traceparent string) SpanContext {
+	var sc SpanContext
+
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return SpanContext{}
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return SpanContext{}
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	return sc
+}
+
+// Span is a single timed operation within a trace
+// All methods accept a nil span, so callers don't have to check whether a request is traced
+type Span struct {
+	mu sync.Mutex
+
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parent     [8]byte
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+	ended      bool
+}
+
+// Context returns the span context of the span, a nil span returns an invalid context
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetName renames the span, e.g. once the route of a http request is known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name = name
+}
+
+// SetAttribute adds a key value pair to the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed, nil errors and nil spans are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err.Error()
+}
+
+// End finishes the span and passes it to the exporter
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	export(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of the context that carries the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by a context or nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start begins a new span as child of the span carried by the context, or a new trace if there is none
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := StartRemote(SpanFromContext(ctx).Context(), name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartRemote begins a new span as child of a span context received from another service
+// An invalid parent starts a new trace
+func StartRemote(parent SpanContext, name string, kind SpanKind) *Span {
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return span
+}
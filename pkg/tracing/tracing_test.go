@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNilSpan(t *testing.T) {
+	var span *Span
+
+	span.SetName("name")
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	if span.Context().IsValid() {
+		t.Errorf("nil span has a valid context")
+	}
+	if SpanFromContext(context.Background()) != nil {
+		t.Errorf("context without span returned a span")
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	span := StartRemote(SpanContext{}, "name", KindServer)
+
+	sc := ParseTraceparent(span.Context().Traceparent())
+	if sc != span.Context() {
+		t.Errorf("parsed span context = %+v, want %+v", sc, span.Context())
+	}
+
+	tests := []string{"", "00-abc-def-01", "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	for _, traceparent := range tests {
+		if ParseTraceparent(traceparent).IsValid() {
+			t.Errorf("ParseTraceparent(%q) returned a valid span context", traceparent)
+		}
+	}
+}
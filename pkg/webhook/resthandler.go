@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"github.com/go-chi/chi"
+)
+
+// minSecretLength is the minimum length of a secret chosen by the client
+const minSecretLength = 16
+
+// Mount registers the rest handlers used to manage the webhooks below /webhooks
+func (d *Dispatcher) Mount(router chi.Router) {
+	router.Route("/webhooks", func(r chi.Router) {
+		r.Post("/", d.postWebhook)
+		r.Get("/", d.getAllWebhooks)
+		r.Get("/{id}", d.getWebhook)
+		r.Delete("/{id}", d.deleteWebhook)
+		r.Get("/{id}/deliveries", d.getDeliveries)
+		r.Post("/{id}/deliveries/{delivery}/redeliver", d.postRedelivery)
+	})
+}
+
+// postWebhook is the rest handler to subscribe a new webhook
+// The secret is only part of this response, a secret is generated if the client didn't send one
+func (d *Dispatcher) postWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to read request body", err)
+		return
+	}
+
+	webhook := entities.Webhook{}
+	err = json.Unmarshal(body, &webhook)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Invalid webhook", service.InvalidBody(err))
+		return
+	}
+
+	if fields := d.validateWebhook(webhook); len(fields) > 0 {
+		d.service.HandleAPIError(w, r, "Invalid webhook", service.ValidationFailed(fields...))
+		return
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret = newSecret()
+	}
+	webhook.Created = time.Now().UTC()
+
+	webhook.ObjectID, err = d.service.StorageFor(r.Context()).CreateWebhook(webhook)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to create webhook", err)
+		return
+	}
+
+	d.service.Logger.Infow("Created webhook", "webhook", webhook.ObjectID, "url", webhook.URL, "events", webhook.Events)
+
+	d.writeJSON(w, r, http.StatusCreated, webhook)
+}
+
+// getAllWebhooks is the rest handler to return all webhooks without their secrets
+func (d *Dispatcher) getAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := d.service.StorageFor(r.Context()).AllWebhooks()
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to fetch webhooks", err)
+		return
+	}
+
+	if webhooks == nil {
+		webhooks = []entities.Webhook{}
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	d.writeJSON(w, r, http.StatusOK, webhooks)
+}
+
+// getWebhook is the rest handler to return a single webhook without its secret
+func (d *Dispatcher) getWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, err := d.service.StorageFor(r.Context()).FindWebhook(chi.URLParam(r, "id"))
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to fetch webhook", err)
+		return
+	}
+
+	webhook.Secret = ""
+	d.writeJSON(w, r, http.StatusOK, webhook)
+}
+
+// deleteWebhook is the rest handler to unsubscribe a webhook, its pending deliveries fail on their next attempt
+func (d *Dispatcher) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	err := d.service.StorageFor(r.Context()).DeleteWebhook(id)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to delete webhook", err)
+		return
+	}
+
+	d.service.Logger.Infow("Deleted webhook", "webhook", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeliveries is the rest handler to return the delivery log of a webhook, the newest delivery first
+// The log is kept after the webhook was deleted
+func (d *Dispatcher) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := d.service.StorageFor(r.Context()).WebhookDeliveries(chi.URLParam(r, "id"))
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to fetch deliveries", err)
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []entities.WebhookDelivery{}
+	}
+
+	d.writeJSON(w, r, http.StatusOK, deliveries)
+}
+
+// postRedelivery is the rest handler to send the payload of a delivery again
+// The new delivery is answered with 202 Accepted and sent by the next delivery run
+func (d *Dispatcher) postRedelivery(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	storage := d.service.StorageFor(r.Context())
+
+	_, err := storage.FindWebhook(id)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to fetch webhook", err)
+		return
+	}
+
+	original, err := storage.FindDelivery(chi.URLParam(r, "delivery"))
+	if err == nil && original.Webhook != id {
+		// deliveries of other webhooks don't exist below this webhook
+		err = service.NewAPIError(http.StatusNotFound, service.CodeNotFound, "Failed to fetch delivery")
+	}
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to fetch delivery", err)
+		return
+	}
+
+	delivery, err := d.Redeliver(r.Context(), original)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to redeliver", err)
+		return
+	}
+
+	d.service.Logger.Infow("Redelivering webhook event", "webhook", id, "delivery", delivery.ObjectID, "original", original.ObjectID)
+
+	d.writeJSON(w, r, http.StatusAccepted, delivery)
+}
+
+// validateWebhook checks the fields of a new webhook and returns all invalid fields
+func (d *Dispatcher) validateWebhook(webhook entities.Webhook) []service.FieldError {
+	var fields []service.FieldError
+
+	target, err := url.Parse(webhook.URL)
+	if webhook.URL == "" || err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		fields = append(fields, service.FieldError{Field: "url", Message: "must be an absolute http or https url"})
+	}
+
+	if len(webhook.Events) == 0 {
+		fields = append(fields, service.FieldError{Field: "events", Message: "must contain at least one event"})
+	}
+	for _, event := range webhook.Events {
+		if !contains(d.events, event) {
+			fields = append(fields, service.FieldError{
+				Field:   "events",
+				Message: "must only contain " + strings.Join(d.events, ", "),
+			})
+			break
+		}
+	}
+
+	if webhook.Secret != "" && len(webhook.Secret) < minSecretLength {
+		fields = append(fields, service.FieldError{Field: "secret", Message: fmt.Sprintf("must contain at least %d characters", minSecretLength)})
+	}
+
+	return fields
+}
+
+// writeJSON marshals a response body and writes it with the given status code
+func (d *Dispatcher) writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		d.service.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// newSecret generates a random secret for a webhook whose client didn't choose one
+func newSecret() string {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
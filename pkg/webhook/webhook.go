@@ -0,0 +1,302 @@
+// Package webhook notifies external systems about the events of a service
+// Every event is stored as a delivery for each subscribed webhook and sent as signed json payload,
+// deliveries that aren't accepted are retried with an exponential backoff
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+)
+
+const (
+	// StatusPending is the status of a delivery that wasn't accepted yet but will be attempted again
+	StatusPending = "pending"
+	// StatusDelivered is the status of a delivery the webhook accepted
+	StatusDelivered = "delivered"
+	// StatusFailed is the status of a delivery that wasn't accepted within the maximum number of attempts
+	StatusFailed = "failed"
+)
+
+// headers sent with every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader contains "sha256=" followed by the hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+	// keyed with the secret of the webhook
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Config defines how often and how long events are sent to a webhook that doesn't accept them
+type Config struct {
+	// MaxAttempts is the number of attempts after which a delivery fails for good
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt, it doubles with every further attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout is how long a webhook may take to answer a single attempt
+	Timeout time.Duration
+	// CheckInterval is how often the service looks for deliveries whose next attempt is due
+	CheckInterval time.Duration
+}
+
+// Payload is the json body sent to a webhook
+type Payload struct {
+	// ID identifies the event, the deliveries of an event to different webhooks share it
+	ID      string      `json:"id"`
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+// Dispatcher stores the webhooks of a service and delivers the events of the service to them
+type Dispatcher struct {
+	service *service.Service
+	config  Config
+	events  []string
+	client  *http.Client
+	// wake triggers an immediate delivery run after new deliveries were stored
+	wake chan struct{}
+}
+
+// New creates a dispatcher for the given event types, webhooks can only subscribe to these events
+// The deliveries are only sent once Run was started
+func New(s *service.Service, config Config, events ...string) *Dispatcher {
+	return &Dispatcher{
+		service: s,
+		config:  config,
+		events:  events,
+		client:  &http.Client{Transport: &tracing.Transport{}, Timeout: config.Timeout},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Publish stores a delivery of an event for every webhook that subscribed to it
+// The event already happened at this point, so a failure is only logged
+func (d *Dispatcher) Publish(ctx context.Context, event string, data interface{}) {
+	storage := d.service.StorageFor(ctx)
+
+	webhooks, err := storage.AllWebhooks()
+	if err != nil {
+		d.service.Logger.Errorw("Failed to fetch webhooks", "event", event, "err", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(Payload{ID: newEventID(), Event: event, Created: now, Data: data})
+	if err != nil {
+		d.service.Logger.Errorw("Failed to marshal webhook payload", "event", event, "err", err)
+		return
+	}
+
+	stored := 0
+	for _, webhook := range webhooks {
+		if !contains(webhook.Events, event) {
+			continue
+		}
+
+		_, err := storage.CreateDelivery(entities.WebhookDelivery{
+			Webhook:     webhook.ObjectID,
+			Event:       event,
+			Payload:     body,
+			Status:      StatusPending,
+			NextAttempt: now,
+			Created:     now,
+		})
+		if err != nil {
+			d.service.Logger.Errorw("Failed to store webhook delivery", "event", event, "webhook", webhook.ObjectID, "err", err)
+			continue
+		}
+		stored++
+	}
+
+	if stored > 0 {
+		d.trigger()
+	}
+}
+
+// Redeliver sends the payload of a delivery again as a new delivery, the original delivery stays in the log
+func (d *Dispatcher) Redeliver(ctx context.Context, original entities.WebhookDelivery) (entities.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	delivery := entities.WebhookDelivery{
+		Webhook:      original.Webhook,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		Status:       StatusPending,
+		NextAttempt:  now,
+		RedeliveryOf: original.ObjectID,
+		Created:      now,
+	}
+
+	var err error
+	delivery.ObjectID, err = d.service.StorageFor(ctx).CreateDelivery(delivery)
+	if err != nil {
+		return entities.WebhookDelivery{}, err
+	}
+
+	d.trigger()
+	return delivery, nil
+}
+
+// trigger wakes up Run without blocking, a pending wake up already covers the new deliveries
+func (d *Dispatcher) trigger() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run periodically sends all deliveries whose next attempt is due
+// If several instances of a service share a database a delivery may be sent more than once,
+// so receivers should ignore deliveries whose id they already processed
+func (d *Dispatcher) Run() {
+	for {
+		select {
+		case <-time.After(d.config.CheckInterval):
+		case <-d.wake:
+		}
+
+		deliveries, err := d.service.Storage.DueDeliveries(time.Now().UTC())
+		if err != nil {
+			d.service.Logger.Errorw("Failed to fetch due webhook deliveries", "err", err)
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			d.attempt(delivery)
+		}
+	}
+}
+
+// attempt sends a delivery once and stores the result together with the time of the next attempt
+func (d *Dispatcher) attempt(delivery entities.WebhookDelivery) {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttempt = now
+	delivery.StatusCode = 0
+	delivery.Error = ""
+
+	// the webhook may have been deleted after the event was published, retrying can't help then
+	deleted := false
+
+	webhook, err := d.service.Storage.FindWebhook(delivery.Webhook)
+	switch {
+	case db.IsNotFound(err):
+		delivery.Error = "The webhook was deleted"
+		deleted = true
+	case err != nil:
+		delivery.Error = fmt.Sprintf("Failed to fetch webhook: %s", err)
+	default:
+		delivery.StatusCode, err = d.send(webhook, delivery, now)
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+	}
+
+	switch {
+	case delivery.Error == "":
+		delivery.Status = StatusDelivered
+		delivery.NextAttempt = time.Time{}
+	case deleted || delivery.Attempts >= d.config.MaxAttempts:
+		delivery.Status = StatusFailed
+		delivery.NextAttempt = time.Time{}
+	default:
+		delivery.Status = StatusPending
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues(delivery.Event, delivery.Status).Inc()
+	if delivery.Error != "" {
+		d.service.Logger.Warnw("Failed to deliver webhook event", "delivery", delivery.ObjectID, "webhook", delivery.Webhook,
+			"event", delivery.Event, "attempts", delivery.Attempts, "status", delivery.Status, "err", delivery.Error)
+	}
+
+	err = d.service.Storage.UpdateDelivery(delivery)
+	if err != nil {
+		d.service.Logger.Errorw("Failed to store webhook delivery", "delivery", delivery.ObjectID, "err", err)
+	}
+}
+
+// send posts the payload of a delivery to its webhook, any other status than 2xx is an error
+func (d *Dispatcher) send(webhook entities.Webhook, delivery entities.WebhookDelivery, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ObjectID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Webhook answered with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.InitialBackoff
+	for i := 1; i < attempts && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.config.MaxBackoff {
+		delay = d.config.MaxBackoff
+	}
+	return delay
+}
+
+// Sign returns the signature of a payload in the format of the SignatureHeader
+// Receivers compute the same signature and compare it in constant time to verify a delivery
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID generates a random id for an event
+func newEventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// contains checks whether a list contains a value
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
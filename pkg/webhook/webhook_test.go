@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/memory"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"go.uber.org/zap"
+)
+
+// testConfig is the retry policy used by the tests
+var testConfig = Config{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Second,
+	MaxBackoff:     time.Minute,
+	Timeout:        time.Second,
+	CheckInterval:  time.Second,
+}
+
+// newTestDispatcher returns a dispatcher that stores its webhooks and deliveries in memory
+func newTestDispatcher() (*Dispatcher, *memory.Client) {
+	storage := memory.New()
+	s := &service.Service{Config: &service.Config{}, Storage: storage, Logger: zap.NewNop().Sugar()}
+	return New(s, testConfig, "order.shipped", "order.failed"), storage
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	want := "sha256=27a46cee46ddb319f3f5434aadbb86a0130fa0b54a5b58fe0174646b158fa13c"
+
+	if got := Sign("secret", "1594209600", body); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+	if Sign("other secret", "1594209600", body) == want {
+		t.Errorf("Signature doesn't depend on the secret")
+	}
+	if Sign("secret", "1594209601", body) == want {
+		t.Errorf("Signature doesn't depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d, _ := newTestDispatcher()
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 10 * time.Second},
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 3, want: 40 * time.Second},
+		{attempts: 4, want: time.Minute},
+		{attempts: 100, want: time.Minute},
+	}
+
+	for _, test := range tests {
+		if got := d.backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		previous       int
+		deleteWebhook  bool
+		wantStatus     string
+		wantStatusCode int
+		wantRetry      bool
+		wantRequest    bool
+	}{
+		{
+			name:           "accepted",
+			status:         http.StatusNoContent,
+			wantStatus:     StatusDelivered,
+			wantStatusCode: http.StatusNoContent,
+			wantRequest:    true,
+		},
+		{
+			name:           "rejected",
+			status:         http.StatusInternalServerError,
+			wantStatus:     StatusPending,
+			wantStatusCode: http.StatusInternalServerError,
+			wantRetry:      true,
+			wantRequest:    true,
+		},
+		{
+			name:           "other status than 2xx",
+			status:         http.StatusNotModified,
+			wantStatus:     StatusPending,
+			wantStatusCode: http.StatusNotModified,
+			wantRetry:      true,
+			wantRequest:    true,
+		},
+		{
+			name:           "rejected on the last attempt",
+			status:         http.StatusBadGateway,
+			previous:       testConfig.MaxAttempts - 1,
+			wantStatus:     StatusFailed,
+			wantStatusCode: http.StatusBadGateway,
+			wantRequest:    true,
+		},
+		{
+			name:           "accepted on the last attempt",
+			status:         http.StatusOK,
+			previous:       testConfig.MaxAttempts - 1,
+			wantStatus:     StatusDelivered,
+			wantStatusCode: http.StatusOK,
+			wantRequest:    true,
+		},
+		{
+			name:          "deleted webhook",
+			status:        http.StatusOK,
+			deleteWebhook: true,
+			wantStatus:    StatusFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := []byte(`{"id":"event","event":"order.shipped"}`)
+
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != string(payload) {
+					t.Errorf("body = %s, want %s", body, payload)
+				}
+				if r.Header.Get(EventHeader) != "order.shipped" {
+					t.Errorf("%s = %q, want order.shipped", EventHeader, r.Header.Get(EventHeader))
+				}
+				if got, want := r.Header.Get(SignatureHeader), Sign("0123456789abcdef", r.Header.Get(TimestampHeader), body); got != want {
+					t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+				}
+
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			d, storage := newTestDispatcher()
+
+			webhookID, _ := storage.CreateWebhook(entities.Webhook{URL: server.URL, Secret: "0123456789abcdef", Events: []string{"order.shipped"}})
+			if test.deleteWebhook {
+				storage.DeleteWebhook(webhookID)
+			}
+
+			delivery := entities.WebhookDelivery{
+				Webhook:     webhookID,
+				Event:       "order.shipped",
+				Payload:     payload,
+				Status:      StatusPending,
+				Attempts:    test.previous,
+				NextAttempt: time.Now().UTC(),
+			}
+			delivery.ObjectID, _ = storage.CreateDelivery(delivery)
+
+			before := time.Now().UTC()
+			d.attempt(delivery)
+
+			stored, err := storage.FindDelivery(delivery.ObjectID)
+			if err != nil {
+				t.Fatalf("FindDelivery returned %v", err)
+			}
+
+			if (requests > 0) != test.wantRequest {
+				t.Errorf("%d requests sent, want request %v", requests, test.wantRequest)
+			}
+			if stored.Status != test.wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, test.wantStatus)
+			}
+			if stored.StatusCode != test.wantStatusCode {
+				t.Errorf("status code = %d, want %d", stored.StatusCode, test.wantStatusCode)
+			}
+			if stored.Attempts != test.previous+1 {
+				t.Errorf("attempts = %d, want %d", stored.Attempts, test.previous+1)
+			}
+			if stored.LastAttempt.Before(before) {
+				t.Errorf("last attempt %s wasn't updated", stored.LastAttempt)
+			}
+			if (stored.Error == "") != (test.wantStatus == StatusDelivered) {
+				t.Errorf("error = %q for status %s", stored.Error, stored.Status)
+			}
+
+			if !test.wantRetry {
+				if !stored.NextAttempt.IsZero() {
+					t.Errorf("next attempt = %s, want none", stored.NextAttempt)
+				}
+				return
+			}
+
+			backoff := d.backoff(stored.Attempts)
+			if stored.NextAttempt.Before(before.Add(backoff)) || stored.NextAttempt.After(time.Now().UTC().Add(backoff)) {
+				t.Errorf("next attempt = %s, want %s after the attempt", stored.NextAttempt, backoff)
+			}
+		})
+	}
+}
+
+func TestAttemptUnreachableWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	d, storage := newTestDispatcher()
+	webhookID, _ := storage.CreateWebhook(entities.Webhook{URL: url, Secret: "0123456789abcdef", Events: []string{"order.shipped"}})
+
+	delivery := entities.WebhookDelivery{Webhook: webhookID, Event: "order.shipped", Status: StatusPending}
+	delivery.ObjectID, _ = storage.CreateDelivery(delivery)
+
+	d.attempt(delivery)
+
+	stored, _ := storage.FindDelivery(delivery.ObjectID)
+	if stored.Status != StatusPending || stored.StatusCode != 0 || stored.Error == "" {
+		t.Errorf("delivery = %s %d %q, want a pending delivery with error", stored.Status, stored.StatusCode, stored.Error)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	d, storage := newTestDispatcher()
+
+	subscribed, _ := storage.CreateWebhook(entities.Webhook{URL: "http://example.com", Events: []string{"order.shipped"}})
+	other, _ := storage.CreateWebhook(entities.Webhook{URL: "http://example.com", Events: []string{"order.failed"}})
+
+	d.Publish(context.Background(), "order.shipped", map[string]string{"order": "a"})
+
+	deliveries, _ := storage.WebhookDeliveries(subscribed)
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries for the subscribed webhook, want 1", len(deliveries))
+	}
+	if deliveries[0].Status != StatusPending || deliveries[0].Attempts != 0 {
+		t.Errorf("delivery = %s after %d attempts, want a new pending delivery", deliveries[0].Status, deliveries[0].Attempts)
+	}
+
+	payload := Payload{}
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil || payload.Event != "order.shipped" || payload.ID == "" {
+		t.Errorf("payload = %s, %v", deliveries[0].Payload, err)
+	}
+
+	if deliveries, _ := storage.WebhookDeliveries(other); len(deliveries) != 0 {
+		t.Errorf("%d deliveries for a webhook that didn't subscribe to the event, want 0", len(deliveries))
+	}
+}
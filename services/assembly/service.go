@@ -2,6 +2,7 @@ package assembly
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
@@ -12,64 +13,118 @@ import (
 // Service is the instance wrapper
 type Service struct {
 	*service.Service
+
+	// inFlight contains the orders that are being assembled
+	inFlight *service.InFlight
 }
 
 // New is the initializer
 func New(config *service.Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
 	var err error
 
-	supplierService := &Service{}
+	supplierService := &Service{inFlight: service.NewInFlight()}
 	supplierService.Service, err = service.New(config, messages, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	go supplierService.handleRbmqMessage(messages)
+	supplierService.Router.Handle(rbmq.TypeOrderUpdate, rbmq.OrderHandler(supplierService.assemble))
+	supplierService.Router.HandleControl(rbmq.TypeCancelOrder, rbmq.OrderHandler(supplierService.handleCancelOrder))
+	go supplierService.Router.Run(messages)
 
 	return supplierService, nil
 }
 
-/* handleRbmqMessage receives incoming messages from factory service */
+/* assemble receives incoming orders from factory service */
 /* after reception, function sleeps to simulate production and then responds to factory with ack msg */
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	for msg := range messages {
-
-		recMsg := rbmq.OrderMessage{}
-		err := json.Unmarshal(msg.Body, &recMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
+func (s *Service) assemble(msg rbmq.Message, recMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received assembly request", "order", recMsg.OrderID)
+	s.Logger.Infow("Starting production", "order", recMsg.OrderID)
+
+	s.inFlight.Start(recMsg.OrderID)
+	defer s.inFlight.Done(recMsg.OrderID)
+
+	/* sleep to simulate production process */
+	/* sleep duration depends on service location and individual product */
+	fridges := 0
+	for _, item := range recMsg.Items {
+		fridges += item.Units()
+	}
+
+	produced := 0
+	for _, item := range recMsg.Items {
+		for unit := 0; unit < item.Units(); unit++ {
+			// stop the production once the order was cancelled, the remaining parts can be sent back
+			if s.inFlight.Cancelled(recMsg.OrderID) {
+				return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), recMsg, true,
+					fmt.Sprintf("Stopped the assembly after %d of %d fridges", produced, fridges))
+			}
+
+			produce(item.AssemblyTime, s.speedFactor())
+			produced++
 		}
+		s.Logger.Infow("Successfully produced item", "order", recMsg.OrderID, "item", item.ItemID, "quantity", item.Units(),
+			"assemblyTime", item.AssemblyTime)
+	}
+	s.Logger.Infow("Production finished", "order", recMsg.OrderID)
 
-		s.Logger.Infow("Received assembly request", "order", recMsg.OrderID)
-		s.Logger.Infow("Starting production", "order", recMsg.OrderID)
+	/* Notify factory service of finished assembling process */
+	response, err := productionAck(recMsg)
+	if err != nil {
+		s.Logger.Errorw("Failed to marshal assembly acknowledgement", "err", err)
+		return err
+	}
 
-		/* sleep to simulate production process */
-		/* sleep duration depends on service location and individual product */
-		for _, item := range recMsg.Items {
-			produce(item.AssemblyTime, s.Config.Location)
-			s.Logger.Infow("Successfully produced item", "order", recMsg.OrderID, "item", item.ItemID, "assemblyTime", item.AssemblyTime)
-		}
-		s.Logger.Infow("Production finished", "order", recMsg.OrderID)
+	return s.Producer[s.Config.Location].Publish(msg.Envelope.Follow(rbmq.TypeOrderUpdate), response, "factory")
+}
 
-		/* Notify factory service of finished assembling process */
-		response, err := productionAck(recMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to marshal assembly acknowledgement", "err", err)
-			return
-		}
-		s.Producer[s.Config.Location].Publish(response, "factory")
+// handleCancelOrder stops the assembly of a cancelled order
+// Cancellations are control messages, they are handled while the same order is being assembled
+// If the order isn't being assembled, it was either assembled already or it is still queued and stops
+// as soon as it is started
+func (s *Service) handleCancelOrder(msg rbmq.Message, recMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order cancellation", "order", recMsg.OrderID)
+
+	// the handler assembling the order reports the cancellation once it stopped
+	if s.inFlight.Cancel(recMsg.OrderID) {
+		return nil
+	}
+
+	return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), recMsg, false, "The order isn't being assembled")
+}
+
+// reportCancellation tells the factory whether the assembly service stopped its work on a cancelled order
+func (s *Service) reportCancellation(envelope rbmq.Envelope, recMsg rbmq.OrderMessage, cancelled bool, reason string) error {
+	result := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelResult,
+		OrderID:   recMsg.OrderID,
+		Stage:     "assembly",
+		Cancelled: cancelled,
+		Reason:    reason,
 	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Infow("Reporting cancellation", "order", recMsg.OrderID, "cancelled", cancelled, "reason", reason)
+	return s.Producer[s.Config.Location].Publish(envelope, body, "factory")
 }
 
-// TODO: Add equation to depend sleep duration on location and individual product
-func produce(assemblyTime int, location string) {
-	var productionTime float32
-	if location == "usa" {
-		productionTime = float32(assemblyTime) * float32(0.7)
-	} else if location == "china" {
-		productionTime = float32(assemblyTime) * float32(1.2)
+// speedFactor returns the speed factor of the factory the service runs in, factories without a speed factor produce in real time
+func (s *Service) speedFactor() float32 {
+	factory, ok := s.Topology.Factory(s.Config.Location)
+	if !ok || factory.SpeedFactor <= 0 {
+		return 1
 	}
+	return factory.SpeedFactor
+}
+
+// produce simulates the production of a product, the sleep duration depends on the assembly time and the location's speed factor
+func produce(assemblyTime int, speedFactor float32) {
+	productionTime := float32(assemblyTime) * speedFactor
 
 	time.Sleep(time.Duration(productionTime) * time.Second)
 }
@@ -78,7 +133,7 @@ func productionAck(recMsg rbmq.OrderMessage) ([]byte, error) {
 
 	response := rbmq.OrderMessage{
 		Timestamp: time.Now().UTC(),
-		MsgType:   recMsg.MsgType,
+		MsgType:   rbmq.TypeOrderUpdate,
 		Status:    "complete",
 		OrderID:   recMsg.OrderID,
 	}
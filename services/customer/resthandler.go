@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 	"github.com/go-chi/chi"
 )
 
@@ -12,13 +15,13 @@ import (
 func (s *Service) postCustomer(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		s.handleAPIError("Failed to read request body", err, w)
+		s.HandleAPIError(w, r, "Failed to read request body", err)
 		return
 	}
 
-	response, err := s.prepareCustomer(body)
+	response, err := s.prepareCustomer(r.Context(), body)
 	if err != nil {
-		s.handleAPIError("Failed to create customer", err, w)
+		s.HandleAPIError(w, r, "Failed to create customer", err)
 		return
 	}
 
@@ -31,15 +34,15 @@ func (s *Service) getCustomer(w http.ResponseWriter, r *http.Request) {
 
 	s.Logger.Infow("Received request to fetch customer", "customer", id)
 
-	customer, err := s.Storage.FindCustomer(id)
+	customer, err := s.StorageFor(r.Context()).FindCustomer(id)
 	if err != nil {
-		s.handleAPIError("Failed to find customer", err, w)
+		s.HandleAPIError(w, r, "Failed to find customer", err)
 		return
 	}
 
 	body, err := json.Marshal(customer)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
@@ -48,25 +51,52 @@ func (s *Service) getCustomer(w http.ResponseWriter, r *http.Request) {
 
 // getAllCustomers is the handler function to get a list of all customers
 func (s *Service) getAllCustomers(w http.ResponseWriter, r *http.Request) {
-	s.Logger.Info("Received request to fetch all customers")
+	q, err := parseCustomerQuery(r)
+	if err != nil {
+		s.HandleAPIError(w, r, "Invalid customer query", err)
+		return
+	}
+
+	s.Logger.Infow("Received request to fetch customers", "country", q.Country, "limit", q.Page.Limit, "offset", q.Page.Offset)
 
-	customers, err := s.Storage.AllCustomers()
+	customers, total, err := s.StorageFor(r.Context()).FindCustomers(q)
 	if err != nil {
-		s.handleAPIError("Failed to fetch customers", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch customers", err)
 		return
 	}
 
+	if customers == nil {
+		customers = []entities.Customer{}
+	}
+
 	body, err := json.Marshal(customers)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
+	service.WritePage(w, r, q.Page, total)
 	w.Write(body)
 }
 
-func (s *Service) handleAPIError(msg string, err error, w http.ResponseWriter) {
-	s.Logger.Errorw("msg", "err", err)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
+// parseCustomerQuery reads the filters, the sort order and the page of a customer list request
+// By default the newest customers are returned first
+func parseCustomerQuery(r *http.Request) (query.Customers, error) {
+	var err error
+	q := query.Customers{
+		Country: r.URL.Query().Get("country"),
+	}
+
+	q.Created, err = service.ParseTimeRange(r, "created")
+	if err != nil {
+		return q, err
+	}
+
+	q.Sort, err = service.ParseSort(r, query.Sort{Field: query.SortCreated, Descending: true}, query.SortCreated, query.SortLastName)
+	if err != nil {
+		return q, err
+	}
+
+	q.Page, err = service.ParsePage(r)
+	return q, err
 }
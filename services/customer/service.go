@@ -1,6 +1,7 @@
 package customer
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -53,7 +54,7 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 }
 
 // prepareCustomer takes a http request body and parses it so that it can be stored in the database
-func (s *Service) prepareCustomer(body []byte) ([]byte, error) {
+func (s *Service) prepareCustomer(ctx context.Context, body []byte) ([]byte, error) {
 	s.Logger.Info("Received request to create customer")
 
 	customer := entities.Customer{
@@ -63,11 +64,11 @@ func (s *Service) prepareCustomer(body []byte) ([]byte, error) {
 	// decode the body
 	err := json.Unmarshal(body, &customer)
 	if err != nil {
-		return nil, err
+		return nil, service.InvalidBody(err)
 	}
 
 	// add customer to the database
-	customer.ObjectID, err = s.Storage.CreateCustomer(customer)
+	customer.ObjectID, err = s.StorageFor(ctx).CreateCustomer(customer)
 	if err != nil {
 		return nil, err
 	}
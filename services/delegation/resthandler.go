@@ -0,0 +1,191 @@
+package delegation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"github.com/go-chi/chi"
+)
+
+// factoryResponse is the representation of a factory returned by the rest api
+type factoryResponse struct {
+	Location            string    `json:"location"`
+	Mode                string    `json:"mode"`
+	Live                bool      `json:"live"`
+	CurrentLoad         int       `json:"currentLoad"`
+	MaxConcurrentOrders int       `json:"maxConcurrentOrders"`
+	AnnouncedCapacity   int       `json:"announcedCapacity"`
+	LastHeartbeat       time.Time `json:"lastHeartbeat"`
+}
+
+// settingsRequest contains the settings of a factory an administrator can change, fields that are missing are kept
+type settingsRequest struct {
+	Mode                *string `json:"mode"`
+	MaxConcurrentOrders *int    `json:"maxConcurrentOrders"`
+}
+
+// getAllFactories is the rest handler to return the status of all registered factories
+func (s *Service) getAllFactories(w http.ResponseWriter, r *http.Request) {
+	s.Logger.Info("Received request to fetch all factories")
+
+	factories, err := s.StorageFor(r.Context()).AllFactoryStatus()
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to fetch factories", err)
+		return
+	}
+
+	response := []factoryResponse{}
+	for _, status := range factories {
+		response = append(response, s.factoryResponse(status))
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// getFactory is the rest handler to return the status of a single factory
+func (s *Service) getFactory(w http.ResponseWriter, r *http.Request) {
+	location := chi.URLParam(r, "location")
+
+	s.Logger.Infow("Received request to fetch factory", "location", location)
+
+	status, err := s.StorageFor(r.Context()).GetFactoryStatus(location)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to find factory", err)
+		return
+	}
+
+	body, err := json.Marshal(s.factoryResponse(status))
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// patchFactory is the rest handler to change the mode or the capacity of a factory
+// A capacity of 0 resets the factory to the capacity it announced itself
+func (s *Service) patchFactory(w http.ResponseWriter, r *http.Request) {
+	location := chi.URLParam(r, "location")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to read request body", err)
+		return
+	}
+
+	var settings settingsRequest
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to parse request body", service.InvalidBody(err))
+		return
+	}
+
+	s.Logger.Infow("Received request to update factory", "location", location)
+
+	status, err := s.StorageFor(r.Context()).GetFactoryStatus(location)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to find factory", err)
+		return
+	}
+
+	if settings.Mode != nil {
+		switch *settings.Mode {
+		case "", "active":
+			status.Mode = ""
+		case ModeDrain, ModeMaintenance:
+			status.Mode = *settings.Mode
+		default:
+			s.HandleAPIError(w, r, "Invalid factory settings", service.ValidationFailed(service.FieldError{
+				Field:   "mode",
+				Message: "must be active, drain or maintenance",
+			}))
+			return
+		}
+	}
+
+	if settings.MaxConcurrentOrders != nil {
+		if *settings.MaxConcurrentOrders < 0 {
+			s.HandleAPIError(w, r, "Invalid factory settings", service.ValidationFailed(service.FieldError{
+				Field:   "maxConcurrentOrders",
+				Message: "must not be negative",
+			}))
+			return
+		}
+		status.CapacityOverride = *settings.MaxConcurrentOrders
+	}
+
+	err = s.StorageFor(r.Context()).UpdateFactorySettings(status)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to update factory", err)
+		return
+	}
+
+	s.Logger.Infow("Updated factory", "location", location, "mode", status.Mode, "capacityOverride", status.CapacityOverride)
+
+	body, err = json.Marshal(s.factoryResponse(status))
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// getFactoryOrders is the rest handler to return all orders that are currently assigned to a factory
+func (s *Service) getFactoryOrders(w http.ResponseWriter, r *http.Request) {
+	location := chi.URLParam(r, "location")
+
+	s.Logger.Infow("Received request to fetch orders of factory", "location", location)
+
+	delegations, err := s.StorageFor(r.Context()).OpenDelegations(location)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to fetch orders", err)
+		return
+	}
+
+	if delegations == nil {
+		delegations = []entities.Delegation{}
+	}
+
+	body, err := json.Marshal(delegations)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// factoryResponse converts the stored status of a factory to its representation in the rest api
+func (s *Service) factoryResponse(status entities.FactoryStatus) factoryResponse {
+	response := factoryResponse{
+		Location:            status.Location,
+		Mode:                status.Mode,
+		Live:                s.isLive(status),
+		CurrentLoad:         status.CurrentLoad,
+		MaxConcurrentOrders: status.MaxConcurrentOrders,
+		AnnouncedCapacity:   status.MaxConcurrentOrders,
+		LastHeartbeat:       status.LastHeartbeat,
+	}
+
+	if response.Mode == "" {
+		response.Mode = "active"
+	}
+
+	if status.CapacityOverride > 0 {
+		response.MaxConcurrentOrders = status.CapacityOverride
+	}
+
+	return response
+}
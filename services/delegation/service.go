@@ -1,42 +1,71 @@
 package delegation
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 )
 
+const (
+	// ModeDrain stops delegating new orders to a factory while it finishes its current orders
+	ModeDrain = "drain"
+	// ModeMaintenance stops delegating new orders to a factory while it is under maintenance
+	ModeMaintenance = "maintenance"
+)
+
+// Config contains the settings of the delegation service
+type Config struct {
+	// Strategy is the name of the strategy used to choose a factory
+	Strategy string
+	// HeartbeatInterval is how often the service checks which factories are live,
+	// it stops sending orders to a factory once it missed its heartbeats for HeartbeatTimeout
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+	// ReconcileInterval is how often the service compares the load it tracks with the real number
+	// of unfinished orders of each factory
+	ReconcileInterval time.Duration
+}
+
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
-}
 
-var additionalProducers = [...]string{"china", "usa"}
+	config Config
+
+	// strategy chooses the factory of every new order
+	strategy Strategy
+}
 
 // New launches a new custom service based on the service library in /pkg/service
-func New(config *service.Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
+func New(config *service.Config, delegationConfig Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
 	var err error
 
 	// initialize a new service instance based on the config
-	delegationService := &Service{}
+	delegationService := &Service{config: delegationConfig}
 	delegationService.Service, err = service.New(config, messages, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	// add additional producers to send messages to the factories
-	for _, location := range additionalProducers {
-		producer, err := delegationService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
-
-		delegationService.Producer[location] = producer
+	// choose the configured delegation strategy
+	delegationService.strategy, err = NewStrategy(delegationConfig.Strategy, delegationService.customerCountry)
+	if err != nil {
+		return nil, err
 	}
+	logger.Infow("Using delegation strategy", "strategy", delegationService.strategy.Name())
 
 	// initialize the database
 	err = delegationService.InitStorage()
@@ -44,108 +73,571 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	logger.Info("Initializing database")
-	err = delegationService.Storage.InitDelegationDatabase()
+	// export the initial load of the factories
+	_, err = delegationService.getFactoryStatus(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go delegationService.handleRbmqMessage(messages)
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	// new orders need to be delegated, updates of existing ones decrease the load of a factory,
+	// orders that missed a deadline are delegated again or aborted and cancellations are forwarded to the factories
+	// and factories announce themselves with registrations and heartbeats and report their real load
+	delegationService.Router.Handle(rbmq.TypeDelegate, rbmq.OrderHandler(delegationService.delegateOrder))
+	delegationService.Router.Handle(rbmq.TypeRedelegate, rbmq.OrderHandler(delegationService.redelegateOrder))
+	delegationService.Router.Handle(rbmq.TypeAbortOrder, rbmq.OrderHandler(delegationService.abortOrder))
+	delegationService.Router.Handle(rbmq.TypeCancelOrder, rbmq.OrderHandler(delegationService.cancelOrder))
+	delegationService.Router.Handle(rbmq.TypeCancelResult, rbmq.OrderHandler(delegationService.handleCancelResult))
+	delegationService.Router.Handle(rbmq.TypeOrderUpdate, rbmq.OrderHandler(delegationService.updateFactoryStatus))
+	delegationService.Router.Handle(rbmq.TypeRegisterFactory, rbmq.FactoryHandler(delegationService.registerFactory))
+	delegationService.Router.Handle(rbmq.TypeHeartbeat, rbmq.FactoryHandler(delegationService.registerFactory))
+	delegationService.Router.Handle(rbmq.TypeLoadReport, rbmq.FactoryHandler(delegationService.reconcileLoad))
+	go delegationService.Router.Run(messages)
+
+	// initialize a chi router and its handler functions
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(middleware.Recoverer)
+
+	router.Get("/", delegationService.getAllFactories)
+	router.Get("/{location}", delegationService.getFactory)
+	router.Patch("/{location}", delegationService.patchFactory)
+	router.Get("/{location}/orders", delegationService.getFactoryOrders)
+
+	go delegationService.InitAPI(router)
+
+	// launch a new thread that reports factories whose heartbeats stopped
+	go delegationService.watchFactories()
+
+	// launch a new thread that periodically asks the factories for their real load
+	go delegationService.requestLoadReports()
 
 	return delegationService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
+// delegateOrder determines the location a new order is being sent to
+func (s *Service) delegateOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order", "order", orderMsg.OrderID)
+
+	return s.delegate(msg, orderMsg, "")
+}
+
+// redelegateOrder takes an order from the factory that didn't accept it in time and delegates it to another factory
+func (s *Service) redelegateOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order to delegate again", "order", orderMsg.OrderID)
+
+	// a delegation created after the request was sent belongs to a previous attempt of this redelegation,
+	// the order is sent to its factory again instead of releasing it
+	delegation, err := s.StorageFor(msg.Context()).FindOpenDelegation(orderMsg.OrderID)
+	if err == nil && delegation.Created.After(orderMsg.Timestamp) {
+		return s.delegate(msg, orderMsg, "")
+	}
+	if err != nil && !db.IsNotFound(err) {
+		return err
+	}
+
+	previous, err := s.releaseOrder(msg, orderMsg, "The order is delegated to another factory")
+	if err != nil {
+		return err
+	}
+
+	return s.delegate(msg, orderMsg, previous)
+}
+
+// abortOrder takes an order that missed a deadline from its factory
+func (s *Service) abortOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order to abort", "order", orderMsg.OrderID, "reason", orderMsg.Reason)
+
+	_, err := s.releaseOrder(msg, orderMsg, orderMsg.Reason)
+	return err
+}
+
+// cancelOrder forwards the cancellation of an order to the factory it was delegated to
+// An order without open delegation either wasn't delegated yet or was already finished, in both cases there is
+// nothing to stop, the order service only accepts cancellations of unfinished orders
+func (s *Service) cancelOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order cancellation", "order", orderMsg.OrderID)
+
+	delegation, err := s.StorageFor(msg.Context()).FindOpenDelegation(orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "The order wasn't delegated to a factory")
+	}
+	if err != nil {
+		return err
+	}
+
+	cancelMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelOrder,
+		OrderID:   orderMsg.OrderID,
+		Location:  delegation.Location,
+	}
+
+	body, err := json.Marshal(cancelMsg)
+	if err != nil {
+		return err
+	}
+
+	producer, err := s.ProducerFor(delegation.Location)
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Infow("Forwarding cancellation to factory", "order", orderMsg.OrderID, "location", delegation.Location)
+	return producer.Publish(msg.Envelope.Follow(rbmq.TypeCancelOrder), body, "factory")
+}
+
+// reportCancellation tells the order service that an order was cancelled before it reached a factory
+func (s *Service) reportCancellation(envelope rbmq.Envelope, orderMsg rbmq.OrderMessage, reason string) error {
+	result := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelResult,
+		OrderID:   orderMsg.OrderID,
+		Status:    "cancelled",
+		Stage:     "delegation",
+		Cancelled: true,
+		Reason:    reason,
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	producer, err := s.ProducerFor(s.Topology.Headquarter)
+	if err != nil {
+		return err
+	}
+
+	return producer.Publish(envelope, body, "order")
+}
+
+// handleCancelResult releases the load of an order that its factory stopped
+// Orders the factory couldn't stop anymore are released once the factory completes them
+func (s *Service) handleCancelResult(msg rbmq.Message, result rbmq.OrderMessage) error {
+	s.Logger.Infow("Received cancellation result", "order", result.OrderID, "location", result.Location, "cancelled", result.Cancelled)
+
+	if !result.Cancelled {
+		return nil
+	}
+
+	// the delegation may already be released, e.g. if the order was aborted before
+	_, err := s.releaseDelegation(msg.Context(), result.OrderID)
+	if db.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// releaseDelegation completes the open delegation of an order and decreases the load of its factory
+// Completing the delegation is a single conditional update, so only one of several duplicate or redelivered
+// messages decreases the load, the others receive a not found error
+func (s *Service) releaseDelegation(ctx context.Context, orderID string) (entities.Delegation, error) {
+	delegation, err := s.StorageFor(ctx).CompleteDelegation(entities.Delegation{
+		OrderID:   orderID,
+		Completed: time.Now().UTC(),
+	})
+	if err != nil {
+		return entities.Delegation{}, err
+	}
+
+	// a retry wouldn't find the open delegation anymore, the next load report corrects the load instead
+	err = s.changeLoad(ctx, delegation.Location, -1)
+	if err != nil {
+		s.Logger.Errorw("Failed to release load, waiting for the next load report", "order", orderID, "location", delegation.Location, "err", err)
+	}
+
+	return delegation, nil
+}
+
+// releaseOrder completes the open delegation of an order, decreases the load of its factory and tells the factory
+// to abort the order, it returns the location of the factory
+// Orders without open delegation were never delegated or already released, so there is nothing to do
+func (s *Service) releaseOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage, reason string) (string, error) {
+	storage := s.StorageFor(msg.Context())
+
+	delegation, err := storage.FindOpenDelegation(orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// tell the factory first, a retry after a failed publish still finds the open delegation
+	abortMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeAbortOrder,
+		OrderID:   orderMsg.OrderID,
+		Location:  delegation.Location,
+		Reason:    reason,
+	}
+
+	body, err := json.Marshal(abortMsg)
+	if err != nil {
+		return "", err
+	}
+
+	producer, err := s.ProducerFor(delegation.Location)
+	if err != nil {
+		return "", err
+	}
+
+	err = producer.Publish(msg.Envelope.Follow(rbmq.TypeAbortOrder), body, "factory")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.releaseDelegation(msg.Context(), orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		// a concurrent message already released the order
+		return delegation.Location, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.Logger.Infow("Released order from factory", "order", orderMsg.OrderID, "location", delegation.Location)
+
+	return delegation.Location, nil
+}
+
+// delegate chooses a live factory for an order and forwards the order to it
+// The excluded factory is only chosen if no other factory is live
+func (s *Service) delegate(msg rbmq.Message, orderMsg rbmq.OrderMessage, exclude string) error {
+	envelope := msg.Envelope.Follow(rbmq.TypeNewOrder)
+
+	// a retried or redelivered order was already assigned to a factory, it is sent to the same factory again
+	delegation, err := s.StorageFor(msg.Context()).FindOpenDelegation(orderMsg.OrderID)
+	if err == nil {
+		s.Logger.Infow("Resuming delegation", "order", orderMsg.OrderID, "location", delegation.Location)
+		return s.delegateTo(envelope, delegation.Location, orderMsg)
+	}
+	if !db.IsNotFound(err) {
+		return err
+	}
+
+	// get the current status of each factory
+	status, err := s.getFactoryStatus(msg.Context())
+	if err != nil {
+		return err
+	}
+
+	// only active factories that recently sent a heartbeat receive new orders
+	locations := s.liveFactories(status)
+	if len(locations) == 0 {
+		return fmt.Errorf("No live factory available for order %s", orderMsg.OrderID)
+	}
+
+	if exclude != "" && len(locations) > 1 {
+		var others []string
+		for _, location := range locations {
+			if location != exclude {
+				others = append(others, location)
+			}
+		}
+		if len(others) > 0 {
+			locations = others
+		}
+	}
+
+	var candidates []Candidate
+	for _, location := range locations {
+		factory, _ := s.Topology.Factory(location)
+		candidates = append(candidates, Candidate{Status: status[location], Factory: factory})
+	}
+
+	// let the strategy choose one of the factories
+	decision, err := s.strategy.Select(msg.Context(), orderMsg, candidates)
+	if err != nil {
+		s.Logger.Errorw("Failed to choose factory", "order", orderMsg.OrderID, "strategy", s.strategy.Name(), "err", err)
+		return err
+	}
 
-		// decode the msg body
-		orderMsg := rbmq.OrderMessage{}
-		err := json.Unmarshal(msg.Body, &orderMsg)
+	s.Logger.Infow("Chose factory", "order", orderMsg.OrderID, "location", decision.Location,
+		"strategy", decision.Strategy, "reason", decision.Reason)
+
+	// the decision is stored before the order is sent, so a retry after a failed publish finds it,
+	// doesn't choose another factory and doesn't count the order again
+	err = s.recordDecision(msg.Context(), orderMsg, decision)
+	if err != nil {
+		return err
+	}
+
+	return s.delegateTo(envelope, decision.Location, orderMsg)
+}
+
+// recordDecision stores which strategy delegated an order and why and increases the load of the chosen factory
+// The load is only increased together with a new delegation, resumed delegations don't count the order again
+func (s *Service) recordDecision(ctx context.Context, orderMsg rbmq.OrderMessage, decision Decision) error {
+	_, err := s.StorageFor(ctx).CreateDelegation(entities.Delegation{
+		OrderID:  orderMsg.OrderID,
+		Location: decision.Location,
+		Strategy: decision.Strategy,
+		Reason:   decision.Reason,
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		s.Logger.Errorw("Failed to record delegation", "order", orderMsg.OrderID, "err", err)
+		return err
+	}
+
+	metrics.Delegations.WithLabelValues(decision.Strategy, decision.Location).Inc()
+
+	// a retry finds the delegation and doesn't increase the load, the next load report corrects it instead
+	err = s.changeLoad(ctx, decision.Location, 1)
+	if err != nil {
+		s.Logger.Errorw("Failed to increase load, waiting for the next load report", "order", orderMsg.OrderID, "location", decision.Location, "err", err)
+	}
+
+	return nil
+}
+
+// customerCountry requests the country of a customer from the customer service
+func (s *Service) customerCountry(ctx context.Context, customerID string) (string, error) {
+	customerURL, err := s.Topology.ServiceURL("customer")
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := tracing.Get(ctx, fmt.Sprintf("%s/%s", customerURL, customerID))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Customer service responded with %s", resp.Status)
+	}
+
+	var customer entities.Customer
+	err = json.NewDecoder(resp.Body).Decode(&customer)
+	if err != nil {
+		return "", err
+	}
+
+	return customer.Address.Country, nil
+}
+
+// getFactoryStatus accumulates the status of each registered factory
+func (s *Service) getFactoryStatus(ctx context.Context) (map[string]entities.FactoryStatus, error) {
+	statusMap := make(map[string]entities.FactoryStatus)
+
+	factories, err := s.StorageFor(ctx).AllFactoryStatus()
+	if err != nil {
+		return statusMap, err
+	}
+
+	for _, status := range factories {
+		// a capacity set by an administrator replaces the announced capacity
+		if status.CapacityOverride > 0 {
+			status.MaxConcurrentOrders = status.CapacityOverride
+		}
+
+		statusMap[status.Location] = status
+		s.reportFactoryStatus(status)
+	}
+
+	return statusMap, nil
+}
+
+// liveFactories returns the sorted locations of all active factories whose last heartbeat isn't older than the heartbeat timeout
+func (s *Service) liveFactories(status map[string]entities.FactoryStatus) []string {
+	var locations []string
+	for location, factory := range status {
+		if s.isLive(factory) && factory.Mode == "" {
+			locations = append(locations, location)
+		}
+	}
+
+	sort.Strings(locations)
+	return locations
+}
+
+// isLive checks if a factory sent a heartbeat within the heartbeat timeout and has capacity for orders
+func (s *Service) isLive(status entities.FactoryStatus) bool {
+	return status.MaxConcurrentOrders > 0 && time.Since(status.LastHeartbeat) <= s.config.HeartbeatTimeout
+}
+
+// watchFactories periodically checks the heartbeats of all factories and logs when a factory goes down or comes back
+func (s *Service) watchFactories() {
+	live := make(map[string]bool)
+
+	for {
+		<-time.After(s.config.HeartbeatInterval)
+
+		status, err := s.getFactoryStatus(context.Background())
 		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
+			s.Logger.Errorw("Failed to fetch factory status", "err", err)
 			continue
 		}
 
-		// read the message type to decide whether its a new order that needs to be delegated
-		// or an existing one that has been updated and thus decreasing the load
-		if orderMsg.MsgType == "delegate" {
-			err = s.delegateOrder(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to delegate message", "err", err)
+		for location, factory := range status {
+			isLive := s.isLive(factory)
+			if wasLive, known := live[location]; known && wasLive && !isLive {
+				s.Logger.Warnw("Factory stopped sending heartbeats, no longer delegating orders to it",
+					"location", location, "lastHeartbeat", factory.LastHeartbeat)
+			} else if known && !wasLive && isLive {
+				s.Logger.Infow("Factory is sending heartbeats again", "location", location)
 			}
-		} else if orderMsg.MsgType == "orderupdate" {
-			err = s.updateFactoryStatus(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to update factory status", "location", orderMsg.Location, "err", err)
-			}
-		} else {
-			s.Logger.Debugw("Unhandled message type", "type", orderMsg.MsgType)
+			live[location] = isLive
 		}
 	}
 }
 
-// delegateOrder determines the location a new order is being sent to
-func (s *Service) delegateOrder(orderMsg rbmq.OrderMessage) error {
-	targetLocation := ""
-	var relativeLoadUSA float32 = 0
-	var relativeLoadChina float32 = 0
+// registerFactory adds a factory to the registry or refreshes its heartbeat
+func (s *Service) registerFactory(msg rbmq.Message, factoryMsg rbmq.FactoryMessage) error {
+	if factoryMsg.Location == "" || factoryMsg.MaxConcurrentOrders <= 0 {
+		return rbmq.Permanent(fmt.Errorf("Invalid factory announcement for location %q with capacity %d",
+			factoryMsg.Location, factoryMsg.MaxConcurrentOrders))
+	}
 
-	s.Logger.Infow("Received order", "order", orderMsg.OrderID)
+	if factoryMsg.MsgType == rbmq.TypeRegisterFactory {
+		s.Logger.Infow("Registering factory", "location", factoryMsg.Location, "capacity", factoryMsg.MaxConcurrentOrders)
+	}
 
-	// get the current status of each factory
-	status, err := s.getFactoryStatus()
+	// the time of reception is used as heartbeat so that clocks of the factories don't have to be in sync
+	status := entities.FactoryStatus{
+		Location:            factoryMsg.Location,
+		MaxConcurrentOrders: factoryMsg.MaxConcurrentOrders,
+		LastHeartbeat:       time.Now().UTC(),
+	}
+
+	err := s.StorageFor(msg.Context()).RegisterFactory(status)
+	if err != nil {
+		s.Logger.Errorw("Failed to register factory", "location", factoryMsg.Location, "err", err)
+		return err
+	}
+
+	metrics.FactoryCapacity.WithLabelValues(status.Location).Set(float64(status.MaxConcurrentOrders))
+	metrics.FactoryUp.WithLabelValues(status.Location).Set(1)
+	return nil
+}
+
+// changeLoad atomically increases or decreases the load of a factory and exports the new load as metric
+func (s *Service) changeLoad(ctx context.Context, location string, delta int) error {
+	status, err := s.StorageFor(ctx).IncrementFactoryLoad(location, delta)
 	if err != nil {
+		s.Logger.Errorw("Failed to update load", "location", location, "err", err)
 		return err
 	}
 
-	// calculate the relative load of each factory
-	relativeLoadUSA = float32(status["usa"].CurrentLoad) / float32(status["usa"].MaxConcurrentOrders)
-	relativeLoadChina = float32(status["china"].CurrentLoad) / float32(status["china"].MaxConcurrentOrders)
+	s.Logger.Infow("Updated load", "location", location, "load", status.CurrentLoad)
+
+	metrics.FactoryLoad.WithLabelValues(status.Location).Set(float64(status.CurrentLoad))
+	return nil
+}
+
+// requestLoadReports asks every live factory for its number of unfinished orders every reconcile interval
+func (s *Service) requestLoadReports() {
+	for {
+		<-time.After(s.config.ReconcileInterval)
+
+		status, err := s.getFactoryStatus(context.Background())
+		if err != nil {
+			s.Logger.Errorw("Failed to fetch factory status", "err", err)
+			continue
+		}
+
+		for location, factory := range status {
+			// factories that stopped sending heartbeats can't answer
+			if !s.isLive(factory) {
+				continue
+			}
 
-	// send the order to the factory with less load, or the one with the higher capacity if both have the same relative load
-	if relativeLoadUSA == relativeLoadChina {
-		if status["usa"].MaxConcurrentOrders > status["china"].MaxConcurrentOrders {
-			targetLocation = "usa"
-		} else {
-			targetLocation = "china"
+			err := s.requestLoad(location)
+			if err != nil {
+				s.Logger.Errorw("Failed to request load of factory", "location", location, "err", err)
+			}
 		}
-	} else if relativeLoadUSA > relativeLoadChina {
-		targetLocation = "china"
-	} else {
-		targetLocation = "usa"
 	}
+}
 
-	s.Logger.Infow("Calculating relative load", "china", relativeLoadChina, "usa", relativeLoadUSA)
+// requestLoad sends a load request to a factory
+func (s *Service) requestLoad(location string) error {
+	factoryMsg := rbmq.FactoryMessage{
+		Timestamp:   time.Now().UTC(),
+		MsgType:     rbmq.TypeRequestLoad,
+		Location:    location,
+		RequestedAt: time.Now().UTC(),
+	}
 
-	return s.delegateTo(status[targetLocation], orderMsg)
+	body, err := json.Marshal(factoryMsg)
+	if err != nil {
+		return err
+	}
+
+	producer, err := s.ProducerFor(location)
+	if err != nil {
+		return err
+	}
+
+	return producer.Publish(rbmq.NewEnvelope(rbmq.TypeRequestLoad, location), body, "factory")
 }
 
-// getFactoryStatus accumulates the status of each factory
-func (s *Service) getFactoryStatus() (map[string]entities.FactoryStatus, error) {
-	var err error
-	statusMap := make(map[string]entities.FactoryStatus)
+// reconcileLoad corrects the tracked load of a factory with the number of unfinished orders the factory reported
+// Orders delegated after the request was sent may not have reached the factory yet, so they are added to the reported count
+func (s *Service) reconcileLoad(msg rbmq.Message, factoryMsg rbmq.FactoryMessage) error {
+	if factoryMsg.Location == "" || factoryMsg.OpenOrders < 0 {
+		return rbmq.Permanent(fmt.Errorf("Invalid load report for location %q with %d open orders",
+			factoryMsg.Location, factoryMsg.OpenOrders))
+	}
+
+	storage := s.StorageFor(msg.Context())
 
-	statusMap["usa"], err = s.Storage.GetFactoryStatus("usa")
+	status, err := storage.GetFactoryStatus(factoryMsg.Location)
 	if err != nil {
-		return statusMap, err
+		return err
 	}
 
-	statusMap["china"], err = s.Storage.GetFactoryStatus("china")
+	delegations, err := storage.OpenDelegations(factoryMsg.Location)
 	if err != nil {
-		return statusMap, err
+		return err
 	}
 
-	return statusMap, err
+	expected := factoryMsg.OpenOrders
+	for _, delegation := range delegations {
+		if delegation.Created.After(factoryMsg.RequestedAt) {
+			expected++
+		}
+	}
+
+	if status.CurrentLoad == expected {
+		return nil
+	}
+
+	s.Logger.Warnw("Correcting load of factory", "location", factoryMsg.Location,
+		"tracked", status.CurrentLoad, "actual", expected, "reported", factoryMsg.OpenOrders)
+
+	// the difference is applied atomically so that loads changed in the meantime aren't overwritten
+	err = s.changeLoad(msg.Context(), factoryMsg.Location, expected-status.CurrentLoad)
+	if err != nil {
+		return err
+	}
+
+	metrics.FactoryLoadCorrections.WithLabelValues(factoryMsg.Location).Inc()
+	return nil
+}
+
+// reportFactoryStatus sets the load, capacity and liveness metrics of a factory
+func (s *Service) reportFactoryStatus(status entities.FactoryStatus) {
+	up := 0.0
+	if s.isLive(status) {
+		up = 1
+	}
+
+	metrics.FactoryLoad.WithLabelValues(status.Location).Set(float64(status.CurrentLoad))
+	metrics.FactoryCapacity.WithLabelValues(status.Location).Set(float64(status.MaxConcurrentOrders))
+	metrics.FactoryUp.WithLabelValues(status.Location).Set(up)
 }
 
 // delegateTo forwards an order to a specific location
-func (s *Service) delegateTo(status entities.FactoryStatus, orderMsg rbmq.OrderMessage) error {
+// The load of the location was already increased when the delegation was recorded, so it can be called again
+// for retried or redelivered orders, the factory ignores orders it already received
+func (s *Service) delegateTo(envelope rbmq.Envelope, location string, orderMsg rbmq.OrderMessage) error {
 	// update the messages timestamp and status
 	orderMsg.Timestamp = time.Now().UTC()
-	orderMsg.MsgType = "neworder"
+	orderMsg.MsgType = rbmq.TypeNewOrder
 
 	// encode the message to json
 	msgBody, err := json.Marshal(orderMsg)
@@ -153,33 +645,32 @@ func (s *Service) delegateTo(status entities.FactoryStatus, orderMsg rbmq.OrderM
 		return err
 	}
 
-	// publish the message to the location
-	s.Producer[status.Location].Publish(msgBody, "factory")
+	// publish the message to the location, the producer is created on first use since factories register at runtime
+	producer, err := s.ProducerFor(location)
 	if err != nil {
 		return err
 	}
 
-	s.Logger.Infow("Delegating order to factory", "order", orderMsg.OrderID, "location", status.Location)
-
-	// update the current load of the location
-	status.CurrentLoad = status.CurrentLoad + 1
+	err = producer.Publish(envelope, msgBody, "factory")
+	if err != nil {
+		return err
+	}
 
-	return s.Storage.UpdateFactoryStatus(status)
+	s.Logger.Infow("Delegating order to factory", "order", orderMsg.OrderID, "location", location)
+	return nil
 }
 
 // updateFactoryStatus changes the status of a factory
 // It is usually called when a factory completed an order and thus decreases its load
-func (s *Service) updateFactoryStatus(orderMsg rbmq.OrderMessage) error {
+func (s *Service) updateFactoryStatus(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
 	s.Logger.Infow("Received order update", "order", orderMsg.OrderID)
 
-	status, err := s.Storage.GetFactoryStatus(orderMsg.Location)
-	if err != nil {
-		return err
+	// the order no longer counts as assigned to the factory, orders that were already released,
+	// e.g. by an abort or a redelivered message, don't decrease the load again
+	_, err := s.releaseDelegation(msg.Context(), orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		s.Logger.Infow("Order has no open delegation", "order", orderMsg.OrderID)
+		return nil
 	}
-
-	status.CurrentLoad = status.CurrentLoad - 1
-
-	s.Logger.Infow("Updating load", "location", orderMsg.Location, "load", status.CurrentLoad)
-
-	return s.Storage.UpdateFactoryStatus(status)
+	return err
 }
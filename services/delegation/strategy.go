@@ -0,0 +1,256 @@
+package delegation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+)
+
+const (
+	// StrategyLeastLoad sends an order to the factory with the least relative load
+	StrategyLeastLoad = "leastload"
+	// StrategyWeightedRoundRobin distributes orders in turn, weighted by the capacity of each factory
+	StrategyWeightedRoundRobin = "roundrobin"
+	// StrategyNearest sends an order to a factory that serves the country of the customer
+	StrategyNearest = "nearest"
+	// StrategyCheapest sends an order to the factory with the lowest expected costs of parts
+	StrategyCheapest = "cheapest"
+	// StrategyFastest sends an order to the factory with the shortest expected completion time
+	StrategyFastest = "fastest"
+)
+
+// Candidate is a live factory an order can be delegated to
+type Candidate struct {
+	Status entities.FactoryStatus
+	// Factory is the topology entry of the factory, it is empty if the factory isn't part of the topology
+	Factory service.Factory
+}
+
+// relativeLoad returns the share of the factory's capacity that is in use
+func (c Candidate) relativeLoad() float32 {
+	return float32(c.Status.CurrentLoad) / float32(c.Status.MaxConcurrentOrders)
+}
+
+// Decision describes which factory a strategy chose for an order and why
+type Decision struct {
+	Strategy string
+	Location string
+	Reason   string
+}
+
+// Strategy selects the factory a new order is delegated to
+// The candidates are sorted by location and contain at least one factory
+type Strategy interface {
+	Name() string
+	Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error)
+}
+
+// CountryLookup returns the country a customer lives in
+type CountryLookup func(ctx context.Context, customerID string) (string, error)
+
+// NewStrategy returns the strategy with the given name, the least load strategy is used if the name is empty
+func NewStrategy(name string, lookup CountryLookup) (Strategy, error) {
+	switch name {
+	case "", StrategyLeastLoad:
+		return leastLoad{}, nil
+	case StrategyWeightedRoundRobin:
+		return &weightedRoundRobin{current: make(map[string]int)}, nil
+	case StrategyNearest:
+		return nearest{lookup: lookup}, nil
+	case StrategyCheapest:
+		return cheapest{}, nil
+	case StrategyFastest:
+		return fastest{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown delegation strategy %s", name)
+	}
+}
+
+// leastLoad chooses the factory with the least relative load, or the one with the higher capacity if both have the same relative load
+type leastLoad struct{}
+
+func (leastLoad) Name() string {
+	return StrategyLeastLoad
+}
+
+func (leastLoad) Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error) {
+	target := leastLoaded(candidates)
+
+	return Decision{
+		Strategy: StrategyLeastLoad,
+		Location: target.Status.Location,
+		Reason: fmt.Sprintf("lowest relative load %.2f (%d of %d orders)",
+			target.relativeLoad(), target.Status.CurrentLoad, target.Status.MaxConcurrentOrders),
+	}, nil
+}
+
+// leastLoaded returns the candidate with the least relative load, ties go to the factory with the higher capacity
+func leastLoaded(candidates []Candidate) Candidate {
+	target := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.relativeLoad() < target.relativeLoad() ||
+			(candidate.relativeLoad() == target.relativeLoad() && candidate.Status.MaxConcurrentOrders > target.Status.MaxConcurrentOrders) {
+			target = candidate
+		}
+	}
+	return target
+}
+
+// weightedRoundRobin distributes orders smoothly in proportion to the capacity of the factories
+// Every pick adds the capacity of each factory to its current weight, the factory with the highest
+// current weight is chosen and the total capacity is subtracted from its weight
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+func (*weightedRoundRobin) Name() string {
+	return StrategyWeightedRoundRobin
+}
+
+func (s *weightedRoundRobin) Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	var target Candidate
+	for i, candidate := range candidates {
+		location := candidate.Status.Location
+		s.current[location] += candidate.Status.MaxConcurrentOrders
+		total += candidate.Status.MaxConcurrentOrders
+
+		if i == 0 || s.current[location] > s.current[target.Status.Location] {
+			target = candidate
+		}
+	}
+	s.current[target.Status.Location] -= total
+
+	return Decision{
+		Strategy: StrategyWeightedRoundRobin,
+		Location: target.Status.Location,
+		Reason:   fmt.Sprintf("next in turn with weight %d of %d", target.Status.MaxConcurrentOrders, total),
+	}, nil
+}
+
+// nearest chooses a factory that serves the country of the customer
+// If several factories serve the country the one with the least load is chosen,
+// if none does the order is delegated like with the least load strategy
+type nearest struct {
+	lookup CountryLookup
+}
+
+func (nearest) Name() string {
+	return StrategyNearest
+}
+
+func (s nearest) Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error) {
+	country, err := s.lookup(ctx, orderMsg.Customer)
+	if err != nil {
+		return Decision{}, fmt.Errorf("Failed to look up country of customer %s: %s", orderMsg.Customer, err)
+	}
+
+	var serving []Candidate
+	for _, candidate := range candidates {
+		for _, served := range candidate.Factory.Countries {
+			if strings.EqualFold(served, country) {
+				serving = append(serving, candidate)
+				break
+			}
+		}
+	}
+
+	if len(serving) == 0 {
+		target := leastLoaded(candidates)
+		return Decision{
+			Strategy: StrategyNearest,
+			Location: target.Status.Location,
+			Reason:   fmt.Sprintf("no factory serves %q, chose lowest relative load %.2f", country, target.relativeLoad()),
+		}, nil
+	}
+
+	target := leastLoaded(serving)
+	return Decision{
+		Strategy: StrategyNearest,
+		Location: target.Status.Location,
+		Reason:   fmt.Sprintf("serves customer country %q", country),
+	}, nil
+}
+
+// cheapest chooses the factory with the lowest expected costs of parts
+// The expected costs are the prices of all parts of the order multiplied with the cost factor of the factory
+type cheapest struct{}
+
+func (cheapest) Name() string {
+	return StrategyCheapest
+}
+
+func (cheapest) Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error) {
+	partsCost := 0
+	for _, item := range orderMsg.Items {
+		partsCost += item.PartsCost * item.Units()
+	}
+
+	expected := func(candidate Candidate) float32 {
+		return float32(partsCost) * factor(candidate.Factory.CostFactor)
+	}
+
+	target := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if expected(candidate) < expected(target) ||
+			(expected(candidate) == expected(target) && candidate.relativeLoad() < target.relativeLoad()) {
+			target = candidate
+		}
+	}
+
+	return Decision{
+		Strategy: StrategyCheapest,
+		Location: target.Status.Location,
+		Reason:   fmt.Sprintf("lowest expected costs of parts %.2f", expected(target)),
+	}, nil
+}
+
+// fastest chooses the factory with the shortest expected completion time
+// The assembly time of the order is multiplied with the speed factor of the factory and
+// increased by the relative load since a busy factory starts the assembly later
+type fastest struct{}
+
+func (fastest) Name() string {
+	return StrategyFastest
+}
+
+func (fastest) Select(ctx context.Context, orderMsg rbmq.OrderMessage, candidates []Candidate) (Decision, error) {
+	assemblyTime := 0
+	for _, item := range orderMsg.Items {
+		assemblyTime += item.AssemblyTime * item.Units()
+	}
+
+	expected := func(candidate Candidate) float32 {
+		return float32(assemblyTime) * factor(candidate.Factory.SpeedFactor) * (1 + candidate.relativeLoad())
+	}
+
+	target := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if expected(candidate) < expected(target) {
+			target = candidate
+		}
+	}
+
+	return Decision{
+		Strategy: StrategyFastest,
+		Location: target.Status.Location,
+		Reason:   fmt.Sprintf("shortest expected completion time %.1fs", expected(target)),
+	}, nil
+}
+
+// factor returns a factor of the topology, factories without factor are treated as average
+func factor(value float32) float32 {
+	if value <= 0 {
+		return 1
+	}
+	return value
+}
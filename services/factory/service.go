@@ -1,41 +1,61 @@
 package factory
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 	"go.uber.org/zap"
 )
 
-var additionalProducers = [...]string{"london"}
+// Config contains the settings of a factory
+type Config struct {
+	// Capacity overrides the capacity the factory announces, by default the capacity of the topology is used
+	Capacity int
+	// HeartbeatInterval is how often the factory announces itself to the delegation service
+	HeartbeatInterval time.Duration
+}
 
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
+
+	config Config
+
+	// capacity is the number of orders the factory announces it can work on at the same time
+	capacity int
 }
 
 // New launches a new custom service based on the service library in /pkg/service
-func New(config *service.Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
+func New(config *service.Config, factoryConfig Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
 	var err error
 
 	// initialize a new service instance based on the config
-	factoryService := &Service{}
+	factoryService := &Service{config: factoryConfig}
 	factoryService.Service, err = service.New(config, messages, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	// add additional producers to send messages to the headquarter
-	for _, location := range additionalProducers {
-		producer, err := factoryService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
+	// determine the capacity the factory announces to the delegation service
+	factoryService.capacity = factoryConfig.Capacity
+	if factoryService.capacity <= 0 {
+		factory, ok := factoryService.Topology.Factory(config.Location)
+		if !ok || factory.MaxConcurrentOrders <= 0 {
+			return nil, fmt.Errorf("No capacity configured for factory %s", config.Location)
 		}
+		factoryService.capacity = factory.MaxConcurrentOrders
+	}
 
-		factoryService.Producer[location] = producer
+	// add additional producers to send messages to the headquarter
+	err = factoryService.AddProducers(factoryService.Topology.Headquarter)
+	if err != nil {
+		return nil, err
 	}
 
 	// initialize the database
@@ -44,123 +64,380 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go factoryService.handleRbmqMessage(messages)
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	// a factory receives new orders, updates to existing orders, aborts of orders that missed a deadline,
+	// cancellations and their outcome at the part and assembly service and requests for new kpi and its current load
+	factoryService.Router.Handle(rbmq.TypeNewOrder, rbmq.OrderHandler(factoryService.handleNewOrder))
+	factoryService.Router.Handle(rbmq.TypeOrderUpdate, rbmq.OrderHandler(factoryService.handleOrderUpdate))
+	factoryService.Router.Handle(rbmq.TypeAbortOrder, rbmq.OrderHandler(factoryService.handleAbortOrder))
+	factoryService.Router.Handle(rbmq.TypeCancelOrder, rbmq.OrderHandler(factoryService.handleCancelOrder))
+	factoryService.Router.Handle(rbmq.TypeCancelResult, rbmq.OrderHandler(factoryService.handleCancelResult))
+	factoryService.Router.Handle(rbmq.TypeRequestKPI, rbmq.KPIHandler(factoryService.handleKPIRequest))
+	factoryService.Router.Handle(rbmq.TypeRequestLoad, rbmq.FactoryHandler(factoryService.handleLoadRequest))
+	go factoryService.Router.Run(messages)
+
+	// launch a new thread that registers the factory with the delegation service and keeps sending heartbeats
+	go factoryService.announce()
 
 	return factoryService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
+// handleNewOrder stores a new order, orders its parts and notifies the headquarter that production started
+// A retried or redelivered order is already stored, its parts are only ordered again while they weren't delivered yet
+func (s *Service) handleNewOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received new order", "order", orderMsg.OrderID)
+
+	order, err := s.StorageFor(msg.Context()).FindOrderFactory(orderMsg.OrderID)
+	switch {
+	case err == nil && order.Status != "waitingForParts":
+		s.Logger.Infow("Dropping duplicate order", "order", orderMsg.OrderID, "status", order.Status)
+		return nil
+	case err == nil:
+		s.Logger.Infow("Resuming stored order", "order", orderMsg.OrderID)
+	case db.IsNotFound(err):
+		// add order to the database
+		err = s.insertOrder(msg.Context(), orderMsg)
+		if err != nil {
+			s.Logger.Errorw("Failed to insert into database", "err", err)
+			return err
+		}
+	default:
+		s.Logger.Errorw("Failed to find order", "id", orderMsg.OrderID, "err", err)
+		return err
+	}
+
+	// prepare the message to the part service
+	orderMsg.MsgType = rbmq.TypeOrderPart
+	orderMsg.Timestamp = time.Now().UTC()
+
+	body, err := json.Marshal(orderMsg)
+	if err != nil {
+		s.Logger.Errorw("Failed to marshal message", "err", err)
+		return err
+	}
+
+	// send a part order to the part service
+	err = s.Producer[s.Config.Location].Publish(msg.Envelope.Follow(rbmq.TypeOrderPart), body, "part")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to part service", "err", err)
+		return err
+	}
+
+	// a retry would order the parts twice, so a lost progress update is only logged
+	s.notifyOrderService(msg.Envelope.Follow(rbmq.TypeOrderUpdate), orderMsg, "production")
+	return nil
+}
+
+// notifyOrderService reports the progress of an order to the order service in the headquarter
+func (s *Service) notifyOrderService(envelope rbmq.Envelope, orderMsg rbmq.OrderMessage, status string) error {
+	orderMsg.Timestamp = time.Now().UTC()
+	orderMsg.Status = status
+	orderMsg.MsgType = rbmq.TypeOrderUpdate
+	orderMsg.Location = s.Config.Location
+
+	body, err := json.Marshal(orderMsg)
+	if err != nil {
+		s.Logger.Errorw("Failed to marshal message", "err", err)
+		return err
+	}
+
+	err = s.Producer[s.Topology.Headquarter].Publish(envelope, body, "order")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to order service", "err", err)
+	}
+
+	return err
+}
+
+// handleOrderUpdate updates an order and forwards it to the next production step
+func (s *Service) handleOrderUpdate(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order update", "order", orderMsg.OrderID, "status", orderMsg.Status)
+
+	targetService := ""
+	targetLocation := s.Config.Location
+	progress := ""
+
+	// aborted and cancelled orders aren't produced any further, parts delivered for them are returned right away
+	order, err := s.StorageFor(msg.Context()).FindOrderFactory(orderMsg.OrderID)
+	if err != nil {
+		s.Logger.Errorw("Failed to find order", "id", orderMsg.OrderID, "err", err)
+		return err
+	}
+	if order.Status == "failed" || order.Status == "cancelled" {
+		s.Logger.Infow("Dropping update of stopped order", "order", orderMsg.OrderID, "status", orderMsg.Status)
+		if orderMsg.Status == "partsdelivered" {
+			return s.releaseParts(msg.Context(), msg.Envelope.Follow(rbmq.TypeReleaseParts), orderMsg)
+		}
+		return nil
+	}
+
+	// redelivered updates were already forwarded, only the notification of shipped orders is repeated
+	// since the headquarter ignores duplicate notifications and the first one may have failed
+	if reached(order.Status, orderMsg.Status) && orderMsg.Status != "shipped" {
+		s.Logger.Infow("Dropping duplicate order update", "order", orderMsg.OrderID, "status", orderMsg.Status)
+		return nil
+	}
+
+	// check the orders status to update the status in the database accordingly and notify the headquarter if an order is complete
+	if orderMsg.Status == "partsdelivered" {
+		orderMsg.Timestamp = time.Now().UTC()
+		targetService = "assembly"
+		progress = "partsdelivered"
+		err := s.updateCosts(msg.Context(), orderMsg)
+		if err != nil {
+			s.Logger.Errorw("Failed to update costs", "id", orderMsg.OrderID, "err", err)
+			return err
+		}
+	} else if orderMsg.Status == "complete" {
+		orderMsg.Timestamp = time.Now().UTC()
+		targetService = "shipping"
+		progress = "assembled"
+	} else if orderMsg.Status == "shipped" {
+		// shipped orders are only stored, the headquarter is notified after the update succeeded
+	} else {
+		s.Logger.Errorw("Unknown order status", "status", orderMsg.Status)
+		return rbmq.Permanent(fmt.Errorf("Unknown order status %s", orderMsg.Status))
+	}
 
-		// decode the msg body
-		orderMsg := rbmq.OrderMessage{}
-		err := json.Unmarshal(msg.Body, &orderMsg)
+	if orderMsg.Status == "shipped" {
+		// update the database entry for an order
+		err = s.updateFactoryOrder(msg.Context(), orderMsg)
 		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
+			s.Logger.Errorw("Failed to update order", "id", orderMsg.OrderID, "err", err)
+			return err
 		}
 
-		// read the message type to decide whether its a new order, an update to an existing order or a request for new kpi
-		switch orderMsg.MsgType {
-		case "neworder":
-			s.Logger.Infow("Received new order", "order", orderMsg.OrderID)
+		return s.notifyLondon(msg.Envelope.Follow(rbmq.TypeOrderUpdate), orderMsg)
+	}
 
-			// add order to the database and receive the message to be forwarded to the part service
-			orderMsg, err := s.insertOrder(orderMsg)
+	// encode the message body
+	event, err := json.Marshal(orderMsg)
+	if err != nil {
+		s.Logger.Errorw("Failed to marshal message", "err", err)
+		return err
+	}
 
-			if err != nil {
-				s.Logger.Errorw("Failed to insert into database", "err", err)
-			}
+	// publish the message to the next service before the new status is stored,
+	// so a retry after a failed publish doesn't take the update for a duplicate
+	err = s.Producer[targetLocation].Publish(msg.Envelope.Follow(rbmq.TypeOrderUpdate), event, targetService)
+	if err != nil {
+		s.Logger.Errorw("Failed to send message", "service", targetService, "err", err)
+		return err
+	}
 
-			body, err := json.Marshal(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to marshal message", "err", err)
-			}
+	// update the database entry for an order
+	err = s.updateFactoryOrder(msg.Context(), orderMsg)
+	if err != nil {
+		s.Logger.Errorw("Failed to update order", "id", orderMsg.OrderID, "err", err)
+		return err
+	}
 
-			// send a part order to the part service
-			err = s.Producer[s.Config.Location].Publish(body, "part")
-			if err != nil {
-				s.Logger.Errorw("Failed to send message to part service", "err", err)
-			}
+	// the headquarter uses the progress to check the deadline of each step, the order already moved on
+	// so a lost progress update is only logged instead of retrying and forwarding the order twice
+	s.notifyOrderService(msg.Envelope.Follow(rbmq.TypeOrderUpdate), orderMsg, progress)
+	return nil
+}
 
-			orderMsg.Timestamp = time.Now().UTC()
-			orderMsg.Status = "production"
-			orderMsg.MsgType = "orderupdate"
+// handleAbortOrder stops the production of an order that missed a deadline and returns its parts if they were delivered
+// Parts that are still being delivered are returned once they arrive
+func (s *Service) handleAbortOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order abort", "order", orderMsg.OrderID, "reason", orderMsg.Reason)
 
-			body, err = json.Marshal(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to marshal message", "err", err)
-			}
+	order, err := s.StorageFor(msg.Context()).FindOrderFactory(orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		s.Logger.Infow("Aborted order was never received", "order", orderMsg.OrderID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-			err = s.Producer["london"].Publish(body, "order")
-			if err != nil {
-				s.Logger.Errorw("Failed to send message to order service", "err", err)
-			}
+	// shipped orders can't be stopped anymore
+	if order.Status == "shipped" || order.Status == "failed" || order.Status == "cancelled" {
+		return nil
+	}
 
-		case "orderupdate":
-			s.Logger.Infow("Received order update", "order", orderMsg.OrderID, "status", orderMsg.Status)
-
-			targetService := ""
-			targetLocation := s.Config.Location
-
-			// check the orders status to update the status in the database accordingly and notify the headquarter if an order is complete
-			if orderMsg.Status == "partsdelivered" {
-				orderMsg.Timestamp = time.Now().UTC()
-				targetService = "assembly"
-				s.updateCosts(orderMsg)
-			} else if orderMsg.Status == "complete" {
-				orderMsg.Timestamp = time.Now().UTC()
-				targetService = "shipping"
-			} else if orderMsg.Status == "shipped" {
-				s.notifyLondon(orderMsg)
-			} else {
-				s.Logger.Errorw("Unknown order status", "status", orderMsg.Status)
-				continue
-			}
+	// the parts of assembled orders are already built in, the costs are reset once the parts were sent back,
+	// so a retry doesn't send them twice
+	if order.Status == "partsdelivered" && order.CostsOfParts > 0 {
+		orderMsg.CostsOfParts = order.CostsOfParts
+		err = s.releaseParts(msg.Context(), msg.Envelope.Follow(rbmq.TypeReleaseParts), orderMsg)
+		if err != nil {
+			return err
+		}
+	}
 
-			// update the database entry for an order
-			err := s.updateFactoryOrder(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to update order", "id", orderMsg.OrderID, "err", err)
-			}
+	// the status is stored last, a retry skips stopped orders
+	return s.updateFactoryOrder(msg.Context(), rbmq.OrderMessage{
+		OrderID:   orderMsg.OrderID,
+		Timestamp: time.Now().UTC(),
+		Status:    "failed",
+	})
+}
 
-			// encode the message body
-			event, err := json.Marshal(orderMsg)
-			if err != nil {
-				s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-				continue
-			}
+// handleCancelOrder stops a cancelled order before it is shipped and reports the outcome to the headquarter
+// The factory doesn't forward a cancelled order to its next step, so an order can be cancelled until it is assembled,
+// the part or assembly service working on the order is asked to stop as well
+func (s *Service) handleCancelOrder(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order cancellation", "order", orderMsg.OrderID)
 
-			// publish the message to the next service
-			err = s.Producer[targetLocation].Publish(event, targetService)
-			if err != nil {
-				s.Logger.Errorw("Failed to send message to assembly", "err", err)
-			}
+	order, err := s.StorageFor(msg.Context()).FindOrderFactory(orderMsg.OrderID)
+	if db.IsNotFound(err) {
+		return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "cancelled", "The order never reached the factory")
+	}
+	if err != nil {
+		return err
+	}
 
-		case "requestkpi":
-			s.Logger.Info("Received kpi request")
+	stage := ""
+	switch order.Status {
+	case "waitingForParts":
+		stage = "part"
+	case "partsdelivered":
+		stage = "assembly"
+	case "complete":
+		return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "assembled", "The order was already assembled and is being shipped")
+	case "shipped":
+		return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "complete", "The order was already shipped")
+	default:
+		return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "cancelled", "The order was already stopped")
+	}
 
-			// get new kpis from the kpi request handler function
-			kpi, err := s.handleKPIRequest()
-			if err != nil {
-				s.Logger.Errorw("Failed to fetch kpis", "err", err)
-				continue
-			}
+	// ask the service working on the order to stop, it reports whether it was in time
+	cancelMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelOrder,
+		OrderID:   orderMsg.OrderID,
+	}
+
+	body, err := json.Marshal(cancelMsg)
+	if err != nil {
+		return err
+	}
+
+	err = s.Producer[s.Config.Location].Publish(msg.Envelope.Follow(rbmq.TypeCancelOrder), body, stage)
+	if err != nil {
+		s.Logger.Errorw("Failed to forward cancellation", "service", stage, "err", err)
+		return err
+	}
+
+	// the status is stored after the cancellation was forwarded, a retry finds the stopped order
+	// and only reports the cancellation again, the headquarter ignores duplicate results
+	err = s.updateFactoryOrder(msg.Context(), rbmq.OrderMessage{
+		OrderID:   orderMsg.OrderID,
+		Timestamp: time.Now().UTC(),
+		Status:    "cancelled",
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), orderMsg, "cancelled",
+		fmt.Sprintf("The factory stopped the order, the %s service was asked to stop", stage))
+}
+
+// reportCancellation reports the outcome of a cancellation at the factory to the delegation and order service
+// The status is "cancelled" if the factory stopped the order, otherwise it is the step the order already reached
+func (s *Service) reportCancellation(envelope rbmq.Envelope, orderMsg rbmq.OrderMessage, status string, reason string) error {
+	result := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelResult,
+		OrderID:   orderMsg.OrderID,
+		Location:  s.Config.Location,
+		Status:    status,
+		Stage:     "factory",
+		Cancelled: status == "cancelled",
+		Reason:    reason,
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Infow("Reporting cancellation", "order", orderMsg.OrderID, "cancelled", result.Cancelled, "reason", reason)
 
-			// send the new kpis to the headquarter
-			err = s.Producer["london"].Publish(kpi, "kpi")
+	// the delegation service releases the load of cancelled orders
+	err = s.Producer[s.Topology.Headquarter].Publish(envelope, body, "delegation")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to delegation service", "err", err)
+		return err
+	}
+
+	err = s.Producer[s.Topology.Headquarter].Publish(envelope, body, "order")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to order service", "err", err)
+	}
+
+	return err
+}
+
+// handleCancelResult forwards the outcome of a cancellation at the part or assembly service to the order service
+// The parts of an order whose assembly was stopped weren't built in and are sent back
+func (s *Service) handleCancelResult(msg rbmq.Message, result rbmq.OrderMessage) error {
+	s.Logger.Infow("Received cancellation result", "order", result.OrderID, "stage", result.Stage, "cancelled", result.Cancelled)
+
+	if result.Stage == "assembly" && result.Cancelled {
+		order, err := s.StorageFor(msg.Context()).FindOrderFactory(result.OrderID)
+		if err != nil {
+			return err
+		}
+
+		// the costs are reset once the parts were sent back, so a retry doesn't send them twice
+		if order.CostsOfParts > 0 {
+			err = s.releaseParts(msg.Context(), msg.Envelope.Follow(rbmq.TypeReleaseParts), rbmq.OrderMessage{
+				OrderID:      result.OrderID,
+				CostsOfParts: order.CostsOfParts,
+				Reason:       "The order was cancelled",
+			})
 			if err != nil {
-				s.Logger.Errorw("Failed to send message to assembly", "err", err)
+				return err
 			}
+		}
+	}
+
+	result.Location = s.Config.Location
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return s.Producer[s.Topology.Headquarter].Publish(msg.Envelope.Follow(rbmq.TypeCancelResult), body, "order")
+}
+
+// releaseParts sends the parts of an aborted order back to the part service and removes their costs from the kpis
+func (s *Service) releaseParts(ctx context.Context, envelope rbmq.Envelope, orderMsg rbmq.OrderMessage) error {
+	orderMsg.Timestamp = time.Now().UTC()
+	orderMsg.MsgType = rbmq.TypeReleaseParts
+
+	body, err := json.Marshal(orderMsg)
+	if err != nil {
+		return err
+	}
+
+	err = s.Producer[s.Config.Location].Publish(envelope, body, "part")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to part service", "err", err)
+		return err
+	}
 
-			s.Logger.Info("Sent aggregated kpis to kpi service")
-		default:
-			s.Logger.Errorw("Unhandled message type", "type", orderMsg.MsgType)
+	return s.updateCosts(ctx, rbmq.OrderMessage{OrderID: orderMsg.OrderID, CostsOfParts: 0})
+}
+
+// steps lists the statuses an order passes through during production in their order
+var steps = []string{"waitingForParts", "partsdelivered", "complete", "shipped"}
+
+// reached checks whether an order with the stored status already reached the given production step
+func reached(stored string, status string) bool {
+	for _, step := range steps {
+		if step == status {
+			return true
+		}
+		if step == stored {
+			return false
 		}
 	}
+	return false
 }
 
 func orderFromMessage(msg rbmq.OrderMessage) entities.Order {
@@ -172,15 +449,16 @@ func orderFromMessage(msg rbmq.OrderMessage) entities.Order {
 	}
 }
 
-func (s *Service) updateCosts(orderMsg rbmq.OrderMessage) {
+// updateCosts stores the costs of parts of an order
+func (s *Service) updateCosts(ctx context.Context, orderMsg rbmq.OrderMessage) error {
 	order := orderFromMessage(orderMsg)
-	s.Storage.UpdateOrderCosts(order)
+	return s.StorageFor(ctx).UpdateOrderCosts(order)
 }
 
 // updateFactoryOrder uses an order message to update an order's fields
-func (s *Service) updateFactoryOrder(msg rbmq.OrderMessage) error {
+func (s *Service) updateFactoryOrder(ctx context.Context, msg rbmq.OrderMessage) error {
 	order := orderFromMessage(msg)
-	return s.Storage.UpdateOrderStatusFactory(order)
+	return s.StorageFor(ctx).UpdateOrderStatusFactory(order)
 }
 
 // deprecated
@@ -200,41 +478,96 @@ func (s *Service) updateStatus(status string, msg rbmq.Message) []byte {
 }
 
 // insertOrder adds a new order to the factories database
-func (s *Service) insertOrder(orderMsg rbmq.OrderMessage) (rbmq.OrderMessage, error) {
-	var items []int
+func (s *Service) insertOrder(ctx context.Context, orderMsg rbmq.OrderMessage) error {
+	var lineItems []entities.LineItem
+	var total int
 	var err error
 
-	// aggregate all items in a list of strings
+	// convert the items to line items, the total of the order is used as revenue in the kpis
 	for _, item := range orderMsg.Items {
-		items = append(items, item.ItemID)
+		lineItems = append(lineItems, entities.LineItem{
+			Model:     item.ItemID,
+			Quantity:  item.Units(),
+			UnitPrice: item.UnitPrice,
+			Total:     item.Total,
+		})
+		total += item.Total
 	}
 
 	// create a new entity object
 	order := entities.Order{
-		Created:  time.Now().UTC(),
-		Status:   "waitingForParts",
-		Customer: orderMsg.Customer,
-		Items:    items,
-		OrderID:  orderMsg.OrderID,
+		Created:   time.Now().UTC(),
+		Status:    "waitingForParts",
+		Customer:  orderMsg.Customer,
+		LineItems: lineItems,
+		Total:     total,
+		OrderID:   orderMsg.OrderID,
 	}
 
 	// store the object in the database
-	_, err = s.Storage.CreateOrderFactory(order)
+	_, err = s.StorageFor(ctx).CreateOrderFactory(order)
+	return err
+}
+
+// handleKPIRequest aggregates new kpi entries and sends them to the headquarter
+func (s *Service) handleKPIRequest(msg rbmq.Message, kpiMsg rbmq.KPIMessage) error {
+	s.Logger.Info("Received kpi request")
+
+	// get new kpis
+	kpi, err := s.aggregateKPI(msg.Context())
 	if err != nil {
-		return orderMsg, err
+		s.Logger.Errorw("Failed to fetch kpis", "err", err)
+		return err
 	}
 
-	// prepare the message to the part service
-	orderMsg.MsgType = "orderpart"
-	orderMsg.Timestamp = time.Now().UTC()
+	// send the new kpis to the headquarter
+	err = s.Producer[s.Topology.Headquarter].Publish(msg.Envelope.Follow(rbmq.TypeKPIUpdate), kpi, "kpi")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to kpi service", "err", err)
+		return err
+	}
+
+	s.Logger.Info("Sent aggregated kpis to kpi service")
+	return nil
+}
+
+// handleLoadRequest reports the number of unfinished orders to the delegation service
+func (s *Service) handleLoadRequest(msg rbmq.Message, factoryMsg rbmq.FactoryMessage) error {
+	openOrders, err := s.StorageFor(msg.Context()).CountOpenOrdersFactory()
+	if err != nil {
+		s.Logger.Errorw("Failed to count open orders", "err", err)
+		return err
+	}
+
+	// the time of the request is sent back so that the delegation service knows which delegations the count can't contain yet
+	report := rbmq.FactoryMessage{
+		Timestamp:           time.Now().UTC(),
+		MsgType:             rbmq.TypeLoadReport,
+		Location:            s.Config.Location,
+		MaxConcurrentOrders: s.capacity,
+		OpenOrders:          openOrders,
+		RequestedAt:         factoryMsg.RequestedAt,
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
 
-	return orderMsg, nil
+	err = s.Producer[s.Topology.Headquarter].Publish(msg.Envelope.Follow(rbmq.TypeLoadReport), body, "delegation")
+	if err != nil {
+		s.Logger.Errorw("Failed to send load report to delegation service", "err", err)
+		return err
+	}
+
+	s.Logger.Infow("Sent load report to delegation service", "openOrders", openOrders)
+	return nil
 }
 
-// handleKPIRequest aggregates new kpi entries
-func (s *Service) handleKPIRequest() ([]byte, error) {
+// aggregateKPI aggregates new kpi entries
+func (s *Service) aggregateKPI(ctx context.Context) ([]byte, error) {
 	// fetch new kpi from the database
-	kpis, err := s.Storage.AggregateKPI()
+	kpis, err := s.StorageFor(ctx).AggregateKPI()
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +575,7 @@ func (s *Service) handleKPIRequest() ([]byte, error) {
 	// parse the kpi to an update message
 	msg := rbmq.KPIMessage{
 		Timestamp: time.Now().UTC(),
-		MsgType:   "kpiupdate",
+		MsgType:   rbmq.TypeKPIUpdate,
 		Location:  s.Config.Location,
 	}
 
@@ -254,6 +587,7 @@ func (s *Service) handleKPIRequest() ([]byte, error) {
 		msg.CompletedOrders = kpi.CompletedOrders
 		msg.Total = kpi.Total
 		msg.CostsOfParts = kpi.CostsOfParts
+		msg.Revenue = kpi.Revenue
 	}
 
 	// return the encoded message
@@ -261,7 +595,8 @@ func (s *Service) handleKPIRequest() ([]byte, error) {
 }
 
 // notifyLondon sends an update to london when an order is complete
-func (s *Service) notifyLondon(orderMsg rbmq.OrderMessage) {
+// The notification is sent again if a publish failed, the delegation and order service ignore duplicates
+func (s *Service) notifyLondon(envelope rbmq.Envelope, orderMsg rbmq.OrderMessage) error {
 	orderMsg.Timestamp = time.Now().UTC()
 	orderMsg.Status = "complete"
 	orderMsg.Location = s.Config.Location
@@ -269,15 +604,54 @@ func (s *Service) notifyLondon(orderMsg rbmq.OrderMessage) {
 	event, err := json.Marshal(orderMsg)
 	if err != nil {
 		s.Logger.Errorw("Failed to parse message", "err", err)
+		return err
 	}
 
-	err = s.Producer["london"].Publish(event, "delegation")
+	err = s.Producer[s.Topology.Headquarter].Publish(envelope, event, "delegation")
 	if err != nil {
-		s.Logger.Errorw("Failed to send message to assembly", "err", err)
+		s.Logger.Errorw("Failed to send message to delegation service", "err", err)
+		return err
+	}
+
+	err = s.Producer[s.Topology.Headquarter].Publish(envelope, event, "order")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to order service", "err", err)
+	}
+
+	return err
+}
+
+// announce registers the factory with the delegation service and sends a heartbeat every heartbeat interval
+// The registration is repeated until it was published successfully
+func (s *Service) announce() {
+	msgType := rbmq.TypeRegisterFactory
+
+	for {
+		err := s.sendAnnouncement(msgType)
+		if err != nil {
+			s.Logger.Errorw("Failed to announce factory to delegation service", "type", msgType, "err", err)
+		} else {
+			msgType = rbmq.TypeHeartbeat
+		}
+
+		// block until the next heartbeat is due
+		<-time.After(s.config.HeartbeatInterval)
 	}
+}
 
-	err = s.Producer["london"].Publish(event, "order")
+// sendAnnouncement publishes the location and capacity of the factory to the delegation service
+func (s *Service) sendAnnouncement(msgType string) error {
+	factoryMsg := rbmq.FactoryMessage{
+		Timestamp:           time.Now().UTC(),
+		MsgType:             msgType,
+		Location:            s.Config.Location,
+		MaxConcurrentOrders: s.capacity,
+	}
+
+	body, err := json.Marshal(factoryMsg)
 	if err != nil {
-		s.Logger.Errorw("Failed to send message to assembly", "err", err)
+		return err
 	}
+
+	return s.Producer[s.Topology.Headquarter].Publish(rbmq.NewEnvelope(msgType, s.Config.Location), body, "delegation")
 }
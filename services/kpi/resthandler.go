@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 	"github.com/go-chi/chi"
 )
 
@@ -13,15 +14,15 @@ func (s *Service) getKpi(w http.ResponseWriter, r *http.Request) {
 	location := chi.URLParam(r, "location")
 	s.Logger.Infow("Received request to fetch kpi", "location", location)
 
-	kpis, err := s.Storage.FindKPI(location)
+	kpis, err := s.StorageFor(r.Context()).FindKPI(location)
 	if err != nil {
-		s.handleAPIError("Failed to fetch kpis", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch kpis", err)
 		return
 	}
 
 	body, err := json.Marshal(kpis)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
@@ -33,29 +34,23 @@ func (s *Service) getKpis(w http.ResponseWriter, r *http.Request) {
 	location := chi.URLParam(r, "location")
 	n, err := strconv.Atoi(chi.URLParam(r, "n"))
 	if err != nil {
-		s.handleAPIError("Failed to parse to int", err, w)
+		s.HandleAPIError(w, r, "Failed to parse to int", service.NewAPIError(http.StatusBadRequest, service.CodeInvalidParameter, "The number of kpis must be a number"))
 		return
 	}
 
 	s.Logger.Infow("Received request to fetch kpi", "location", location, "amount", n)
 
-	kpis, err := s.Storage.FindLastNKPI(location, int64(n))
+	kpis, err := s.StorageFor(r.Context()).FindLastNKPI(location, int64(n))
 	if err != nil {
-		s.handleAPIError("Failed to fetch kpis", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch kpis", err)
 		return
 	}
 
 	body, err := json.Marshal(kpis)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
 	w.Write(body)
 }
-
-func (s *Service) handleAPIError(msg string, err error, w http.ResponseWriter) {
-	s.Logger.Errorw("msg", "err", err)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
-}
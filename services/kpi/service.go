@@ -1,12 +1,17 @@
 package kpi
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
 	"time"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
@@ -14,8 +19,6 @@ import (
 
 const requestInterval = 90
 
-var additionalProducers = [...]string{"china", "usa"}
-
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
@@ -32,24 +35,16 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	// add additional producers to send messages to the factories
-	for _, location := range additionalProducers {
-		producer, err := kpiService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
-
-		kpiService.Producer[location] = producer
-	}
-
 	// initialize the database
 	err = kpiService.InitStorage()
 	if err != nil {
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go kpiService.handleRbmqMessage(messages)
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	// this service only expects messages to be of type kpiupdate, everything else is rejected
+	kpiService.Router.Handle(rbmq.TypeKPIUpdate, rbmq.KPIHandler(kpiService.handleKPIUpdate))
+	go kpiService.Router.Run(messages)
 
 	// initialize a chi router and its handler functions
 	router := chi.NewRouter()
@@ -69,43 +64,28 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	return kpiService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
-		var kpiMsg rbmq.KPIMessage
-
-		// decode the msg body
-		err := json.Unmarshal(msg.Body, &kpiMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to unmarshal message", "err", err)
-			continue
-		}
-
-		// this service only expects messages to be of type kpiupdate, everything else is rejected
-		if kpiMsg.MsgType != "kpiupdate" {
-			s.Logger.Errorw("Unhandled message type", "type", kpiMsg.MsgType)
-			continue
-		}
-
-		s.Logger.Infow("Received kpi update", "location", kpiMsg.Location)
-
-		// create a new kpi entity based on the messsage
-		kpi := entities.KPI{
-			Created:          time.Now().UTC(),
-			Location:         kpiMsg.Location,
-			IncompleteOrders: kpiMsg.IncompleteOrders,
-			CompletedOrders:  kpiMsg.CompletedOrders,
-			Total:            kpiMsg.IncompleteOrders + kpiMsg.CompletedOrders,
-			CostsOfParts:     kpiMsg.CostsOfParts,
-		}
+// handleKPIUpdate stores a single kpi update, the returned error decides whether the message is retried
+func (s *Service) handleKPIUpdate(msg rbmq.Message, kpiMsg rbmq.KPIMessage) error {
+	s.Logger.Infow("Received kpi update", "location", kpiMsg.Location)
+
+	// create a new kpi entity based on the messsage
+	kpi := entities.KPI{
+		Created:          time.Now().UTC(),
+		Location:         kpiMsg.Location,
+		IncompleteOrders: kpiMsg.IncompleteOrders,
+		CompletedOrders:  kpiMsg.CompletedOrders,
+		Total:            kpiMsg.IncompleteOrders + kpiMsg.CompletedOrders,
+		CostsOfParts:     kpiMsg.CostsOfParts,
+		Revenue:          kpiMsg.Revenue,
+	}
 
-		// add the entity to the database
-		_, err = s.Storage.CreateKPI(kpi)
-		if err != nil {
-			s.Logger.Errorw("Failed to add kpi entry", "err", err)
-		}
+	// add the entity to the database
+	_, err := s.StorageFor(msg.Context()).CreateKPI(kpi)
+	if err != nil {
+		s.Logger.Errorw("Failed to add kpi entry", "err", err)
 	}
+
+	return err
 }
 
 // requestKPIs that sends kpi requests to each factory
@@ -114,7 +94,7 @@ func (s *Service) requestKPIs() {
 		// prepare a new message
 		kpiMsg := rbmq.KPIMessage{
 			Timestamp: time.Now().UTC(),
-			MsgType:   "requestkpi",
+			MsgType:   rbmq.TypeRequestKPI,
 		}
 
 		// encode the message
@@ -124,19 +104,85 @@ func (s *Service) requestKPIs() {
 			continue
 		}
 
-		// publish the message to china
-		err = s.Producer["china"].Publish(msg, "factory")
-		if err != nil {
-			s.Logger.Errorw("Failed to publish message", "location", "china", "err", err)
-		}
-
-		// publish the message to usa
-		err = s.Producer["usa"].Publish(msg, "factory")
-		if err != nil {
-			s.Logger.Errorw("Failed to publish message", "location", "usa", "err", err)
+		// all requests share the same correlation id
+		envelope := rbmq.NewEnvelope(rbmq.TypeRequestKPI, "")
+
+		// publish the message to every factory, the producers are created on first use since factories register at runtime
+		for _, location := range s.factoryLocations(context.Background()) {
+			producer, err := s.ProducerFor(location)
+			if err != nil {
+				s.Logger.Errorw("Failed to create producer", "location", location, "err", err)
+				continue
+			}
+
+			err = producer.Publish(envelope, msg, "factory")
+			if err != nil {
+				s.Logger.Errorw("Failed to publish message", "location", location, "err", err)
+			}
 		}
 
 		// block until the timer is over
 		<-time.After(requestInterval * time.Second)
 	}
 }
+
+// factoryLocations returns the sorted locations of the factories in the topology and of all factories
+// registered at the delegation service
+// If the delegation service can't be reached, only the factories in the topology are returned
+func (s *Service) factoryLocations(ctx context.Context) []string {
+	locations := make(map[string]bool)
+	for _, location := range s.Topology.FactoryLocations() {
+		locations[location] = true
+	}
+
+	registered, err := s.registeredFactories(ctx)
+	if err != nil {
+		s.Logger.Warnw("Failed to fetch registered factories, requesting kpis of the topology only", "err", err)
+	}
+	for _, location := range registered {
+		locations[location] = true
+	}
+
+	var sorted []string
+	for location := range locations {
+		sorted = append(sorted, location)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// registeredFactories requests the locations of all registered factories from the delegation service
+func (s *Service) registeredFactories(ctx context.Context) ([]string, error) {
+	delegationURL, err := s.Topology.ServiceURL("delegation")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := tracing.Get(ctx, delegationURL+"/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Delegation service responded with %s", resp.Status)
+	}
+
+	var factories []struct {
+		Location string `json:"location"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&factories)
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []string
+	for _, factory := range factories {
+		if factory.Location != "" {
+			locations = append(locations, factory.Location)
+		}
+	}
+
+	return locations, nil
+}
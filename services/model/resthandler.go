@@ -9,6 +9,7 @@ import (
 	"github.com/go-chi/chi"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 )
 
 // updatePrice updates the pricing based on the body of a post request
@@ -16,27 +17,27 @@ func (s *Service) updatePrice(w http.ResponseWriter, r *http.Request) {
 	var part entities.Part
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		s.handleAPIError("Failed to read request body", err, w)
+		s.HandleAPIError(w, r, "Failed to read request body", err)
 		return
 	}
 
 	err = json.Unmarshal(body, &part)
 	if err != nil {
-		s.handleAPIError("Failed to parse message", err, w)
+		s.HandleAPIError(w, r, "Failed to parse message", service.InvalidBody(err))
 		return
 	}
 
 	s.Logger.Infow("Received request to update part", "part", part.ID)
 
-	response, err := s.updatePriceDB(w, part)
+	response, err := s.updatePriceDB(r.Context(), w, part)
 	if err != nil {
-		s.handleAPIError("Failed to parse message", err, w)
+		s.HandleAPIError(w, r, "Failed to update part", err)
 		return
 	}
 
-	err = s.notifyPartService(part)
+	err = s.notifyPartService(r.Context(), part)
 	if err != nil {
-		s.handleAPIError("Failed to parse message", err, w)
+		s.HandleAPIError(w, r, "Failed to notify part services", err)
 		return
 	}
 
@@ -49,21 +50,22 @@ func (s *Service) getModel(w http.ResponseWriter, r *http.Request) {
 
 	s.Logger.Infow("Received request to fetch model", "model", id)
 
+	// model ids are numbers, so other ids can't exist
 	modelID, err := strconv.Atoi(id)
 	if err != nil {
-		s.handleAPIError("Failed to find order", err, w)
+		s.HandleAPIError(w, r, "Failed to find model", service.NewAPIError(http.StatusNotFound, service.CodeNotFound, "Failed to find model"))
 		return
 	}
 
-	model, err := s.Storage.FindModel(modelID)
+	model, err := s.StorageFor(r.Context()).FindModel(modelID)
 	if err != nil {
-		s.handleAPIError("Failed to find order", err, w)
+		s.HandleAPIError(w, r, "Failed to find model", err)
 		return
 	}
 
 	body, err := json.Marshal(model)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
@@ -74,23 +76,17 @@ func (s *Service) getModel(w http.ResponseWriter, r *http.Request) {
 func (s *Service) getAllModels(w http.ResponseWriter, r *http.Request) {
 	s.Logger.Info("Received request to fetch all models")
 
-	models, err := s.Storage.AllModels()
+	models, err := s.StorageFor(r.Context()).AllModels()
 	if err != nil {
-		s.handleAPIError("Failed to fetch orders", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch models", err)
 		return
 	}
 
 	body, err := json.Marshal(models)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
 	w.Write(body)
 }
-
-func (s *Service) handleAPIError(msg string, err error, w http.ResponseWriter) {
-	s.Logger.Errorw("msg", "err", err)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
-}
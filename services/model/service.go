@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -15,8 +16,6 @@ import (
 	"github.com/go-chi/chi/middleware"
 )
 
-var additionalProducers = [...]string{"china", "usa"}
-
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
@@ -34,13 +33,9 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	}
 
 	// add additional producers to send messages to the factories
-	for _, location := range additionalProducers {
-		producer, err := modelService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
-
-		modelService.Producer[location] = producer
+	err = modelService.AddProducers(modelService.Topology.FactoryLocations()...)
+	if err != nil {
+		return nil, err
 	}
 
 	// initialize the database
@@ -55,6 +50,9 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
+	// launch a new thread that rejects incoming rabbitmq messages, this service only sends messages
+	go modelService.Router.Run(messages)
+
 	// initialize a chi router and its handler functions
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
@@ -70,9 +68,9 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 }
 
 // updatePriceDB updates the price of a single part in the database and terminates the http request
-func (s *Service) updatePriceDB(w http.ResponseWriter, part entities.Part) ([]byte, error) {
+func (s *Service) updatePriceDB(ctx context.Context, w http.ResponseWriter, part entities.Part) ([]byte, error) {
 
-	err := s.Storage.UpdateModelPart(part)
+	err := s.StorageFor(ctx).UpdateModelPart(part)
 	if err != nil {
 		s.Logger.Errorw("Failed to update part price", "err", err)
 		return nil, err
@@ -88,11 +86,11 @@ func (s *Service) updatePriceDB(w http.ResponseWriter, part entities.Part) ([]by
 }
 
 // notifyPartService sends a message to each factory to update their local pricing services
-func (s *Service) notifyPartService(part entities.Part) error {
+func (s *Service) notifyPartService(ctx context.Context, part entities.Part) error {
 
 	partMsg := &rbmq.PartMessage{
 		Timestamp: time.Now().UTC(),
-		MsgType:   "updatepart",
+		MsgType:   rbmq.TypeUpdatePart,
 		Part:      part.ID,
 		Price:     part.Price,
 	}
@@ -102,13 +100,13 @@ func (s *Service) notifyPartService(part entities.Part) error {
 		return err
 	}
 
-	err = s.Producer["usa"].Publish(notification, "part")
-	if err != nil {
-		return err
-	}
-	err = s.Producer["china"].Publish(notification, "part")
-	if err != nil {
-		return err
+	envelope := rbmq.NewEnvelope(rbmq.TypeUpdatePart, "").WithTrace(ctx)
+
+	for _, location := range s.Topology.FactoryLocations() {
+		err = s.Producer[location].Publish(envelope, notification, "part")
+		if err != nil {
+			return err
+		}
 	}
 	s.Logger.Infow("Sent part updates to factories", "part", part.ID)
 	return nil
@@ -0,0 +1,151 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
+)
+
+// errOrderFinished is returned when a finished order is cancelled
+var errOrderFinished = errors.New("The order is already finished")
+
+// cancel requests the cancellation of an order through the delegation service
+// The order keeps the status cancelling until its factory reports whether it stopped the order in time
+func (s *Service) cancel(ctx context.Context, id string) (entities.Order, error) {
+	var order entities.Order
+	err := retryOnConflict(id, func() (bool, error) {
+		var done bool
+		var err error
+		order, done, err = s.requestCancellation(ctx, id)
+		return done, err
+	})
+	return order, err
+}
+
+// requestCancellation marks the current copy of an order as cancelling and forwards the cancellation
+// It returns false if the order changed before the status was stored
+func (s *Service) requestCancellation(ctx context.Context, id string) (entities.Order, bool, error) {
+	order, err := s.StorageFor(ctx).FindOrder(id)
+	if err != nil {
+		return order, false, err
+	}
+
+	// the cancellation was already requested
+	if order.Status == StatusCancelling {
+		return order, true, nil
+	}
+
+	if !canTransition(order.Status, StatusCancelling) {
+		return order, false, errOrderFinished
+	}
+
+	previous := order
+	now := time.Now().UTC()
+	order.Status = StatusCancelling
+	order.LastUpdate = now
+	order.Deadline = s.deadline(StatusCancelling, now)
+
+	// the status is stored before the cancellation is forwarded, so the order can't have moved on in the meantime
+	updated, err := s.StorageFor(ctx).UpdateOrderStatus(order, previous.Status)
+	if err != nil || !updated {
+		return previous, false, err
+	}
+
+	orderMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelOrder,
+		OrderID:   order.ObjectID,
+		Customer:  order.Customer,
+		Location:  order.Location,
+	}
+
+	body, err := json.Marshal(orderMsg)
+	if err == nil {
+		err = s.Producer[s.Topology.Headquarter].Publish(rbmq.NewEnvelope(rbmq.TypeCancelOrder, order.ObjectID).WithTrace(ctx), body, "delegation")
+	}
+	if err != nil {
+		s.restoreStatus(ctx, previous, StatusCancelling)
+		return previous, false, err
+	}
+
+	s.Logger.Infow("Requested cancellation of order", "order", order.ObjectID, "status", previous.Status)
+
+	s.recordStatus(ctx, order, "The customer cancelled the order")
+	return order, true, nil
+}
+
+// handleCancelResult records the outcome of a cancellation
+// Part and assembly services report whether they stopped their work, which is only added to the history,
+// the final status is decided by the factory or by the delegation service if the order never reached a factory
+func (s *Service) handleCancelResult(msg rbmq.Message, result rbmq.OrderMessage) error {
+	s.Logger.Infow("Received cancellation result", "order", result.OrderID, "stage", result.Stage, "cancelled", result.Cancelled)
+
+	return retryOnConflict(result.OrderID, func() (bool, error) {
+		return s.applyCancelResult(msg, result)
+	})
+}
+
+// applyCancelResult applies the outcome of a cancellation to the current copy of the order
+// It returns false if the order changed before the outcome was stored
+func (s *Service) applyCancelResult(msg rbmq.Message, result rbmq.OrderMessage) (bool, error) {
+	order, err := s.StorageFor(msg.Context()).FindOrder(result.OrderID)
+	if err != nil {
+		return false, err
+	}
+
+	outcome := "Too late: " + result.Reason
+	if result.Cancelled {
+		outcome = "Cancelled: " + result.Reason
+	}
+
+	if result.Stage != "factory" && result.Stage != "delegation" {
+		s.recordStatusFrom(msg.Context(), entities.Order{
+			ObjectID:   order.ObjectID,
+			Customer:   order.Customer,
+			Status:     order.Status,
+			LastUpdate: time.Now().UTC(),
+			Location:   result.Location,
+		}, result.Stage, outcome)
+		return true, nil
+	}
+
+	// the order may have been shipped or aborted in the meantime
+	if order.Status != StatusCancelling {
+		s.Logger.Infow("Ignoring cancellation result of order that isn't cancelling", "order", order.ObjectID, "status", order.Status)
+		return true, nil
+	}
+
+	status := StatusCancelled
+	if !result.Cancelled {
+		// the factory reports the step the order reached, it continues from there
+		if step(result.Status) == -1 {
+			return false, rbmq.Permanent(fmt.Errorf("Invalid status %q in cancellation result of order %s", result.Status, order.ObjectID))
+		}
+		status = result.Status
+	}
+
+	now := time.Now().UTC()
+	order.Status = status
+	order.LastUpdate = now
+	order.Deadline = s.deadline(status, now)
+	if result.Location != "" {
+		order.Location = result.Location
+	}
+	if result.Cancelled {
+		order.Reason = result.Reason
+	}
+
+	updated, err := s.StorageFor(msg.Context()).UpdateOrderStatus(order, StatusCancelling)
+	if err != nil || !updated {
+		return false, err
+	}
+
+	s.recordStatusFrom(msg.Context(), order, result.Stage, outcome)
+	s.notify(msg.Context(), order)
+	return true, nil
+}
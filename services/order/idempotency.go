@@ -0,0 +1,101 @@
+package order
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+)
+
+const (
+	// idempotencyHeader is the header a client uses to mark requests that may be sent more than once
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader is set on responses that were repeated for a request with a known idempotency key
+	replayedHeader = "Idempotent-Replayed"
+	// reserveAttempts is how often the service tries to reserve a key that is released by other requests
+	reserveAttempts = 3
+)
+
+var (
+	// errKeyReused is returned if a client sends an idempotency key again with a different request body
+	errKeyReused = service.ValidationFailed(service.FieldError{
+		Field:   idempotencyHeader,
+		Message: "was already used for a different request",
+	})
+	// errKeyPending is returned if the first request with an idempotency key is still being processed
+	errKeyPending = service.NewAPIError(http.StatusConflict, service.CodeConflict,
+		"A request with the same idempotency key is still being processed")
+)
+
+// reserveIdempotencyKey stores an idempotency key before the request that sent it is processed
+// It returns true if the request has to be processed, otherwise the returned key contains the response
+// to the first request with the key
+func (s *Service) reserveIdempotencyKey(ctx context.Context, key string, body []byte) (entities.IdempotencyKey, bool, error) {
+	hash := sha256.Sum256(body)
+	now := time.Now().UTC()
+
+	record := entities.IdempotencyKey{
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash[:]),
+		Created:     now,
+	}
+
+	// keys older than the retention window are replaced, so the client can use them for a new request,
+	// pending keys are replaced after their lease, so a crashed request doesn't block the key
+	expired := now.Add(-s.config.IdempotencyRetention)
+	abandoned := now.Add(-s.config.IdempotencyLease)
+
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		reserved, err := s.StorageFor(ctx).ReserveIdempotencyKey(record, expired, abandoned)
+		if err != nil || reserved {
+			return record, reserved, err
+		}
+
+		stored, err := s.StorageFor(ctx).FindIdempotencyKey(key)
+		if db.IsNotFound(err) {
+			// the first request failed and released the key in the meantime
+			continue
+		}
+		if err != nil {
+			return record, false, err
+		}
+
+		if stored.RequestHash != record.RequestHash {
+			return stored, false, errKeyReused
+		}
+		if stored.StatusCode == 0 {
+			return stored, false, errKeyPending
+		}
+
+		return stored, false, nil
+	}
+
+	// other requests with the same key keep reserving and releasing it
+	return record, false, errKeyPending
+}
+
+// completeIdempotencyKey stores the response to the request that sent an idempotency key
+// The order is already created at this point, so a failure is only logged
+func (s *Service) completeIdempotencyKey(ctx context.Context, record entities.IdempotencyKey, order entities.Order, statusCode int, response []byte) {
+	record.OrderID = order.ObjectID
+	record.StatusCode = statusCode
+	record.Response = response
+
+	err := s.StorageFor(ctx).CompleteIdempotencyKey(record)
+	if err != nil {
+		s.Logger.Errorw("Failed to store response of idempotency key", "key", record.Key, "order", order.ObjectID, "err", err)
+	}
+}
+
+// releaseIdempotencyKey removes the idempotency key of a failed request, so the client can send the request again
+func (s *Service) releaseIdempotencyKey(ctx context.Context, key string) {
+	err := s.StorageFor(ctx).DeleteIdempotencyKey(key)
+	if err != nil {
+		s.Logger.Errorw("Failed to release idempotency key", "key", key, "err", err)
+	}
+}
@@ -2,26 +2,82 @@ package order
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 	"github.com/go-chi/chi"
 )
 
+// historyEntry is a status change of an order together with the time the order spent in that status
+type historyEntry struct {
+	entities.StatusChange
+	// Duration lasts until the next status change, the current status of an unfinished order lasts until now
+	Duration        string  `json:"duration,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// historyResponse is the timeline of an order
+type historyResponse struct {
+	OrderID string    `json:"orderID"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+	// Total is the time from the creation of the order until it finished or until now
+	Total        string         `json:"total"`
+	TotalSeconds float64        `json:"totalSeconds"`
+	History      []historyEntry `json:"history"`
+}
+
 // postOrder is the rest handler to create a new order
 func (s *Service) postOrder(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		s.handleAPIError("Failed to read request body", err, w)
+		s.HandleAPIError(w, r, "Failed to read request body", err)
+		return
+	}
+
+	// requests with an idempotency key are only processed once, repeated requests get the original response
+	key := r.Header.Get(idempotencyHeader)
+	var record entities.IdempotencyKey
+	if key != "" {
+		var reserved bool
+		record, reserved, err = s.reserveIdempotencyKey(r.Context(), key, body)
+		if err != nil {
+			s.HandleAPIError(w, r, "Failed to check idempotency key", err)
+			return
+		}
+		if !reserved {
+			s.Logger.Infow("Repeating response of idempotent request", "key", key, "order", record.OrderID)
+			w.Header().Set(replayedHeader, "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Response)
+			return
+		}
+	}
+
+	order, err := s.prepareOrder(r.Context(), body, key)
+	if err != nil {
+		if key != "" {
+			s.releaseIdempotencyKey(r.Context(), key)
+		}
+		s.HandleAPIError(w, r, "Failed to create order", err)
 		return
 	}
 
-	response, err := s.prepareOrder(body)
+	response, err := json.Marshal(order)
 	if err != nil {
-		s.handleAPIError("Failed to create order", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
+	if key != "" {
+		s.completeIdempotencyKey(r.Context(), record, order, http.StatusOK, response)
+	}
+
 	w.Write(response)
 }
 
@@ -31,42 +87,200 @@ func (s *Service) getOrder(w http.ResponseWriter, r *http.Request) {
 
 	s.Logger.Info("Received request to fetch order", "order", id)
 
-	order, err := s.Storage.FindOrder(id)
+	order, err := s.StorageFor(r.Context()).FindOrder(id)
 	if err != nil {
-		s.handleAPIError("Failed to find order", err, w)
+		s.HandleAPIError(w, r, "Failed to find order", err)
 		return
 	}
 
 	body, err := json.Marshal(order)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
 	w.Write(body)
 }
 
-// getAllOrders is the rest handler to return a list of all orders
+// getAllOrders is the rest handler to return a page of all orders matching the query parameters
 func (s *Service) getAllOrders(w http.ResponseWriter, r *http.Request) {
-	s.Logger.Info("Received request to fetch all orders")
+	q, err := parseOrderQuery(r)
+	if err != nil {
+		s.HandleAPIError(w, r, "Invalid order query", err)
+		return
+	}
 
-	orders, err := s.Storage.AllOrders()
+	s.Logger.Infow("Received request to fetch orders", "customer", q.Customer, "status", q.Status, "limit", q.Page.Limit,
+		"offset", q.Page.Offset)
+
+	orders, total, err := s.StorageFor(r.Context()).FindOrders(q)
 	if err != nil {
-		s.handleAPIError("Failed to fetch orders", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch orders", err)
 		return
 	}
 
+	if orders == nil {
+		orders = []entities.Order{}
+	}
+
 	body, err := json.Marshal(orders)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
+	service.WritePage(w, r, q.Page, total)
 	w.Write(body)
 }
 
-func (s *Service) handleAPIError(msg string, err error, w http.ResponseWriter) {
-	s.Logger.Errorw("msg", "err", err)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
+// parseOrderQuery reads the filters, the sort order and the page of an order list request
+// By default the newest orders are returned first
+func parseOrderQuery(r *http.Request) (query.Orders, error) {
+	var err error
+	q := query.Orders{
+		Customer: r.URL.Query().Get("customer"),
+		Status:   service.ParseList(r, "status"),
+	}
+
+	q.Created, err = service.ParseTimeRange(r, "created")
+	if err != nil {
+		return q, err
+	}
+
+	q.Updated, err = service.ParseTimeRange(r, "updated")
+	if err != nil {
+		return q, err
+	}
+
+	q.Sort, err = service.ParseSort(r, query.Sort{Field: query.SortCreated, Descending: true}, query.SortCreated, query.SortLastUpdate)
+	if err != nil {
+		return q, err
+	}
+
+	q.Page, err = service.ParsePage(r)
+	return q, err
+}
+
+// deleteOrder is the rest handler to cancel an order
+// The cancellation is processed asynchronously, the response contains the order with the status cancelling
+func (s *Service) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	s.Logger.Infow("Received request to cancel order", "order", id)
+
+	order, err := s.cancel(r.Context(), id)
+	if err == errOrderFinished {
+		err = service.NewAPIError(http.StatusConflict, service.CodeConflict, fmt.Sprintf("Order is already %s", order.Status))
+		s.HandleAPIError(w, r, "Order can't be cancelled anymore", err)
+		return
+	}
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to cancel order", err)
+		return
+	}
+
+	body, err := json.Marshal(order)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(body)
+}
+
+// getOrderHistory is the rest handler to return the timeline of an order with the time spent in each status
+func (s *Service) getOrderHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	s.Logger.Infow("Received request to fetch order history", "order", id)
+
+	order, err := s.StorageFor(r.Context()).FindOrder(id)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to find order", err)
+		return
+	}
+
+	history, err := s.StorageFor(r.Context()).OrderHistory(id)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to fetch order history", err)
+		return
+	}
+
+	body, err := json.Marshal(timeline(order, history, time.Now().UTC()))
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// timeline calculates how long an order spent in each status of its history
+func timeline(order entities.Order, history []entities.StatusChange, now time.Time) historyResponse {
+	response := historyResponse{
+		OrderID: order.ObjectID,
+		Status:  order.Status,
+		Created: order.Created,
+		History: make([]historyEntry, 0, len(history)),
+	}
+
+	end := now
+	if isFinished(order.Status) && len(history) > 0 {
+		end = history[len(history)-1].Timestamp
+	}
+
+	for i, change := range history {
+		entry := historyEntry{StatusChange: change}
+
+		var duration time.Duration
+		if i+1 < len(history) {
+			duration = history[i+1].Timestamp.Sub(change.Timestamp)
+		} else if !isFinished(order.Status) {
+			duration = now.Sub(change.Timestamp)
+		}
+
+		if duration > 0 {
+			entry.Duration = duration.String()
+			entry.DurationSeconds = duration.Seconds()
+		}
+
+		response.History = append(response.History, entry)
+	}
+
+	total := end.Sub(order.Created)
+	response.Total = total.String()
+	response.TotalSeconds = total.Seconds()
+
+	return response
+}
+
+// getStuckOrders is the rest handler to return a page of the orders whose current step missed its deadline
+func (s *Service) getStuckOrders(w http.ResponseWriter, r *http.Request) {
+	page, err := service.ParsePage(r)
+	if err != nil {
+		s.HandleAPIError(w, r, "Invalid page", err)
+		return
+	}
+
+	s.Logger.Infow("Received request to fetch stuck orders", "limit", page.Limit, "offset", page.Offset)
+
+	orders, err := s.StorageFor(r.Context()).OverdueOrders(time.Now().UTC())
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to fetch stuck orders", err)
+		return
+	}
+
+	// only few orders are stuck at once, so the page is selected here instead of in every storage backend
+	start, end := page.Bounds(len(orders))
+	stuck := append([]entities.Order{}, orders[start:end]...)
+
+	body, err := json.Marshal(stuck)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
+		return
+	}
+
+	service.WritePage(w, r, page, len(orders))
+	w.Write(body)
 }
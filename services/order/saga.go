@@ -0,0 +1,255 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
+)
+
+const (
+	// StatusProcessing is the status of a new order until a factory accepts it
+	StatusProcessing = "processing"
+	// StatusProduction is the status of an order whose factory is waiting for its parts
+	StatusProduction = "production"
+	// StatusPartsDelivered is the status of an order whose parts were delivered and that is being assembled
+	StatusPartsDelivered = "partsdelivered"
+	// StatusAssembled is the status of an order that was assembled and is being shipped
+	StatusAssembled = "assembled"
+	// StatusComplete is the status of an order that was shipped to the customer
+	StatusComplete = "complete"
+	// StatusFailed is the status of an order that couldn't be finished
+	StatusFailed = "failed"
+	// StatusCancelling is the status of a cancelled order until its factory reports whether it stopped the order
+	StatusCancelling = "cancelling"
+	// StatusCancelled is the status of an order that was stopped after the customer cancelled it
+	StatusCancelled = "cancelled"
+)
+
+// SagaConfig contains the deadlines of the steps of an order, a step without deadline never times out
+type SagaConfig struct {
+	AcceptTimeout   time.Duration
+	PartsTimeout    time.Duration
+	AssemblyTimeout time.Duration
+	ShippingTimeout time.Duration
+	// CancelTimeout is how long the factory of a cancelled order may take to confirm the cancellation
+	CancelTimeout time.Duration
+	// MaxRedelegations is how often an order that wasn't accepted in time is delegated again before it fails
+	MaxRedelegations int
+	// CheckInterval is how often the service looks for orders that missed a deadline
+	CheckInterval time.Duration
+}
+
+// lifecycle contains the steps of an order in the order they are passed
+// An order may skip steps since the updates of different steps can overtake each other,
+// but it can never go back to an earlier step
+var lifecycle = []string{StatusProcessing, StatusProduction, StatusPartsDelivered, StatusAssembled, StatusComplete}
+
+// step returns the position of a status in the lifecycle, -1 is returned for unknown statuses
+func step(status string) int {
+	for i, lifecycleStatus := range lifecycle {
+		if lifecycleStatus == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// isFinished checks whether an order can't change its status anymore
+func isFinished(status string) bool {
+	return status == StatusComplete || status == StatusFailed || status == StatusCancelled
+}
+
+// canTransition checks whether an order may change from one status to another
+// A cancelling order keeps its status until the cancellation is confirmed, it can only fail or be shipped
+// if the cancellation came too late
+func canTransition(from string, to string) bool {
+	if isFinished(from) {
+		return false
+	}
+
+	switch to {
+	case StatusFailed, StatusCancelled:
+		return true
+	case StatusCancelling:
+		return from != StatusCancelling
+	}
+
+	if from == StatusCancelling {
+		return to == StatusComplete
+	}
+	return step(from) != -1 && step(to) > step(from)
+}
+
+// timeout returns how long an order may stay in a status, finished orders and steps without deadline return zero
+func (s *Service) timeout(status string) time.Duration {
+	switch status {
+	case StatusProcessing:
+		return s.config.Saga.AcceptTimeout
+	case StatusProduction:
+		return s.config.Saga.PartsTimeout
+	case StatusPartsDelivered:
+		return s.config.Saga.AssemblyTimeout
+	case StatusAssembled:
+		return s.config.Saga.ShippingTimeout
+	case StatusCancelling:
+		return s.config.Saga.CancelTimeout
+	default:
+		return 0
+	}
+}
+
+// deadline returns the time an order that enters a status at the given time has to leave it
+func (s *Service) deadline(status string, now time.Time) time.Time {
+	timeout := s.timeout(status)
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
+
+// watchDeadlines periodically compensates all orders whose current step missed its deadline
+// Orders whose compensation failed stay overdue and are compensated again in the next run
+func (s *Service) watchDeadlines() {
+	for {
+		<-time.After(s.config.Saga.CheckInterval)
+
+		orders, err := s.Storage.OverdueOrders(time.Now().UTC())
+		if err != nil {
+			s.Logger.Errorw("Failed to fetch overdue orders", "err", err)
+			continue
+		}
+
+		for _, order := range orders {
+			err := s.compensate(context.Background(), order)
+			if err != nil {
+				s.Logger.Errorw("Failed to compensate overdue order", "order", order.ObjectID, "status", order.Status, "err", err)
+			}
+		}
+	}
+}
+
+// compensate handles an order that missed the deadline of its current step
+// An order that no factory accepted is delegated to another factory until it was redelegated too often,
+// all other orders are aborted so that their factory stops working on them and releases their parts
+func (s *Service) compensate(ctx context.Context, order entities.Order) error {
+	if order.Status == StatusProcessing && order.Redelegations < s.config.Saga.MaxRedelegations {
+		metrics.OrderTimeouts.WithLabelValues(order.Status, "redelegate").Inc()
+		return s.redelegate(ctx, order)
+	}
+
+	var reason string
+	switch order.Status {
+	case StatusProcessing:
+		reason = fmt.Sprintf("No factory accepted the order within %s", s.timeout(order.Status))
+	case StatusProduction:
+		reason = fmt.Sprintf("The parts weren't delivered within %s", s.timeout(order.Status))
+	case StatusPartsDelivered:
+		reason = fmt.Sprintf("The assembly didn't finish within %s", s.timeout(order.Status))
+	case StatusCancelling:
+		reason = fmt.Sprintf("The cancellation wasn't confirmed within %s", s.timeout(order.Status))
+	default:
+		reason = fmt.Sprintf("The order wasn't shipped within %s", s.timeout(order.Status))
+	}
+
+	metrics.OrderTimeouts.WithLabelValues(order.Status, "abort").Inc()
+	return s.abort(ctx, order, reason)
+}
+
+// redelegate asks the delegation service to take an order from its factory and delegate it to another one
+func (s *Service) redelegate(ctx context.Context, order entities.Order) error {
+	// the delegation service needs the parts and assembly times of all items to choose a factory,
+	// the prices stay the ones from the time the order was created
+	items, err := s.fetchModelAndParts(ctx, orderLineItems(order))
+	if err != nil {
+		return err
+	}
+
+	previous := order
+	now := time.Now().UTC()
+	order.Redelegations++
+	order.LastUpdate = now
+	order.Deadline = s.deadline(order.Status, now)
+
+	// the order is only redelegated if no factory accepted it since it was found overdue
+	updated, err := s.StorageFor(ctx).UpdateOrderStatus(order, previous.Status)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		s.Logger.Infow("Order changed before it was redelegated", "order", order.ObjectID)
+		return nil
+	}
+
+	orderMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		OrderID:   order.ObjectID,
+		Customer:  order.Customer,
+		MsgType:   rbmq.TypeRedelegate,
+		Items:     items,
+	}
+
+	body, err := json.Marshal(orderMsg)
+	if err == nil {
+		err = s.Producer[s.Topology.Headquarter].Publish(rbmq.NewEnvelope(rbmq.TypeRedelegate, order.ObjectID).WithTrace(ctx), body, "delegation")
+	}
+	if err != nil {
+		// the order stays overdue and is redelegated in the next run
+		s.restoreStatus(ctx, previous, order.Status)
+		return err
+	}
+
+	s.Logger.Warnw("Order wasn't accepted in time, delegating it again", "order", order.ObjectID, "redelegations", order.Redelegations)
+
+	s.recordStatus(ctx, order, fmt.Sprintf("No factory accepted the order within %s, delegated it again", s.timeout(order.Status)))
+	return nil
+}
+
+// abort asks the delegation service to stop the production of an order and marks the order as failed
+func (s *Service) abort(ctx context.Context, order entities.Order, reason string) error {
+	previous := order
+	order.Status = StatusFailed
+	order.Reason = reason
+	order.LastUpdate = time.Now().UTC()
+	order.Deadline = time.Time{}
+
+	// the order is only aborted if it didn't reach the next step since it was found overdue
+	updated, err := s.StorageFor(ctx).UpdateOrderStatus(order, previous.Status)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		s.Logger.Infow("Order changed before it was aborted", "order", order.ObjectID, "status", previous.Status)
+		return nil
+	}
+
+	orderMsg := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		OrderID:   order.ObjectID,
+		Customer:  order.Customer,
+		MsgType:   rbmq.TypeAbortOrder,
+		Location:  order.Location,
+		Status:    previous.Status,
+		Reason:    reason,
+	}
+
+	body, err := json.Marshal(orderMsg)
+	if err == nil {
+		err = s.Producer[s.Topology.Headquarter].Publish(rbmq.NewEnvelope(rbmq.TypeAbortOrder, order.ObjectID).WithTrace(ctx), body, "delegation")
+	}
+	if err != nil {
+		// the order stays overdue and is aborted in the next run
+		s.restoreStatus(ctx, previous, StatusFailed)
+		return err
+	}
+
+	s.Logger.Warnw("Aborted order", "order", order.ObjectID, "status", previous.Status, "reason", reason)
+
+	s.recordStatus(ctx, order, reason)
+	s.notify(ctx, order)
+	return nil
+}
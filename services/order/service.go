@@ -1,8 +1,8 @@
 package order
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,42 +11,69 @@ import (
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/webhook"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 )
 
-const (
-	customerServiceURL = "http://customer-service:8080"
-	modelServiceURL    = "http://model-service:8080"
-)
-
-var additionalProducers = [...]string{"china", "usa"}
+// updateAttempts is how often an update is applied to a fresh copy of an order that changed concurrently
+const updateAttempts = 3
+
+// Config contains the settings of the order service
+type Config struct {
+	// IdempotencyRetention is how long the service repeats the response to a request with an Idempotency-Key
+	IdempotencyRetention time.Duration
+	// IdempotencyLease is how long a request may process an Idempotency-Key before another request can take it over
+	IdempotencyLease time.Duration
+	// Saga contains the deadlines the service enforces for each step of an order
+	Saga SagaConfig
+	// Webhook contains the retry policy of the webhooks
+	Webhook webhook.Config
+}
 
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
+
+	config Config
+
+	customerURL string
+	modelURL    string
+
+	// streams pushes the recorded status changes to the clients of the stream endpoints
+	streams *broker
+	// webhooks notifies external systems once an order is finished
+	webhooks *webhook.Dispatcher
 }
 
 // New launches a new custom service based on the service library in /pkg/service
-func New(config *service.Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
+func New(config *service.Config, orderConfig Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
 	var err error
 
 	// initialize a new service instance based on the config
-	orderService := &Service{}
+	orderService := &Service{config: orderConfig, streams: newBroker()}
 	orderService.Service, err = service.New(config, messages, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	// add additional producers to send messages to the factories
-	for _, location := range additionalProducers {
-		producer, err := orderService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
+	// resolve the rest apis used to validate new orders
+	orderService.customerURL, err = orderService.Topology.ServiceURL("customer")
+	if err != nil {
+		return nil, err
+	}
+
+	orderService.modelURL, err = orderService.Topology.ServiceURL("model")
+	if err != nil {
+		return nil, err
+	}
 
-		orderService.Producer[location] = producer
+	// add additional producers to send messages to the factories
+	err = orderService.AddProducers(orderService.Topology.FactoryLocations()...)
+	if err != nil {
+		return nil, err
 	}
 
 	// add additional producers to send messages to the factories
@@ -55,8 +82,14 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go orderService.handleRbmqMessage(messages)
+	// webhooks can subscribe to the end of an order
+	orderService.webhooks = webhook.New(orderService.Service, orderConfig.Webhook, EventOrderShipped, EventOrderFailed, EventOrderCancelled)
+	go orderService.webhooks.Run()
+
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	orderService.Router.Handle(rbmq.TypeOrderUpdate, rbmq.OrderHandler(orderService.handleOrderUpdate))
+	orderService.Router.Handle(rbmq.TypeCancelResult, rbmq.OrderHandler(orderService.handleCancelResult))
+	go orderService.Router.Run(messages)
 
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
@@ -65,100 +98,128 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	// initialize a chi router and its handler functions
 	router.Post("/", orderService.postOrder)
 	router.Get("/", orderService.getAllOrders)
+	router.Get("/stuck", orderService.getStuckOrders)
+	router.Get("/stream", orderService.getCustomerStream)
 	router.Get("/{id}", orderService.getOrder)
+	router.Get("/{id}/history", orderService.getOrderHistory)
+	router.Get("/{id}/stream", orderService.getOrderStream)
+	router.Delete("/{id}", orderService.deleteOrder)
+	router.Post("/{id}/cancel", orderService.deleteOrder)
+	orderService.webhooks.Mount(router)
 
 	go orderService.InitAPI(router)
 
+	// launch a new thread that compensates orders whose steps miss their deadlines
+	go orderService.watchDeadlines()
+
 	return orderService, nil
 }
 
-// handleRbmqMessage handles incoming messages, this service only expects order updates to be send to it by rabbitmq
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
-		orderMsg := rbmq.OrderMessage{}
+// handleOrderUpdate handles order updates, this service only expects order updates to be send to it by rabbitmq
+func (s *Service) handleOrderUpdate(msg rbmq.Message, orderMsg rbmq.OrderMessage) error {
+	s.Logger.Infow("Order update received", "order", orderMsg.OrderID, "status", orderMsg.Status)
 
-		// decode the message
-		err := json.Unmarshal(msg.Body, &orderMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
-		}
-
-		// reject if wrong message type
-		if orderMsg.MsgType != "orderupdate" {
-			s.Logger.Errorw("Unhandled message type", "type", orderMsg.MsgType)
-			continue
-		}
-
-		s.Logger.Infow("Order update received", "order", orderMsg.OrderID, "status", orderMsg.Status)
-
-		// update the order status
-		s.updateOrder(orderMsg)
-	}
+	// update the order status, the service that published the update is recorded as source of the status change
+	return s.updateOrder(msg.Context(), orderMsg, msg.Envelope.Service)
 }
 
 // prepareOrder prepares and creates an order based on a http request body
-func (s *Service) prepareOrder(body []byte) ([]byte, error) {
-	// initialize the entity
+// The idempotency key of the request is stored with the order, it is empty if the client didn't send one
+func (s *Service) prepareOrder(ctx context.Context, body []byte, idempotencyKey string) (entities.Order, error) {
+	// initialize the entity, a factory has to accept the order before the first deadline
+	now := time.Now().UTC()
 	order := entities.Order{
-		Created: time.Now().UTC(),
-		Status:  "processing",
+		Created:    now,
+		LastUpdate: now,
+		Status:     StatusProcessing,
+		Deadline:   s.deadline(StatusProcessing, now),
 	}
 
 	// decode the body into the entity
 	err := json.Unmarshal(body, &order)
 	if err != nil {
-		return nil, err
+		return entities.Order{}, service.InvalidBody(err)
+	}
+	order.IdempotencyKey = idempotencyKey
+
+	// check the fields before the other services are asked
+	if fields := validateOrder(order); len(fields) > 0 {
+		return entities.Order{}, service.ValidationFailed(fields...)
 	}
 
+	s.Logger.Infow("Received request to create new order", "customer", order.Customer)
+
 	// check if the customer exists
-	if !s.customerExists(order) {
-		return nil, errors.New("Invalid customer: " + order.Customer)
+	exists, err := s.customerExists(ctx, order)
+	if err != nil {
+		return entities.Order{}, err
+	}
+	if !exists {
+		return entities.Order{}, service.ValidationFailed(service.FieldError{Field: "customer", Message: "doesn't exist"})
 	}
 
-	s.Logger.Info("Received request to create new order", "customer", order.Customer)
+	// the prices of a new order are always taken from the model catalogue, even if the client sent prices
+	order.LineItems = orderLineItems(order)
+	order.Items = nil
+	for i := range order.LineItems {
+		order.LineItems[i].UnitPrice = 0
+		order.LineItems[i].Total = 0
+	}
 
-	// create a new database entry
-	order.ObjectID, err = s.Storage.CreateOrder(order)
+	// fetch model and part ids before the order is stored, so orders with unknown models are rejected
+	items, err := s.fetchModelAndParts(ctx, order.LineItems)
 	if err != nil {
-		return nil, err
+		return entities.Order{}, err
+	}
+
+	// store the prices of the catalogue with the order
+	order.Total = 0
+	for i, item := range items {
+		order.LineItems[i].UnitPrice = item.UnitPrice
+		order.LineItems[i].Total = item.Total
+		order.Total += item.Total
 	}
 
-	// fetch model and part ids
-	items, err := s.fetchModelAndParts(order.Items)
+	// create a new database entry
+	order.ObjectID, err = s.StorageFor(ctx).CreateOrder(order)
 	if err != nil {
-		return nil, err
+		return entities.Order{}, err
 	}
+	s.recordStatus(ctx, order, "")
 
 	// prepare a message for the delegation service
 	orderMsg := rbmq.OrderMessage{
 		Timestamp: time.Now().UTC(),
 		OrderID:   order.ObjectID,
 		Customer:  order.Customer,
-		MsgType:   "delegate",
+		MsgType:   rbmq.TypeDelegate,
 		Items:     items,
 	}
 
 	s.Logger.Infow("Created new order", "customer", order.Customer, "order", order.ObjectID)
 
-	// delegate the order
-	err = s.delegateOrder(orderMsg)
-	if err != nil {
-		return nil, err
-	}
-
-	// return a response for the http request
-	responseBody, err := json.Marshal(order)
+	// delegate the order, if the broker didn't accept it the order is marked as failed
+	err = s.delegateOrder(ctx, orderMsg)
 	if err != nil {
-		return nil, err
+		order.Status = StatusFailed
+		order.Reason = "The order couldn't be forwarded to the delegation service"
+		order.LastUpdate = time.Now().UTC()
+		order.Deadline = time.Time{}
+		updated, updateErr := s.StorageFor(ctx).UpdateOrderStatus(order, StatusProcessing)
+		if updateErr != nil {
+			s.Logger.Errorw("Failed to mark order as failed", "order", order.ObjectID, "err", updateErr)
+		} else if updated {
+			s.recordStatus(ctx, order, order.Reason)
+			s.notify(ctx, order)
+		}
+		return entities.Order{}, err
 	}
 
-	return responseBody, err
+	return order, nil
 }
 
 // delegateOrder forwards an order to the delegation service
-func (s *Service) delegateOrder(orderMsg rbmq.OrderMessage) error {
+func (s *Service) delegateOrder(ctx context.Context, orderMsg rbmq.OrderMessage) error {
 
 	// encode the message
 	msgBody, err := json.Marshal(orderMsg)
@@ -166,8 +227,8 @@ func (s *Service) delegateOrder(orderMsg rbmq.OrderMessage) error {
 		return err
 	}
 
-	// publish the message to ther service
-	err = s.Producer["london"].Publish(msgBody, "delegation")
+	// publish the message to ther service, all messages caused by it share the order id as correlation id
+	err = s.Producer[s.Topology.Headquarter].Publish(rbmq.NewEnvelope(rbmq.TypeDelegate, orderMsg.OrderID).WithTrace(ctx), msgBody, "delegation")
 	if err != nil {
 		return err
 	}
@@ -177,36 +238,171 @@ func (s *Service) delegateOrder(orderMsg rbmq.OrderMessage) error {
 	return nil
 }
 
-// updateOrder updates the status of a single order
-func (s *Service) updateOrder(msg rbmq.OrderMessage) {
-	// initialize an entity and fill it with the updated information
-	order := entities.Order{
-		ObjectID:   msg.OrderID,
-		LastUpdate: time.Now().UTC(),
-		Status:     msg.Status,
+// updateOrder updates the status of a single order and sets the deadline of its next step
+// Updates that would move an order back to an earlier step or change a finished order are rejected
+func (s *Service) updateOrder(ctx context.Context, msg rbmq.OrderMessage, source string) error {
+	return retryOnConflict(msg.OrderID, func() (bool, error) {
+		return s.applyUpdate(ctx, msg, source)
+	})
+}
+
+// applyUpdate applies an order update to the current copy of the order
+// It returns false if the order changed before the update was stored
+func (s *Service) applyUpdate(ctx context.Context, msg rbmq.OrderMessage, source string) (bool, error) {
+	order, err := s.StorageFor(ctx).FindOrder(msg.OrderID)
+	if err != nil {
+		s.Logger.Errorw("Failed to find order", "id", msg.OrderID, "err", err)
+		return false, err
+	}
+	previous := order.Status
+
+	// the progress of a cancelling order is only recorded, its status is decided by the outcome of the cancellation
+	if order.Status == StatusCancelling && !canTransition(order.Status, msg.Status) {
+		s.recordStatusFrom(ctx, entities.Order{
+			ObjectID:   order.ObjectID,
+			Customer:   order.Customer,
+			Status:     msg.Status,
+			LastUpdate: time.Now().UTC(),
+			Location:   msg.Location,
+		}, source, "Reached while the order is being cancelled")
+		return true, nil
+	}
+
+	// updates with an unknown status are malformed, retrying them can't help
+	if step(msg.Status) == -1 && msg.Status != StatusFailed && msg.Status != StatusCancelled && msg.Status != StatusCancelling {
+		return false, rbmq.Permanent(fmt.Errorf("Unknown order status %s", msg.Status))
+	}
+
+	// stale and duplicate updates, e.g. redelivered messages or repeated notifications of a factory,
+	// are recorded in the history and acknowledged since they can never be applied
+	if !canTransition(order.Status, msg.Status) {
+		s.Logger.Warnw("Ignoring order update", "order", msg.OrderID, "status", order.Status, "update", msg.Status, "source", source)
+		s.recordRejected(ctx, order, msg, source)
+		return true, nil
+	}
+
+	// fill the entity with the updated information
+	now := time.Now().UTC()
+	order.Status = msg.Status
+	order.LastUpdate = now
+	order.Deadline = s.deadline(msg.Status, now)
+	if msg.Location != "" {
+		order.Location = msg.Location
 	}
 
-	// write the updates to the database
-	err := s.Storage.UpdateOrderStatus(order)
+	// write the updates to the database, unless the order changed since it was read
+	updated, err := s.StorageFor(ctx).UpdateOrderStatus(order, previous)
 	if err != nil {
 		s.Logger.Errorw("Failed to update order", "id", msg.OrderID, "err", err)
+		return false, err
 	}
+	if !updated {
+		return false, nil
+	}
+
+	s.recordStatusFrom(ctx, order, source, "")
+	s.notify(ctx, order)
+	return true, nil
+}
+
+// restoreStatus gives an order its previous status back after the new status couldn't be forwarded to other services
+// The order is left alone if it changed again in the meantime
+func (s *Service) restoreStatus(ctx context.Context, previous entities.Order, status string) {
+	restored, err := s.StorageFor(ctx).UpdateOrderStatus(previous, status)
+	if err != nil {
+		s.Logger.Errorw("Failed to restore order status", "order", previous.ObjectID, "status", previous.Status, "err", err)
+		return
+	}
+	if !restored {
+		s.Logger.Warnw("Order changed before its status was restored", "order", previous.ObjectID, "status", previous.Status)
+	}
+}
+
+// retryOnConflict runs an update of an order again while the order changed between reading and storing it
+// The update has to read the order on every call, it returns false if the order changed before it was stored
+func retryOnConflict(id string, update func() (bool, error)) error {
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		done, err := update()
+		if err != nil || done {
+			return err
+		}
+	}
+
+	// the message is retried later
+	return fmt.Errorf("Order %s changed while it was being updated", id)
+}
+
+// recordStatus adds the current status of an order to its history with the order service as source
+func (s *Service) recordStatus(ctx context.Context, order entities.Order, reason string) {
+	s.recordStatusFrom(ctx, order, s.Config.Rbmq.ServiceName, reason)
+}
+
+// recordStatusFrom adds the current status of an order to its history and pushes it to the open streams
+// The status itself is already stored at this point, so a failure is only logged
+func (s *Service) recordStatusFrom(ctx context.Context, order entities.Order, source string, reason string) {
+	change := entities.StatusChange{
+		OrderID:   order.ObjectID,
+		Status:    order.Status,
+		Timestamp: order.LastUpdate,
+		Location:  order.Location,
+		Source:    source,
+		Reason:    reason,
+	}
+
+	var err error
+	change.ObjectID, err = s.StorageFor(ctx).AddStatusChange(change)
+	if err != nil {
+		s.Logger.Errorw("Failed to record status change", "order", order.ObjectID, "status", order.Status, "err", err)
+	}
+
+	s.streams.publish(statusEvent{StatusChange: change, Customer: order.Customer})
+}
+
+// recordRejected adds an update that couldn't be applied to the history of an order
+// The status of the order didn't change, so the update isn't pushed to the open streams
+func (s *Service) recordRejected(ctx context.Context, order entities.Order, msg rbmq.OrderMessage, source string) {
+	_, err := s.StorageFor(ctx).AddStatusChange(entities.StatusChange{
+		OrderID:   order.ObjectID,
+		Status:    msg.Status,
+		Timestamp: time.Now().UTC(),
+		Location:  msg.Location,
+		Source:    source,
+		Reason:    fmt.Sprintf("Rejected, the order can't change from %s to %s", order.Status, msg.Status),
+	})
+	if err != nil {
+		s.Logger.Errorw("Failed to record rejected update", "order", order.ObjectID, "status", msg.Status, "err", err)
+	}
+}
+
+// Shutdown closes the open streams before the service library shuts down the http server,
+// which would otherwise wait for the streams until the shutdown times out
+func (s *Service) Shutdown(ctx context.Context) []error {
+	s.streams.close()
+	return s.Service.Shutdown(ctx)
 }
 
 // customerExists sends a http request to the customer service's rest api to check if a customer exists
-func (s *Service) customerExists(order entities.Order) bool {
+// An error is returned if the customer service couldn't answer the question
+func (s *Service) customerExists(ctx context.Context, order entities.Order) (bool, error) {
 	// send a get request to the service
-	resp, err := http.Get(fmt.Sprintf("%s/%s", customerServiceURL, order.Customer))
+	resp, err := tracing.Get(ctx, fmt.Sprintf("%s/%s", s.customerURL, order.Customer))
 	if err != nil {
 		s.Logger.Errorw("Failed to fetch customer", "err", err)
-		return false
+		return false, service.NewAPIError(http.StatusServiceUnavailable, service.CodeUnavailable, "The customer service is unavailable")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("The customer service answered with %s", resp.Status)
 	}
 
 	// read the response
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		s.Logger.Errorw("Failed to read response body", "err", err)
-		return false
+		return false, err
 	}
 
 	var customer entities.Customer
@@ -214,44 +410,94 @@ func (s *Service) customerExists(order entities.Order) bool {
 	// decode the response
 	err = json.Unmarshal(body, &customer)
 	if err != nil {
-		s.Logger.Errorw("Failed to parse body to json", "err", err)
-		return false
+		return false, err
 	}
 
 	// return true if the customer exists
-	return customer.ObjectID != ""
+	return customer.ObjectID != "", nil
 }
 
-// dummy function to simulate the model service
-func (s *Service) fetchModelAndParts(items []int) ([]rbmq.Item, error) {
+// fetchModelAndParts fetches the parts and assembly time of every line item from the model service
+// Line items that already have a unit price keep it, all others get the current price of the model
+// Line items whose model doesn't exist are reported together as a validation error
+func (s *Service) fetchModelAndParts(ctx context.Context, lineItems []entities.LineItem) ([]rbmq.Item, error) {
 	var rbmqItems []rbmq.Item
-	for _, item := range items {
-		resp, err := http.Get(fmt.Sprintf("%s/%v", modelServiceURL, item))
+	var unknown []service.FieldError
+	for i, lineItem := range lineItems {
+		model, found, err := s.fetchModel(ctx, lineItem.Model)
 		if err != nil {
 			return rbmqItems, err
 		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return rbmqItems, err
+		if !found {
+			unknown = append(unknown, service.FieldError{
+				Field:   fmt.Sprintf("lineItems[%d].model", i),
+				Message: fmt.Sprintf("model %d doesn't exist", lineItem.Model),
+			})
+			continue
 		}
 
-		model := entities.Model{}
-		json.Unmarshal(body, &model)
-
 		var parts []int
+		partsCost := 0
 
 		for _, part := range model.Parts {
 			parts = append(parts, part.ID)
+			partsCost += part.Price
+		}
+
+		unitPrice := lineItem.UnitPrice
+		if unitPrice == 0 {
+			unitPrice = model.Price
+		}
+		if unitPrice <= 0 {
+			return rbmqItems, fmt.Errorf("Model %d has no price", model.ID)
 		}
 
 		rbmqItem := rbmq.Item{
 			ItemID:       model.ID,
 			Parts:        parts,
 			AssemblyTime: model.AssemblyTime,
+			PartsCost:    partsCost,
+			Quantity:     lineItem.Quantity,
+			UnitPrice:    unitPrice,
+			Total:        unitPrice * lineItem.Quantity,
 		}
 
 		rbmqItems = append(rbmqItems, rbmqItem)
 	}
+
+	if len(unknown) > 0 {
+		return rbmqItems, service.ValidationFailed(unknown...)
+	}
 	return rbmqItems, nil
 }
+
+// fetchModel fetches a single model from the model service, false is returned if the model doesn't exist
+func (s *Service) fetchModel(ctx context.Context, id int) (entities.Model, bool, error) {
+	model := entities.Model{}
+
+	resp, err := tracing.Get(ctx, fmt.Sprintf("%s/%v", s.modelURL, id))
+	if err != nil {
+		s.Logger.Errorw("Failed to fetch model", "model", id, "err", err)
+		return model, false, service.NewAPIError(http.StatusServiceUnavailable, service.CodeUnavailable, "The model service is unavailable")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return model, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return model, false, fmt.Errorf("The model service answered with %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return model, false, err
+	}
+
+	err = json.Unmarshal(body, &model)
+	if err != nil {
+		return model, false, err
+	}
+
+	return model, true, nil
+}
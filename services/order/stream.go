@@ -0,0 +1,232 @@
+package order
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// streamBuffer is the number of status changes a subscriber may fall behind before it is disconnected
+	streamBuffer = 32
+	// streamHeartbeat is the interval of the comments that keep idle streams open behind proxies
+	streamHeartbeat = 15 * time.Second
+)
+
+// statusEvent is a status change pushed to the subscribers of an order stream
+type statusEvent struct {
+	entities.StatusChange
+	Customer string `json:"customer"`
+}
+
+// subscriber receives the status changes of a single order or of all orders of a customer
+type subscriber struct {
+	orderID  string
+	customer string
+	events   chan statusEvent
+}
+
+// done checks whether a stream ends after an event, a single order doesn't change anymore once it is finished
+func (sub *subscriber) done(event statusEvent) bool {
+	return sub.orderID != "" && isFinished(event.Status)
+}
+
+// matches checks whether a subscriber is interested in a status change
+func (sub *subscriber) matches(event statusEvent) bool {
+	if sub.orderID != "" {
+		return sub.orderID == event.OrderID
+	}
+	return sub.customer == event.Customer
+}
+
+// broker distributes the status changes recorded by this instance to the connected streams
+// Status changes are only pushed and never stored, a client that reconnects has to fetch the order again
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+// newBroker creates a broker without subscribers
+func newBroker() *broker {
+	return &broker{subscribers: make(map[*subscriber]struct{})}
+}
+
+// subscribe registers a new subscriber, nil is returned if the broker was already closed
+func (b *broker) subscribe(orderID string, customer string) *subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	sub := &subscriber{orderID: orderID, customer: customer, events: make(chan statusEvent, streamBuffer)}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber and closes its channel, removing it twice does nothing
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends a status change to all interested subscribers
+// Publishing never blocks the message handlers, subscribers that fell too far behind are disconnected instead
+func (b *broker) publish(event statusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.matches(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// close disconnects all subscribers and rejects new ones, the http server can't shut down while streams are open
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// getOrderStream is the rest handler that pushes the status changes of a single order as server-sent events
+// The current status is sent first, the stream ends once the order is finished
+func (s *Service) getOrderStream(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	order, err := s.StorageFor(r.Context()).FindOrder(id)
+	if err != nil {
+		s.HandleAPIError(w, r, "Failed to fetch order", err)
+		return
+	}
+
+	// subscribe before the current status is sent, so no status change gets lost in between
+	sub := s.streams.subscribe(order.ObjectID, "")
+	current := statusEvent{
+		StatusChange: entities.StatusChange{
+			OrderID:   order.ObjectID,
+			Status:    order.Status,
+			Timestamp: order.LastUpdate,
+			Location:  order.Location,
+			Reason:    order.Reason,
+		},
+		Customer: order.Customer,
+	}
+
+	s.stream(w, r, sub, &current)
+}
+
+// getCustomerStream is the rest handler that pushes the status changes of all orders of a customer
+// as server-sent events, the customer is selected by the query parameter customer
+func (s *Service) getCustomerStream(w http.ResponseWriter, r *http.Request) {
+	customer := r.URL.Query().Get("customer")
+	if customer == "" {
+		apiErr := service.NewAPIError(http.StatusBadRequest, service.CodeInvalidParameter, "Missing query parameter customer")
+		apiErr.Fields = []service.FieldError{{Field: "customer", Message: "is required"}}
+		s.HandleAPIError(w, r, "Invalid stream request", apiErr)
+		return
+	}
+
+	s.stream(w, r, s.streams.subscribe("", customer), nil)
+}
+
+// stream writes the events of a subscriber to the client until the client disconnects
+// An initial event is written before any other event if it isn't nil
+func (s *Service) stream(w http.ResponseWriter, r *http.Request, sub *subscriber, initial *statusEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.HandleAPIError(w, r, "Streaming isn't supported", fmt.Errorf("Response writer doesn't implement http.Flusher"))
+		return
+	}
+	if sub == nil {
+		s.HandleAPIError(w, r, "The service is shutting down",
+			service.NewAPIError(http.StatusServiceUnavailable, service.CodeUnavailable, "The service is shutting down"))
+		return
+	}
+	defer s.streams.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disables the response buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.Logger.Infow("Opened order stream", "order", sub.orderID, "customer", sub.customer)
+	defer s.Logger.Infow("Closed order stream", "order", sub.orderID, "customer", sub.customer)
+
+	if initial != nil {
+		if err := writeEvent(w, *initial); err != nil || sub.done(*initial) {
+			return
+		}
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.events:
+			if !ok {
+				// the subscriber fell behind or the service shuts down, the client has to reconnect
+				return
+			}
+
+			if err := writeEvent(w, event); err != nil {
+				s.Logger.Infow("Failed to write status event", "order", event.OrderID, "err", err)
+				return
+			}
+			flusher.Flush()
+
+			if sub.done(event) {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a status change as server-sent event
+func writeEvent(w http.ResponseWriter, event statusEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", body)
+	return err
+}
@@ -0,0 +1,89 @@
+package order
+
+import (
+	"fmt"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+)
+
+const (
+	// maxLineItemQuantity is the largest number of fridges of a single model within an order
+	maxLineItemQuantity = 100
+	// maxOrderQuantity is the largest number of fridges within an order, the parts of every fridge are ordered
+	// one after another, so large orders block the workers of the part service for a long time
+	maxOrderQuantity = 500
+)
+
+// validateOrder checks the fields of a new order that can be checked without asking other services
+// Whether the customer and the models exist is checked afterwards by the customer and model service
+func validateOrder(order entities.Order) []service.FieldError {
+	var fields []service.FieldError
+
+	if order.Customer == "" {
+		fields = append(fields, service.FieldError{Field: "customer", Message: "must not be empty"})
+	}
+
+	switch {
+	case len(order.Items) > 0 && len(order.LineItems) > 0:
+		fields = append(fields, service.FieldError{Field: "items", Message: "must not be combined with lineItems"})
+	case len(order.Items) == 0 && len(order.LineItems) == 0:
+		fields = append(fields, service.FieldError{Field: "lineItems", Message: "must contain at least one model"})
+	}
+
+	for i, item := range order.Items {
+		if item <= 0 {
+			fields = append(fields, service.FieldError{Field: fmt.Sprintf("items[%d]", i), Message: "must be a positive model id"})
+		}
+	}
+	if len(order.Items) > maxOrderQuantity {
+		fields = append(fields, service.FieldError{Field: "items", Message: fmt.Sprintf("must not contain more than %d models", maxOrderQuantity)})
+	}
+
+	// the quantities are summed up with an upper bound, so the sum can't overflow
+	quantity := 0
+	for i, lineItem := range order.LineItems {
+		if lineItem.Model <= 0 {
+			fields = append(fields, service.FieldError{Field: fmt.Sprintf("lineItems[%d].model", i), Message: "must be a positive model id"})
+		}
+		switch {
+		case lineItem.Quantity <= 0:
+			fields = append(fields, service.FieldError{Field: fmt.Sprintf("lineItems[%d].quantity", i), Message: "must be positive"})
+		case lineItem.Quantity > maxLineItemQuantity:
+			fields = append(fields, service.FieldError{
+				Field:   fmt.Sprintf("lineItems[%d].quantity", i),
+				Message: fmt.Sprintf("must not be greater than %d", maxLineItemQuantity),
+			})
+		default:
+			quantity += lineItem.Quantity
+		}
+	}
+	if quantity > maxOrderQuantity {
+		fields = append(fields, service.FieldError{Field: "lineItems", Message: fmt.Sprintf("must not contain more than %d fridges in total", maxOrderQuantity)})
+	}
+
+	return fields
+}
+
+// orderLineItems returns the line items of an order
+// Orders of older clients only contain a flat list of model ids, every model in it becomes a line item
+// whose quantity is the number of times the model id occurs
+func orderLineItems(order entities.Order) []entities.LineItem {
+	if len(order.LineItems) > 0 || len(order.Items) == 0 {
+		return order.LineItems
+	}
+
+	var lineItems []entities.LineItem
+	positions := make(map[int]int)
+	for _, model := range order.Items {
+		position, ok := positions[model]
+		if !ok {
+			positions[model] = len(lineItems)
+			lineItems = append(lineItems, entities.LineItem{Model: model, Quantity: 1})
+			continue
+		}
+		lineItems[position].Quantity++
+	}
+
+	return lineItems
+}
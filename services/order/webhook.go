@@ -0,0 +1,33 @@
+package order
+
+import (
+	"context"
+
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+)
+
+const (
+	// EventOrderShipped is sent to webhooks once an order was shipped to the customer
+	EventOrderShipped = "order.shipped"
+	// EventOrderFailed is sent to webhooks once an order couldn't be finished
+	EventOrderFailed = "order.failed"
+	// EventOrderCancelled is sent to webhooks once the cancellation of an order was confirmed
+	EventOrderCancelled = "order.cancelled"
+)
+
+// notify sends the order to the webhooks subscribed to its new status, only finished orders are sent
+func (s *Service) notify(ctx context.Context, order entities.Order) {
+	var event string
+	switch order.Status {
+	case StatusComplete:
+		event = EventOrderShipped
+	case StatusFailed:
+		event = EventOrderFailed
+	case StatusCancelled:
+		event = EventOrderCancelled
+	default:
+		return
+	}
+
+	s.webhooks.Publish(ctx, event, order)
+}
@@ -15,6 +15,9 @@ import (
 // Service is the instance wrapper
 type Service struct {
 	*service.Service
+
+	// inFlight contains the orders whose parts are being ordered
+	inFlight *service.InFlight
 }
 
 // New launches a new custom service based on the service library in /pkg/service
@@ -22,7 +25,7 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	var err error
 
 	// initialize a new service instance based on the config
-	partsService := &Service{}
+	partsService := &Service{inFlight: service.NewInFlight()}
 	partsService.Service, err = service.New(config, messages, logger)
 	if err != nil {
 		return nil, err
@@ -40,73 +43,19 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go partsService.handleRbmqMessage(messages)
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	// price updates originate from the model service, part orders, returns and cancellations from the factory service
+	partsService.Router.Handle(rbmq.TypeUpdatePart, rbmq.PartHandler(partsService.handlePartUpdate))
+	partsService.Router.Handle(rbmq.TypeOrderPart, rbmq.OrderHandler(partsService.handlePartOrder))
+	partsService.Router.Handle(rbmq.TypeReleaseParts, rbmq.OrderHandler(partsService.handleReleaseParts))
+	partsService.Router.HandleControl(rbmq.TypeCancelOrder, rbmq.OrderHandler(partsService.handleCancelOrder))
+	go partsService.Router.Run(messages)
 
 	return partsService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-
-	// this is a placeholder struct used to determine what to do with the message,
-	// because the part service can receive two potential message objects
-	type genericMessage struct {
-		MsgType string `json:"type,omitempty"`
-	}
-
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
-		recMsg := genericMessage{}
-
-		// decode the msg body
-		err := json.Unmarshal(msg.Body, &recMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
-		}
-
-		switch recMsg.MsgType {
-		// Message originates from model service with command to update price of certain part
-		case "updatepart":
-			err = s.handlePartUpdate(msg.Body)
-			if err != nil {
-				s.Logger.Errorw("Failed to update part", "err", err)
-				continue
-			}
-
-		// Message originates from factory service with command to order parts
-		case "orderpart":
-			// Order all parts of all items within received order
-			err = s.handlePartOrder(msg.Body)
-			if err != nil {
-				s.Logger.Errorw("Failed to order part", "err", err)
-				continue
-			}
-			// returns acknowledgement message when all parts are delivered
-		default:
-			s.Logger.Debugw("Unhandled message type", "type", recMsg.MsgType)
-		}
-	}
-}
-
-func (s *Service) handlePartOrder(msg []byte) error {
-	orderMsg := rbmq.OrderMessage{}
-	err := json.Unmarshal(msg, &orderMsg)
-	if err != nil {
-		return err
-	}
-	return s.processOrder(orderMsg)
-}
-
-func (s *Service) handlePartUpdate(msg []byte) error {
-	partMsg := rbmq.PartMessage{}
-
-	err := json.Unmarshal(msg, &partMsg)
-	if err != nil {
-		return err
-	}
-
+// handlePartUpdate updates the price of a part
+func (s *Service) handlePartUpdate(msg rbmq.Message, partMsg rbmq.PartMessage) error {
 	part := entities.Part{
 		ID:    partMsg.Part,
 		Price: partMsg.Price,
@@ -114,39 +63,54 @@ func (s *Service) handlePartUpdate(msg []byte) error {
 
 	s.Logger.Infow("Received part update", "part", part.ID)
 
-	err = s.Storage.UpdatePart(part)
+	err := s.StorageFor(msg.Context()).UpdatePart(part)
 	if err != nil {
-		return fmt.Errorf("Failed to update part %v", part.ID)
+		return fmt.Errorf("Failed to update part %v: %w", part.ID, err)
 	}
 
 	return nil
 }
 
-func (s *Service) processOrder(order rbmq.OrderMessage) error {
+// handlePartOrder orders all parts of all items within an order and notifies the factory when all parts are delivered
+func (s *Service) handlePartOrder(msg rbmq.Message, order rbmq.OrderMessage) error {
 	var combinedPrice int
 
 	s.Logger.Infow("Received part order", "order", order.OrderID)
 
-	for _, item := range order.Items {
-		s.Logger.Infow("Ordering parts", "order", order.OrderID, "item", item.ItemID)
-		for _, part := range item.Parts {
+	s.inFlight.Start(order.OrderID)
+	defer s.inFlight.Done(order.OrderID)
 
-			dbPart, err := s.Storage.FindPart(part)
-			if err != nil {
-				return err
+	ordered := 0
+	for _, item := range order.Items {
+		s.Logger.Infow("Ordering parts", "order", order.OrderID, "item", item.ItemID, "quantity", item.Units())
+
+		// the parts are ordered once for every fridge of the item
+		for unit := 0; unit < item.Units(); unit++ {
+			for _, part := range item.Parts {
+				// stop ordering parts once the order was cancelled, the parts ordered so far are sent back
+				if s.inFlight.Cancelled(order.OrderID) {
+					return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), order, true,
+						fmt.Sprintf("Stopped ordering parts after %d parts, they are sent back", ordered))
+				}
+
+				dbPart, err := s.StorageFor(msg.Context()).FindPart(part)
+				if err != nil {
+					return err
+				}
+
+				// sleep to simulate part delivery process
+				orderPart()
+
+				// Count up combined price of entire delivery
+				combinedPrice = combinedPrice + dbPart.Price
+				ordered++
 			}
-
-			// sleep to simulate part delivery process
-			orderPart()
-
-			// Count up combined price of entire delivery
-			combinedPrice = combinedPrice + dbPart.Price
 		}
 	}
 
 	order.CostsOfParts = combinedPrice
 	order.Timestamp = time.Now().UTC()
-	order.MsgType = "orderupdate"
+	order.MsgType = rbmq.TypeOrderUpdate
 	order.Status = "partsdelivered"
 
 	// marshal message struct back into rbmq message ([]byte)
@@ -155,11 +119,61 @@ func (s *Service) processOrder(order rbmq.OrderMessage) error {
 		return err
 	}
 
-	s.Producer[s.Config.Location].Publish(responseBody, "factory")
+	err = s.Producer[s.Config.Location].Publish(msg.Envelope.Follow(rbmq.TypeOrderUpdate), responseBody, "factory")
+	if err != nil {
+		return err
+	}
+
 	s.Logger.Infow("Ordered and received all parts", "order", order.OrderID, "price", combinedPrice)
 	return nil
 }
 
+// handleReleaseParts returns the parts of an aborted order to their suppliers
+func (s *Service) handleReleaseParts(msg rbmq.Message, order rbmq.OrderMessage) error {
+	s.Logger.Infow("Returning parts of aborted order", "order", order.OrderID, "reason", order.Reason)
+
+	// sleep to simulate sending the parts back
+	orderPart()
+
+	s.Logger.Infow("Returned all parts", "order", order.OrderID, "refund", order.CostsOfParts)
+	return nil
+}
+
+// handleCancelOrder stops ordering the parts of a cancelled order
+// Cancellations are control messages, they are handled while the parts of the same order are being ordered
+// If the parts of the order aren't being ordered, they were either delivered already or the order is still queued
+// and stops as soon as it is started
+func (s *Service) handleCancelOrder(msg rbmq.Message, order rbmq.OrderMessage) error {
+	s.Logger.Infow("Received order cancellation", "order", order.OrderID)
+
+	// the handler ordering the parts reports the cancellation once it stopped
+	if s.inFlight.Cancel(order.OrderID) {
+		return nil
+	}
+
+	return s.reportCancellation(msg.Envelope.Follow(rbmq.TypeCancelResult), order, false, "The parts of the order aren't being ordered")
+}
+
+// reportCancellation tells the factory whether the part service stopped its work on a cancelled order
+func (s *Service) reportCancellation(envelope rbmq.Envelope, order rbmq.OrderMessage, cancelled bool, reason string) error {
+	result := rbmq.OrderMessage{
+		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeCancelResult,
+		OrderID:   order.OrderID,
+		Stage:     "part",
+		Cancelled: cancelled,
+		Reason:    reason,
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Infow("Reporting cancellation", "order", order.OrderID, "cancelled", cancelled, "reason", reason)
+	return s.Producer[s.Config.Location].Publish(envelope, body, "factory")
+}
+
 func orderPart() {
 	waitTime := rand.Intn(500) + 500
 	time.Sleep(time.Duration(waitTime) * time.Millisecond)
@@ -1,29 +1,28 @@
 package shipping
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
 
-	//"net/http"
 	//"io/ioutil"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/tracing"
 
 	"go.uber.org/zap"
 )
 
-const (
-	customerServiceURL = "http://customer-service:8080"
-	orderServiceURL    = "http://order-service:8080"
-)
-
 // Service is the instance wrapper
 type Service struct {
 	*service.Service
+
+	customerURL string
+	orderURL    string
 }
 
 // New launches a new custom service based on the service library in /pkg/service
@@ -36,66 +35,79 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	go shippingService.handleRbmqMessage(messages)
+	// resolve the rest apis of the customer and order service
+	shippingService.customerURL, err = shippingService.Topology.ServiceURL("customer")
+	if err != nil {
+		return nil, err
+	}
+
+	shippingService.orderURL, err = shippingService.Topology.ServiceURL("order")
+	if err != nil {
+		return nil, err
+	}
+
+	shippingService.Router.Handle(rbmq.TypeOrderUpdate, rbmq.OrderHandler(shippingService.ship))
+	go shippingService.Router.Run(messages)
 
 	return shippingService, nil
 }
 
-/* handleRbmqMessage receives assembled order from factory service */
+/* ship receives assembled order from factory service */
 /* uses REST interface to get customer information and sends ack msg to factory service afetr shipping */
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	for msg := range messages {
+func (s *Service) ship(msg rbmq.Message, recMsg rbmq.OrderMessage) error {
+	customerID := recMsg.Customer
 
-		// unmarshal rmbq message ([]byte) into struct
-		recMsg := rbmq.OrderMessage{}
-		err := json.Unmarshal(msg.Body, &recMsg)
+	if recMsg.Customer == "" {
+		// HTTP GET request for order to find customer connected to order
+		order, err := s.getOrder(msg.Context(), recMsg.OrderID)
 		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err)
-			continue
-		}
-
-		customerID := recMsg.Customer
-
-		if recMsg.Customer == "" {
-			// HTTP GET request for order to find customer connected to order
-			order, err := getOrder(recMsg.OrderID)
-			if err != nil {
-				s.Logger.Errorw("Failed to get order via HTTP GET", "err", err, "orderID", recMsg.OrderID)
-				continue
-			}
-			customerID = order.Customer
+			s.Logger.Errorw("Failed to get order via HTTP GET", "err", err, "orderID", recMsg.OrderID)
+			return err
 		}
+		customerID = order.Customer
+	}
 
-		// HTTP GET request for customer to get shipping address/information
-		customer, err := getCustomer(customerID)
-		if err != nil {
-			s.Logger.Errorw("Failed to get customer via HTTP GET", "err", err)
-			continue
-		}
+	// HTTP GET request for customer to get shipping address/information
+	customer, err := s.getCustomer(msg.Context(), customerID)
+	if err != nil {
+		s.Logger.Errorw("Failed to get customer via HTTP GET", "err", err)
+		return err
+	}
 
-		s.Logger.Infow("Received shipping request", "order", recMsg.OrderID)
+	s.Logger.Infow("Received shipping request", "order", recMsg.OrderID)
 
-		// Notify order service that order has been shipped
-		response, err := shipmentAck(recMsg, customer)
-		if err != nil {
-			s.Logger.Errorw("Failed to ship message", "err", err)
-			return
-		}
-		s.Producer[s.Config.Location].Publish(response, "factory")
+	// Notify order service that order has been shipped
+	response, err := shipmentAck(recMsg, customer)
+	if err != nil {
+		s.Logger.Errorw("Failed to ship message", "err", err)
+		return err
+	}
 
-		s.Logger.Infow("Order shipped", "order", recMsg.OrderID, "customer", customer.ObjectID)
+	err = s.Producer[s.Config.Location].Publish(msg.Envelope.Follow(rbmq.TypeOrderUpdate), response, "factory")
+	if err != nil {
+		s.Logger.Errorw("Failed to send message to factory service", "err", err)
+		return err
 	}
+
+	s.Logger.Infow("Order shipped", "order", recMsg.OrderID, "customer", customer.ObjectID)
+	return nil
 }
 
-func getOrder(id string) (entities.Order, error) {
+func (s *Service) getOrder(ctx context.Context, id string) (entities.Order, error) {
 	var order entities.Order
 	var err error
 
 	// HTTP Rest Get request to get customer from customer service
-	httpResponse, err := http.Get(fmt.Sprintf("%s/%s", orderServiceURL, id))
+	httpResponse, err := tracing.Get(ctx, fmt.Sprintf("%s/%s", s.orderURL, id))
 	if err != nil {
 		return order, err
 	}
+	defer httpResponse.Body.Close()
+
+	// failed requests are answered with an api error instead of the order
+	if httpResponse.StatusCode != http.StatusOK {
+		return order, fmt.Errorf("Order service responded with %s", httpResponse.Status)
+	}
 
 	byteResponse, err := ioutil.ReadAll(httpResponse.Body)
 	if err != nil {
@@ -107,15 +119,21 @@ func getOrder(id string) (entities.Order, error) {
 	return order, err
 }
 
-func getCustomer(id string) (entities.Customer, error) {
+func (s *Service) getCustomer(ctx context.Context, id string) (entities.Customer, error) {
 	var customer entities.Customer
 	var err error
 
 	// HTTP Rest Get request to get customer from customer service
-	httpResponse, err := http.Get(fmt.Sprintf("%s/%s", customerServiceURL, id))
+	httpResponse, err := tracing.Get(ctx, fmt.Sprintf("%s/%s", s.customerURL, id))
 	if err != nil {
 		return customer, err
 	}
+	defer httpResponse.Body.Close()
+
+	// failed requests are answered with an api error instead of the customer
+	if httpResponse.StatusCode != http.StatusOK {
+		return customer, fmt.Errorf("Customer service responded with %s", httpResponse.Status)
+	}
 
 	byteResponse, err := ioutil.ReadAll(httpResponse.Body)
 	if err != nil {
@@ -131,7 +149,7 @@ func shipmentAck(recMsg rbmq.OrderMessage, customer entities.Customer) ([]byte,
 
 	response := rbmq.OrderMessage{
 		Timestamp: time.Now().UTC(),
-		MsgType:   "orderupdate",
+		MsgType:   rbmq.TypeOrderUpdate,
 		Status:    "shipped",
 		OrderID:   recMsg.OrderID,
 	}
@@ -9,8 +9,6 @@ import (
 	"go.uber.org/zap"
 )
 
-var additionalProducers = [...]string{"london"}
-
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
@@ -28,55 +26,41 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	}
 
 	// add additional producers to send messages to the headquarter
-	for _, location := range additionalProducers {
-		producer, err := supportService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
-
-		supportService.Producer[location] = producer
+	err = supportService.AddProducers(supportService.Topology.Headquarter)
+	if err != nil {
+		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go supportService.handleRbmqMessage(messages)
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	supportService.Router.Handle(rbmq.TypeNewTicket, rbmq.TicketHandler(supportService.handleTicket))
+	go supportService.Router.Run(messages)
 
 	return supportService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
-		ticketMsg := rbmq.TicketMessage{}
-
-		// decode the msg body
-		err := json.Unmarshal(msg.Body, &ticketMsg)
-		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
-		}
-
-		s.Logger.Infow("Received ticket", "ticket", ticketMsg.TicketID)
-
-		// resolve the ticket
-		err = s.resolve(ticketMsg.TicketID)
-		if err != nil {
-			s.Logger.Errorw("Failed to resolve ticket", "err", err)
-		}
-
-		s.Logger.Infow("Resolved ticket", "ticket", ticketMsg.TicketID)
+// handleTicket resolves a new ticket, the returned error decides whether the message is retried
+func (s *Service) handleTicket(msg rbmq.Message, ticketMsg rbmq.TicketMessage) error {
+	s.Logger.Infow("Received ticket", "ticket", ticketMsg.TicketID)
 
+	// resolve the ticket
+	err := s.resolve(msg.Envelope.Follow(rbmq.TypeResolve), ticketMsg.TicketID)
+	if err != nil {
+		s.Logger.Errorw("Failed to resolve ticket", "err", err)
+		return err
 	}
+
+	s.Logger.Infow("Resolved ticket", "ticket", ticketMsg.TicketID)
+	return nil
 }
 
 // resolve responds to a new ticket with a dummy response text
-func (s *Service) resolve(id string) error {
+func (s *Service) resolve(envelope rbmq.Envelope, id string) error {
 	time.Sleep(5 * time.Second)
 
 	// initialize the response message
 	msg := rbmq.TicketMessage{
 		Timestamp: time.Now().UTC(),
-		MsgType:   "resolve",
+		MsgType:   rbmq.TypeResolve,
 		TicketID:  id,
 		Response: `Lorem ipsum dolor sit amet, consectetur adipiscing elit. Cras vulputate cursus tincidunt. Integer tincidunt purus metus, vel finibus ex commodo sed
 		Quisque ornare dignissim sapien euismod malesuada. Cras ullamcorper mattis tempor. Pellentesque diam odio, posuere sed efficitur pharetra, sagittis sollicitudin
@@ -91,5 +75,5 @@ func (s *Service) resolve(id string) error {
 	}
 
 	// publish the message
-	return s.Producer["london"].Publish(msgBody, "ticket")
+	return s.Producer[s.Topology.Headquarter].Publish(envelope, msgBody, "ticket")
 }
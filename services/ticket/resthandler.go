@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/db/query"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
 	"github.com/go-chi/chi"
 )
 
@@ -12,13 +15,13 @@ import (
 func (s *Service) postTicket(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		s.handleAPIError("Failed to read request body", err, w)
+		s.HandleAPIError(w, r, "Failed to read request body", err)
 		return
 	}
 
-	response, err := s.prepareTicket(body)
+	response, err := s.prepareTicket(r.Context(), body)
 	if err != nil {
-		s.handleAPIError("Failed to create order", err, w)
+		s.HandleAPIError(w, r, "Failed to create order", err)
 		return
 	}
 
@@ -31,15 +34,15 @@ func (s *Service) getTicket(w http.ResponseWriter, r *http.Request) {
 
 	s.Logger.Infow("Received request to fetch ticket", "ticket", id)
 
-	ticket, err := s.Storage.FindTicket(id)
+	ticket, err := s.StorageFor(r.Context()).FindTicket(id)
 	if err != nil {
-		s.handleAPIError("Failed to find ticket", err, w)
+		s.HandleAPIError(w, r, "Failed to find ticket", err)
 		return
 	}
 
 	body, err := json.Marshal(ticket)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
@@ -48,25 +51,54 @@ func (s *Service) getTicket(w http.ResponseWriter, r *http.Request) {
 
 // getAllTickets returns a list of all tickets
 func (s *Service) getAllTickets(w http.ResponseWriter, r *http.Request) {
-	s.Logger.Info("Received request to fetch all tickets")
+	q, err := parseTicketQuery(r)
+	if err != nil {
+		s.HandleAPIError(w, r, "Invalid ticket query", err)
+		return
+	}
+
+	s.Logger.Infow("Received request to fetch tickets", "status", q.Status, "location", q.Location, "limit", q.Page.Limit,
+		"offset", q.Page.Offset)
 
-	tickets, err := s.Storage.AllTickets()
+	tickets, total, err := s.StorageFor(r.Context()).FindTickets(q)
 	if err != nil {
-		s.handleAPIError("Failed to fetch tickets", err, w)
+		s.HandleAPIError(w, r, "Failed to fetch tickets", err)
 		return
 	}
 
+	if tickets == nil {
+		tickets = []entities.Ticket{}
+	}
+
 	body, err := json.Marshal(tickets)
 	if err != nil {
-		s.handleAPIError("Failed to marshal response", err, w)
+		s.HandleAPIError(w, r, "Failed to marshal response", err)
 		return
 	}
 
+	service.WritePage(w, r, q.Page, total)
 	w.Write(body)
 }
 
-func (s *Service) handleAPIError(msg string, err error, w http.ResponseWriter) {
-	s.Logger.Errorw("msg", "err", err)
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(msg))
+// parseTicketQuery reads the filters, the sort order and the page of a ticket list request
+// By default the newest tickets are returned first
+func parseTicketQuery(r *http.Request) (query.Tickets, error) {
+	var err error
+	q := query.Tickets{
+		Status:   service.ParseList(r, "status"),
+		Location: r.URL.Query().Get("location"),
+	}
+
+	q.Created, err = service.ParseTimeRange(r, "created")
+	if err != nil {
+		return q, err
+	}
+
+	q.Sort, err = service.ParseSort(r, query.Sort{Field: query.SortCreated, Descending: true}, query.SortCreated, query.SortClosed)
+	if err != nil {
+		return q, err
+	}
+
+	q.Page, err = service.ParsePage(r)
+	return q, err
 }
@@ -1,26 +1,44 @@
 package ticket
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
 	"time"
 
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/entities"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/metrics"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/rbmq"
 	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/service"
+	"git.thm.de/verteilte-systeme-2020-efridge/gruppe-13/pkg/webhook"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 )
 
+const (
+	// EventTicketCreated is sent to webhooks once a ticket was forwarded to a support centre
+	EventTicketCreated = "ticket.created"
+	// EventTicketResolved is sent to webhooks once a support centre answered a ticket
+	EventTicketResolved = "ticket.resolved"
+)
+
+// Config contains the settings of the ticket service
+type Config struct {
+	// Webhook contains the retry policy of the webhooks
+	Webhook webhook.Config
+}
+
 // Service uses composition to expand the service library
 type Service struct {
 	*service.Service
-}
 
-var additionalProducers = [...]string{"india", "mexico"}
+	// webhooks notifies external systems about new and resolved tickets
+	webhooks *webhook.Dispatcher
+}
 
 // New launches a new custom service based on the service library in /pkg/service
-func New(config *service.Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
+func New(config *service.Config, ticketConfig Config, messages chan rbmq.Message, logger *zap.SugaredLogger) (*Service, error) {
 	var err error
 
 	// initialize a new service instance based on the config
@@ -31,13 +49,9 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	}
 
 	// add additional producers to send messages to the factories
-	for _, location := range additionalProducers {
-		producer, err := ticketService.Service.RbmqSession.NewProducer(location, config.Rbmq.ExchangeType)
-		if err != nil {
-			return nil, err
-		}
-
-		ticketService.Producer[location] = producer
+	err = ticketService.AddProducers(ticketService.Topology.SupportLocations()...)
+	if err != nil {
+		return nil, err
 	}
 
 	// initialize the database
@@ -46,8 +60,19 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 		return nil, err
 	}
 
-	// launch a new thread to handle incoming rabbitmq messages
-	go ticketService.handleRbmqMessage(messages)
+	// export the number of tickets that were still open when the service was started
+	err = ticketService.countOpenTickets()
+	if err != nil {
+		logger.Errorw("Failed to count open tickets", "err", err)
+	}
+
+	// webhooks can subscribe to new and resolved tickets
+	ticketService.webhooks = webhook.New(ticketService.Service, ticketConfig.Webhook, EventTicketCreated, EventTicketResolved)
+	go ticketService.webhooks.Run()
+
+	// register the message handlers and launch a new thread to handle incoming rabbitmq messages
+	ticketService.Router.Handle(rbmq.TypeResolve, rbmq.TicketHandler(ticketService.handleResolve))
+	go ticketService.Router.Run(messages)
 
 	// initialize a chi router and its handler functions
 	router := chi.NewRouter()
@@ -57,66 +82,62 @@ func New(config *service.Config, messages chan rbmq.Message, logger *zap.Sugared
 	router.Post("/", ticketService.postTicket)
 	router.Get("/", ticketService.getAllTickets)
 	router.Get("/{id}", ticketService.getTicket)
+	ticketService.webhooks.Mount(router)
 
 	go ticketService.InitAPI(router)
 
 	return ticketService, nil
 }
 
-// handleRbmqMessage handles incoming messages
-func (s *Service) handleRbmqMessage(messages <-chan rbmq.Message) {
-	// infinite loop iterating over an unbuffered channel that blocks until a new message is received
-	for msg := range messages {
-		ticketMsg := rbmq.TicketMessage{}
+// handleResolve closes a single resolved ticket, the returned error decides whether the message is retried
+func (s *Service) handleResolve(msg rbmq.Message, ticketMsg rbmq.TicketMessage) error {
+	s.Logger.Infow("Received ticket update", "ticket", ticketMsg.TicketID)
 
-		// decode the msg body
-		err := json.Unmarshal(msg.Body, &ticketMsg)
+	// check if ticket is open
+	if isOpen, err := s.ticketIsOpen(msg.Context(), ticketMsg.TicketID); !isOpen {
 		if err != nil {
-			s.Logger.Errorw("Failed to parse message", "err", err, "msg", string(msg.Body))
-			continue
-		}
-
-		// reject if the message type isn't resolve
-		if ticketMsg.MsgType != "resolve" {
-			s.Logger.Debugw("Unhandled message type", "type", ticketMsg.MsgType)
-			continue
+			s.Logger.Errorw("Cannot update ticket", "err", err)
+			return err
 		}
+		s.Logger.Errorw("Cannot update ticket, ticket is already closed", "id", ticketMsg.TicketID)
+		return nil
+	}
 
-		s.Logger.Infow("Received ticket update", "ticket", ticketMsg.TicketID)
+	// prepare a new ticket object
+	ticket := entities.Ticket{
+		ObjectID: ticketMsg.TicketID,
+		Status:   "closed",
+		Closed:   time.Now().UTC(),
+		Response: ticketMsg.Response,
+	}
 
-		// check if ticket is open
-		if isOpen, err := s.ticketIsOpen(ticketMsg.TicketID); !isOpen {
-			if err != nil {
-				s.Logger.Errorw("Cannot update ticket", "err", err)
-				continue
-			}
-			s.Logger.Errorw("Cannot update ticket, ticket is already closed", "id", ticketMsg.TicketID)
-			continue
-		}
+	// update the ticket
+	err := s.StorageFor(msg.Context()).UpdateTicket(ticket)
+	if err != nil {
+		s.Logger.Errorw("Failed to update ticket", "err", err)
+		return err
+	}
 
-		// prepare a new ticket object
-		ticket := entities.Ticket{
-			ObjectID: ticketMsg.TicketID,
-			Status:   "closed",
-			Closed:   time.Now().UTC(),
-			Response: ticketMsg.Response,
-		}
+	metrics.OpenTickets.Dec()
 
-		// update the ticket
-		err = s.Storage.UpdateTicket(ticket)
-		if err != nil {
-			s.Logger.Errorw("Failed to update ticket", "err", err)
-		}
+	// the webhooks get the complete ticket including the question of the customer
+	resolved, err := s.StorageFor(msg.Context()).FindTicket(ticket.ObjectID)
+	if err != nil {
+		s.Logger.Errorw("Failed to fetch resolved ticket, webhooks aren't notified", "ticket", ticket.ObjectID, "err", err)
+		return nil
 	}
+	s.webhooks.Publish(msg.Context(), EventTicketResolved, resolved)
+
+	return nil
 }
 
 // prepareTicket creates and stores a new ticket
-func (s *Service) prepareTicket(body []byte) ([]byte, error) {
+func (s *Service) prepareTicket(ctx context.Context, body []byte) ([]byte, error) {
 	s.Logger.Info("Received request to create new ticket")
 	// determine the target support location
 	location, err := s.currentSupportLocation()
 	if err != nil {
-		return nil, err
+		return nil, service.NewAPIError(http.StatusServiceUnavailable, service.CodeUnavailable, err.Error())
 	}
 
 	ticket := entities.Ticket{
@@ -128,23 +149,25 @@ func (s *Service) prepareTicket(body []byte) ([]byte, error) {
 	// decode the body
 	err = json.Unmarshal(body, &ticket)
 	if err != nil {
-		return nil, err
+		return nil, service.InvalidBody(err)
 	}
 
 	// create a new entry in the database
-	ticket.ObjectID, err = s.Storage.CreateTicket(ticket)
+	ticket.ObjectID, err = s.StorageFor(ctx).CreateTicket(ticket)
 	if err != nil {
 		return nil, err
 	}
 
 	s.Logger.Infow("Created new ticket", "ticket", ticket.ObjectID)
+	metrics.OpenTickets.Inc()
 
-	err = s.forwardTicket(ticket)
+	err = s.forwardTicket(ctx, ticket)
 	if err != nil {
 		return nil, err
 	}
 
 	s.Logger.Infow("Forwarded ticket to support center", "ticket", ticket.ObjectID, "location", ticket.Location)
+	s.webhooks.Publish(ctx, EventTicketCreated, ticket)
 
 	// encode the response
 	responseBody, err := json.Marshal(ticket)
@@ -156,9 +179,10 @@ func (s *Service) prepareTicket(body []byte) ([]byte, error) {
 }
 
 // forwardTicket sends the ticket to the support location
-func (s *Service) forwardTicket(ticket entities.Ticket) error {
+func (s *Service) forwardTicket(ctx context.Context, ticket entities.Ticket) error {
 	msg := rbmq.TicketMessage{
 		Timestamp: time.Now().UTC(),
+		MsgType:   rbmq.TypeNewTicket,
 		TicketID:  ticket.ObjectID,
 	}
 
@@ -167,36 +191,35 @@ func (s *Service) forwardTicket(ticket entities.Ticket) error {
 		return err
 	}
 
-	return s.Producer[ticket.Location].Publish(msgBody, "support")
+	return s.Producer[ticket.Location].Publish(rbmq.NewEnvelope(rbmq.TypeNewTicket, ticket.ObjectID).WithTrace(ctx), msgBody, "support")
 }
 
-// currentSupportLocation determines the target location based on the current time of day in india
+// currentSupportLocation determines the target location based on the current time of day and the opening hours of the support centres
 func (s *Service) currentSupportLocation() (string, error) {
-	// declare time window 6am to 8pm ist
-	limitLower, err := time.Parse(time.Kitchen, "12:30AM")
-	if err != nil {
-		return "", err
-	}
+	return s.Topology.SupportCentreAt(time.Now())
+}
 
-	limitUpper, err := time.Parse(time.Kitchen, "02:30PM")
+// countOpenTickets sets the open tickets metric to the number of open tickets in the database
+func (s *Service) countOpenTickets() error {
+	tickets, err := s.Storage.AllTickets()
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// get current time in india
-	currentTime := time.Now().UTC()
-
-	// check if the current time is within the time window
-	if currentTime.After(limitLower) && currentTime.Before(limitUpper) {
-		return "india", nil
+	open := 0
+	for _, ticket := range tickets {
+		if ticket.Status == "open" {
+			open++
+		}
 	}
 
-	return "mexico", nil
+	metrics.OpenTickets.Set(float64(open))
+	return nil
 }
 
 // ticketIsOpen returns true if a ticket is open
-func (s *Service) ticketIsOpen(id string) (bool, error) {
-	ticket, err := s.Storage.FindTicket(id)
+func (s *Service) ticketIsOpen(ctx context.Context, id string) (bool, error) {
+	ticket, err := s.StorageFor(ctx).FindTicket(id)
 	if err != nil {
 		return false, err
 	}